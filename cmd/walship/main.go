@@ -2,11 +2,13 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
 	"runtime"
-	"runtime/debug"
 	"strings"
+	"syscall"
 
 	"github.com/spf13/cobra"
 	pflag "github.com/spf13/pflag"
@@ -46,10 +48,50 @@ var exampleUsage = strings.TrimSpace(`
 `)
 
 func getVersion() string {
-	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
-		return info.Main.Version
+	return agent.Version()
+}
+
+// loadConfig fills cfg from the config file (default
+// $HOME/.walship/config.toml), then WALSHIP_* variables, then the flags
+// set on cmd, and validates it.
+func loadConfig(cmd *cobra.Command, cfg *agent.Config, cfgPath string) error {
+	cfgFile := cfgPath
+	if cfgFile == "" {
+		cfgFile = agent.DefaultConfigPath()
+	}
+
+	// Build set of changed flags
+	changed := map[string]bool{}
+	cmd.Flags().Visit(func(f *pflag.Flag) { changed[f.Name] = true })
+	if changed["wal-node-index"] {
+		idx, err := cmd.Flags().GetInt("wal-node-index")
+		if err != nil {
+			return err
+		}
+		cfg.WALNodeIndex = &idx
+	}
+
+	if cfgFile != "" && agent.FileExists(cfgFile) {
+		fc, err := agent.LoadFileConfig(cfgFile)
+		if err != nil {
+			return fmt.Errorf("load config: %w", err)
+		}
+		if err := agent.ApplyFileConfig(cfg, fc, changed); err != nil {
+			return err
+		}
 	}
-	return "dev"
+
+	// Apply environment variables (WALSHIP_*)
+	// These override file config but are overridden by flags (checked via changed map)
+	agent.ApplyEnvConfig(cfg, changed)
+
+	// Load node info (ChainID, NodeID) from files if needed
+	if err := agent.LoadNodeInfo(cfg); err != nil {
+		return err
+	}
+
+	// Validate and set derived defaults
+	return cfg.Validate()
 }
 
 func main() {
@@ -65,84 +107,94 @@ func main() {
 		Example: exampleUsage,
 		Version: fmt.Sprintf("%s %s/%s", getVersion(), runtime.GOOS, runtime.GOARCH),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			// Load config file first (default $HOME/.walship/config.toml), then apply flag overrides
-			// Determine config path
-			cfgFile := cfgPath
-			if cfgFile == "" {
-				cfgFile = agent.DefaultConfigPath()
-			}
-
-			// Build set of changed flags
-			changed := map[string]bool{}
-			cmd.Flags().Visit(func(f *pflag.Flag) { changed[f.Name] = true })
-
-			if cfgFile != "" && agent.FileExists(cfgFile) {
-				fc, err := agent.LoadFileConfig(cfgFile)
-				if err != nil {
-					return fmt.Errorf("load config: %w", err)
-				}
-				if err := agent.ApplyFileConfig(&cfg, fc, changed); err != nil {
-					return err
-				}
-			}
-
-			// Apply environment variables (WALSHIP_*)
-			// These override file config but are overridden by flags (checked via changed map)
-			agent.ApplyEnvConfig(&cfg, changed)
-
-			// Load node info (ChainID, NodeID) from files if needed
-			if err := agent.LoadNodeInfo(&cfg); err != nil {
+			if err := loadConfig(cmd, &cfg, cfgPath); err != nil {
 				return err
 			}
 
-			// Validate and set derived defaults
-			if err := cfg.Validate(); err != nil {
-				return err
-			}
+			// Log configuration with secrets masked
+			log.Info().Interface("config", cfg.Redacted()).Msg("configuration")
 
-			// Log configuration (masking API key)
-			logCfg := cfg
-			if len(logCfg.AuthKey) > 0 {
-				logCfg.AuthKey = "*****"
+			cfg.OnIdle = func(ev agent.IdleEvent) {
+				log.Info().Time("since", ev.Since).Msg("caught up; no new frames to ship")
 			}
-			log.Info().Interface("config", logCfg).Msg("configuration")
 
-			if err := agent.Run(context.Background(), cfg); err != nil {
+			// Cancel on SIGINT/SIGTERM so the agent can flush its pending batch
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			if err := agent.Run(ctx, cfg); err != nil && !errors.Is(err, context.Canceled) {
 				return err
 			}
 			return nil
 		},
 	}
 
-	// Flags
-	root.Flags().StringVar(&cfgPath, "config", "", "path to config file (default: $HOME/.walship/config.toml)")
-	root.Flags().StringVar(&cfg.NodeHome, "node-home", "", "application home directory")
-	root.Flags().StringVar(&cfg.WALDir, "wal-dir", cfg.WALDir, "WAL directory containing .idx/.gz pairs")
+	root.AddCommand(newShipCmd(&cfg, &cfgPath))
+	root.AddCommand(newVerifyCmd(&cfg, &cfgPath))
+
+	// Flags, shared with subcommands
+	root.PersistentFlags().StringVar(&cfgPath, "config", "", "path to config file (default: $HOME/.walship/config.toml)")
+	root.PersistentFlags().StringVar(&cfg.NodeHome, "node-home", "", "application home directory")
+	root.PersistentFlags().StringVar(&cfg.WALDir, "wal-dir", cfg.WALDir, "WAL directory containing .idx/.gz pairs")
+	root.PersistentFlags().Int("wal-node-index", 0, "derive wal-dir as <node-home>/data/log.wal/node-<index> instead of from the node ID")
+	root.PersistentFlags().StringSliceVar(&cfg.ExtraWALDirs, "extra-wal-dir", cfg.ExtraWALDirs, "additional WAL directory shipped after wal-dir (repeatable)")
 
-	root.Flags().StringVar(&cfg.ServiceURL, "service-url", cfg.ServiceURL, fmt.Sprintf("base service URL (defaults to %s; override only for internal testing)", agent.DefaultServiceURL))
-	if err := root.Flags().MarkHidden("service-url"); err != nil {
+	root.PersistentFlags().StringVar(&cfg.ServiceURL, "service-url", cfg.ServiceURL, fmt.Sprintf("base service URL (defaults to %s; override only for internal testing)", agent.DefaultServiceURL))
+	if err := root.PersistentFlags().MarkHidden("service-url"); err != nil {
 		log.Info().Err(err).Msg("failed to hide service-url flag")
 	}
-	root.Flags().StringVar(&cfg.AuthKey, "auth-key", cfg.AuthKey, "API key for authentication")
-
-	root.Flags().DurationVar(&cfg.PollInterval, "poll", cfg.PollInterval, "poll interval when idle")
-	root.Flags().DurationVar(&cfg.SendInterval, "send-interval", cfg.SendInterval, "soft send interval")
-	root.Flags().DurationVar(&cfg.HardInterval, "hard-interval", cfg.HardInterval, "hard send interval (override gating)")
-	root.Flags().IntVar(&cfg.MaxBatchBytes, "max-batch-bytes", cfg.MaxBatchBytes, "maximum compressed bytes per batch")
-
-	root.Flags().Float64Var(&cfg.CPUThreshold, "cpu-threshold", cfg.CPUThreshold, "max CPU usage fraction before delaying send")
-	root.Flags().Float64Var(&cfg.NetThreshold, "net-threshold", cfg.NetThreshold, "max network usage fraction before delaying send")
-	root.Flags().StringVar(&cfg.Iface, "iface", cfg.Iface, "network interface to monitor (optional)")
-	root.Flags().IntVar(&cfg.IfaceSpeedMbps, "iface-speed", cfg.IfaceSpeedMbps, "interface speed in Mbps (used for utilization)")
-
-	root.Flags().StringVar(&cfg.StateDir, "state-dir", cfg.StateDir, "state directory for status.json (defaults to wal-dir)")
-	if err := root.Flags().MarkHidden("state-dir"); err != nil {
+	root.PersistentFlags().StringVar(&cfg.AuthKey, "auth-key", cfg.AuthKey, "API key for authentication")
+
+	root.PersistentFlags().DurationVar(&cfg.PollInterval, "poll", cfg.PollInterval, "poll interval when idle")
+	root.PersistentFlags().StringVar(&cfg.MinDay, "min-day", cfg.MinDay, "ignore WAL day directories before this date (YYYY-MM-DD)")
+	root.PersistentFlags().StringVar(&cfg.OnMissingState, "on-missing-state", cfg.OnMissingState, "when the saved index was deleted: fail, oldest or latest")
+	root.PersistentFlags().BoolVar(&cfg.WatchWAL, "watch-wal", cfg.WatchWAL, "wake on WAL file events instead of polling when idle")
+	root.PersistentFlags().DurationVar(&cfg.SendInterval, "send-interval", cfg.SendInterval, "soft send interval")
+	root.PersistentFlags().DurationVar(&cfg.HardInterval, "hard-interval", cfg.HardInterval, "hard send interval (override gating)")
+	root.PersistentFlags().IntVar(&cfg.MaxBatchBytes, "max-batch-bytes", cfg.MaxBatchBytes, "maximum compressed bytes per batch")
+	root.PersistentFlags().DurationVar(&cfg.MaxBatchTimeSpan, "max-batch-time-span", cfg.MaxBatchTimeSpan, "split batches whose frame timestamps span more than this (0 = disabled)")
+	root.PersistentFlags().IntVar(&cfg.MaxBufferedBytes, "max-buffered-bytes", cfg.MaxBufferedBytes, "stop reading ahead when this many unsent compressed bytes are pending (0 = unbounded)")
+	root.PersistentFlags().IntVar(&cfg.MaxFrameBytes, "max-frame-bytes", cfg.MaxFrameBytes, "skip frames larger than this many compressed bytes (0 = unlimited)")
+	root.PersistentFlags().IntVar(&cfg.ManifestGzipLevel, "manifest-gzip-level", cfg.ManifestGzipLevel, "gzip the upload manifest at this level, 1-9 (0 = uncompressed)")
+
+	root.PersistentFlags().Float64Var(&cfg.CPUThreshold, "cpu-threshold", cfg.CPUThreshold, "max CPU usage fraction before delaying send")
+	root.PersistentFlags().Float64Var(&cfg.NetThreshold, "net-threshold", cfg.NetThreshold, "max network usage fraction before delaying send")
+	root.PersistentFlags().Float64Var(&cfg.MaxSendsPerSecond, "max-sends-per-second", cfg.MaxSendsPerSecond, "cap on batch sends per second (0 = unlimited)")
+	root.PersistentFlags().StringVar(&cfg.Iface, "iface", cfg.Iface, "network interface to monitor (optional)")
+	root.PersistentFlags().IntVar(&cfg.IfaceSpeedMbps, "iface-speed", cfg.IfaceSpeedMbps, "interface speed in Mbps (used for utilization)")
+
+	root.PersistentFlags().StringVar(&cfg.StateDir, "state-dir", cfg.StateDir, "state directory for status.json (defaults to wal-dir)")
+	if err := root.PersistentFlags().MarkHidden("state-dir"); err != nil {
 		log.Info().Err(err).Msg("failed to hide state-dir flag")
 	}
-	root.Flags().DurationVar(&cfg.HTTPTimeout, "timeout", cfg.HTTPTimeout, "HTTP timeout")
-	root.Flags().BoolVar(&cfg.Verify, "verify", cfg.Verify, "verify CRC/line counts while reading (debug)")
-	root.Flags().BoolVar(&cfg.Meta, "meta", cfg.Meta, "print frame metadata to stderr (debug)")
-	root.Flags().BoolVar(&cfg.Once, "once", cfg.Once, "process available frames and exit")
+	root.PersistentFlags().DurationVar(&cfg.HTTPTimeout, "timeout", cfg.HTTPTimeout, "HTTP timeout")
+	root.PersistentFlags().StringVar(&cfg.ProxyURL, "proxy-url", cfg.ProxyURL, "send requests through this proxy (defaults to HTTP_PROXY/HTTPS_PROXY)")
+	root.PersistentFlags().StringToStringVar(&cfg.Labels, "label", cfg.Labels, "label sent with every upload as key=value (repeatable)")
+	root.PersistentFlags().StringVar(&cfg.UserAgent, "user-agent", cfg.UserAgent, "override the User-Agent header sent to the service")
+	root.PersistentFlags().DurationVar(&cfg.KeepAlive, "keep-alive", cfg.KeepAlive, "how long to keep an idle connection to the service open (0 uses net/http defaults)")
+	root.PersistentFlags().DurationVar(&cfg.BackoffInitial, "backoff-initial", cfg.BackoffInitial, "initial retry backoff after a failed send")
+	root.PersistentFlags().DurationVar(&cfg.BackoffMax, "backoff-max", cfg.BackoffMax, "maximum retry backoff after a failed send")
+	root.PersistentFlags().DurationVar(&cfg.DNSBackoff, "dns-backoff", cfg.DNSBackoff, "wait after a send fails to resolve the service host (0 uses the regular backoff)")
+	root.PersistentFlags().DurationVar(&cfg.MaxLifetime, "max-lifetime", cfg.MaxLifetime, "restart the agent in-process after this long, keeping its state (0 = never)")
+	root.PersistentFlags().DurationVar(&cfg.FlushTimeout, "flush-timeout", cfg.FlushTimeout, "time allowed to send the pending batch on shutdown")
+	root.PersistentFlags().DurationVar(&cfg.IdleThreshold, "idle-threshold", cfg.IdleThreshold, "log that the node is caught up after this long without new frames (0 disables)")
+	root.PersistentFlags().DurationVar(&cfg.HeartbeatInterval, "heartbeat-interval", cfg.HeartbeatInterval, "post a heartbeat when nothing has been sent for this long (0 disables)")
+	root.PersistentFlags().DurationVar(&cfg.SelfCheckInterval, "self-check-interval", cfg.SelfCheckInterval, "periodically check the tracked index offset against the reader position (0 disables)")
+	root.PersistentFlags().DurationVar(&cfg.StateSaveInterval, "state-save-interval", cfg.StateSaveInterval, "minimum interval between status.json writes (0 writes on every send)")
+	root.PersistentFlags().BoolVar(&cfg.Verify, "verify", cfg.Verify, "verify CRC/line counts while reading (debug)")
+	root.PersistentFlags().BoolVar(&cfg.StrictFrameBounds, "strict-frame-bounds", cfg.StrictFrameBounds, "stop if an index entry does not delimit exactly one gzip member")
+	root.PersistentFlags().BoolVar(&cfg.Meta, "meta", cfg.Meta, "print frame metadata to stderr (debug)")
+	root.PersistentFlags().BoolVar(&cfg.Once, "once", cfg.Once, "process available frames and exit")
+	root.PersistentFlags().StringVar(&cfg.AppConfigPath, "app-config-path", cfg.AppConfigPath, "app config file uploaded by the config watcher, relative to node-home (default config/app.toml)")
+	root.PersistentFlags().StringVar(&cfg.CometConfigPath, "comet-config-path", cfg.CometConfigPath, "CometBFT config file uploaded by the config watcher, relative to node-home (default config/config.toml)")
+	root.PersistentFlags().BoolVar(&cfg.CompressConfigUploads, "compress-config-uploads", cfg.CompressConfigUploads, "gzip the config files the config watcher uploads")
+	root.PersistentFlags().BoolVar(&cfg.WatchChainFiles, "watch-chain-files", cfg.WatchChainFiles, "upload genesis.json and upgrade-info.json when they change")
+	root.PersistentFlags().BoolVar(&cfg.SendUncompressed, "send-uncompressed", cfg.SendUncompressed, "decompress frames and send the raw bytes (more CPU and bandwidth)")
+	root.PersistentFlags().BoolVar(&cfg.SanitizeTimestamps, "sanitize-timestamps", cfg.SanitizeTimestamps, "clamp zero, future and backwards frame timestamps (bad node clock) before batching")
+	root.PersistentFlags().BoolVar(&cfg.AllowInsecure, "allow-insecure", cfg.AllowInsecure, "allow sending the auth key to a plaintext http:// service-url (local testing only)")
+	root.PersistentFlags().BoolVar(&cfg.ProbeBeforeRetry, "probe-before-retry", cfg.ProbeBeforeRetry, "after an upload times out, ask the service whether it was accepted before sending it again")
+	root.PersistentFlags().BoolVar(&cfg.SkipEmptyFrames, "skip-empty-frames", cfg.SkipEmptyFrames, "skip frames whose index line records no entries (recs 0)")
+	root.PersistentFlags().BoolVar(&cfg.LenientIndex, "lenient-index", cfg.LenientIndex, "accept index lines with missing offsets (legacy nodes)")
+	root.PersistentFlags().BoolVar(&cfg.StrictIndexFields, "strict-index-fields", cfg.StrictIndexFields, "reject index lines with unknown fields")
 
 	if err := root.Execute(); err != nil {
 		log.Error().Err(err).Msg("walship")
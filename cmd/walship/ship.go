@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/cobra"
+
+	agent "github.com/bft-labs/walship/internal/agent"
+)
+
+// newShipCmd returns the one-shot "ship" subcommand, which re-ships a
+// range of segments of one day without touching the streaming state.
+func newShipCmd(cfg *agent.Config, cfgPath *string) *cobra.Command {
+	var day, from, to string
+	cmd := &cobra.Command{
+		Use:   "ship",
+		Short: "Ship a range of WAL segments once, e.g. to re-ingest a bad window",
+		Example: strings.TrimSpace(`
+  walship ship --node-home ~/.mychain --day 2025-01-02 --from-segment seg-000010 --to-segment seg-000020
+`),
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fromN, err := parseSegment(from)
+			if err != nil {
+				return fmt.Errorf("--from-segment: %w", err)
+			}
+			toN, err := parseSegment(to)
+			if err != nil {
+				return fmt.Errorf("--to-segment: %w", err)
+			}
+			if err := loadConfig(cmd, cfg, *cfgPath); err != nil {
+				return err
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			n, err := agent.ShipRange(ctx, *cfg, day, fromN, toN)
+			log := agent.Logger()
+			log.Info().Int("frames", n).Str("day", day).Msg("ship finished")
+			return err
+		},
+	}
+	cmd.Flags().StringVar(&day, "day", "", "day directory to ship from (YYYY-MM-DD)")
+	cmd.Flags().StringVar(&from, "from-segment", "", "first segment to ship, e.g. seg-000010 or 10")
+	cmd.Flags().StringVar(&to, "to-segment", "", "last segment to ship (inclusive)")
+	for _, name := range []string{"day", "from-segment", "to-segment"} {
+		_ = cmd.MarkFlagRequired(name)
+	}
+	return cmd
+}
+
+// parseSegment accepts a segment as seg-000010, seg-000010.wal.idx or 10.
+func parseSegment(s string) (int, error) {
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "seg-"), ".wal.idx")
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid segment %q", s)
+	}
+	return n, nil
+}
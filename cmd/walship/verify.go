@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/cobra"
+
+	agent "github.com/bft-labs/walship/internal/agent"
+)
+
+// newVerifyCmd returns the "verify-wal" subcommand, which checks every
+// frame on disk without sending anything or touching the streaming state.
+func newVerifyCmd(cfg *agent.Config, cfgPath *string) *cobra.Command {
+	return &cobra.Command{
+		Use:   "verify-wal",
+		Short: "Decompress and CRC-check every WAL frame on disk without sending",
+		Example: strings.TrimSpace(`
+  walship verify-wal --node-home ~/.mychain
+  walship verify-wal --wal-dir /data/wal --extra-wal-dir /archive/wal
+`),
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := loadConfig(cmd, cfg, *cfgPath); err != nil {
+				return err
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			rep, err := agent.VerifyWAL(ctx, *cfg)
+			log := agent.Logger()
+			log.Info().
+				Int("frames_ok", rep.OK).
+				Int("frames_corrupt", rep.Corrupt).
+				Int64("bytes", rep.Bytes).
+				Msg("verify finished")
+			if err != nil {
+				return err
+			}
+			if rep.Corrupt > 0 {
+				return fmt.Errorf("%d corrupt frames", rep.Corrupt)
+			}
+			return nil
+		},
+	}
+}
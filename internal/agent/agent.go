@@ -3,32 +3,79 @@ package agent
 import (
 	"bytes"
 	"context"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"mime/multipart"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sync"
 	"time"
+
+	"github.com/bft-labs/walship/pkg/backoff"
 )
 
 const (
 	walFramesEndpoint = "/v1/ingest/wal-frames"
 	configEndpoint    = "/v1/ingest/config"
+	statusEndpoint    = walFramesEndpoint + "/status"
 )
 
+// manifestFrame is a manifest entry. Raw frames are sent decompressed and
+// RawLen is their length in the frames part.
+type manifestFrame struct {
+	FrameMeta
+	Raw    bool   `json:"raw,omitempty"`
+	RawLen uint64 `json:"raw_len,omitempty"`
+}
+
 type batchFrame struct {
 	Meta       FrameMeta
-	Compressed []byte
+	Compressed []byte // decompressed under Config.SendUncompressed
 	IdxLineLen int
 }
 
+// exceedsTimeSpan reports whether adding fm to batch would make it cover
+// more than span, measured between the first frame's FirstTS and fm's.
+// Frames without a timestamp never split a batch.
+func exceedsTimeSpan(batch []batchFrame, fm FrameMeta, span time.Duration) bool {
+	if span <= 0 || len(batch) == 0 {
+		return false
+	}
+	first := batch[0].Meta.FirstTS
+	if first == 0 || fm.FirstTS == 0 {
+		return false
+	}
+	return time.Duration(fm.FirstTS-first) > span
+}
+
 func Run(ctx context.Context, cfg Config) error {
+	if cfg.MaxLifetime > 0 {
+		return runWithLifetime(ctx, cfg)
+	}
+	return run(ctx, cfg, false, realClock{})
+}
+
+// Drain ships every frame currently on disk from the saved position,
+// following segment rotation and ExtraWALDirs, and returns once the last
+// one is confirmed. Cancelling ctx bounds it like Run: the pending batch
+// gets a final flush and ctx's error is returned. Use it to decommission a
+// node without leaving unshipped frames behind.
+func Drain(ctx context.Context, cfg Config) error {
+	return run(ctx, cfg, true, realClock{})
+}
+
+// run is Run and Drain with the clock that drives the send cadence:
+// SendInterval, HardInterval and the rate limiter and pacer waits.
+func run(ctx context.Context, cfg Config, drain bool, clk clock) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
+	// Background loops stop with ctx; none may outlive run
+	var bg sync.WaitGroup
 
 	if cfg.ServiceURL == "" {
 		return fmt.Errorf("service-url is required")
@@ -36,22 +83,53 @@ func Run(ctx context.Context, cfg Config) error {
 	if err := os.MkdirAll(cfg.StateDir, 0o700); err != nil {
 		return fmt.Errorf("state dir: %w", err)
 	}
+	if !cfg.DisableLock {
+		release, err := lockStateDir(cfg.StateDir)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
 
 	// Start config watcher for dynamic configuration updates
 	cfgPtr := &cfg
 	watcher := NewConfigWatcher(cfgPtr)
-	go watcher.Run(ctx)
-	go walCleanupLoop(ctx, cfg.WALDir, cfg.StateDir)
+	defer func() {
+		cancel()
+		bg.Wait()
+	}()
+	bg.Add(1)
+	go func() {
+		defer bg.Done()
+		watcher.Run(ctx)
+	}()
+	if cfg.WatchChainFiles {
+		bg.Add(1)
+		go func() {
+			defer bg.Done()
+			NewChainWatcher(cfgPtr).Run(ctx)
+		}()
+	}
+	startWALCleanup(ctx, &bg, realClock{}, cfg.walDirs(), cfg.StateDir)
 
 	// Load prior state; if none, start from the oldest index (first logs)
+	dirs := cfg.walDirs()
 	st, _ := loadState(cfg.StateDir)
 	if st.IdxPath == "" {
-		idxPath, err := oldestIndex(cfg.WALDir)
+		idxPath, dirIdx, err := firstIndexFrom(dirs, 0, cfg.MinDay)
 		if err != nil {
 			return err
 		}
 		st.IdxPath = idxPath
 		st.IdxOffset = 0
+		st.DirIndex = dirIdx
+		_ = saveState(cfg.StateDir, st)
+	} else if !FileExists(st.IdxPath) {
+		recovered, err := recoverMissingState(cfg, dirs, st)
+		if err != nil {
+			return err
+		}
+		st = recovered
 		_ = saveState(cfg.StateDir, st)
 	}
 
@@ -73,39 +151,203 @@ func Run(ctx context.Context, cfg Config) error {
 			gz = f
 		}
 	}
-	httpClient := &http.Client{Timeout: cfg.HTTPTimeout}
-	back := newBackoff(500*time.Millisecond, 10*time.Second)
+	httpClient := newHTTPClient(cfg.HTTPTimeout, &cfg)
+	back := newConfigBackoff(cfg)
+	pace := newPacer(minPaceDelay, maxPaceDelay)
+	saver := newStateSaver(cfg.StateDir, cfg.StateSaveInterval)
+	defer saver.Flush()
+	idle := newIdleTracker(cfg.IdleThreshold)
+	waiter := newWALWaiter(cfg.WatchWAL)
+	defer waiter.Close()
+	src := newSourceState(cfg.PollInterval)
+	limiter := newSendLimiter(cfg.MaxSendsPerSecond)
+	readError := func(path string, err error, fatal bool) {
+		if cfg.OnReadError != nil {
+			cfg.OnReadError(ReadErrorEvent{Path: path, Err: err, Fatal: fatal})
+		}
+	}
 
 	var (
 		batch      []batchFrame
 		batchBytes int
+		ss         sendState
 		lastSend   time.Time
+		lastCheck  = time.Now()
+		lastBeat   = time.Now()
+		authErr    error
+		skew       = tsSanitizer{enabled: cfg.SanitizeTimestamps}
+		// failed is set while the pending batch has an upload that failed;
+		// it is then retried unchanged so every attempt carries one key
+		failed bool
 	)
+	send := func() {
+		// Wait for a send token, but never past the hard interval
+		if limiter != nil && len(batch) > 0 {
+			d := limiter.Delay(clk.Now())
+			if left := cfg.HardInterval - clk.Now().Sub(lastSend); d > left {
+				d = left
+			}
+			if d > 0 {
+				select {
+				case <-ctx.Done():
+					return
+				case <-clk.After(d):
+				}
+			}
+		}
+		prevSendAt := st.LastSendAt
+		hard := clk.Now().Sub(lastSend) >= cfg.HardInterval
+		err := trySend(ctx, cfg, httpClient, &batch, &batchBytes, &st, &ss, filepath.Base(st.IdxPath), &gz, hard, back)
+		sent := !st.LastSendAt.Equal(prevSendAt)
+		if limiter != nil && (err != nil || sent) {
+			limiter.Take(clk.Now())
+		}
+		if errors.Is(err, ErrUnauthorized) {
+			authErr = err
+		}
+		pace.Observe(err)
+		failed = err != nil && len(batch) > 0
+		if sent {
+			saver.Save(st)
+			lastSend = clk.Now()
+		}
+	}
+	// shutdown makes one last attempt to ship the pending batch, bounded by
+	// FlushTimeout, and persists whatever was confirmed.
+	shutdown := func() error {
+		if len(batch) > 0 && cfg.FlushTimeout > 0 {
+			fctx, fcancel := context.WithTimeout(context.Background(), cfg.FlushTimeout)
+			defer fcancel()
+			// A hard send skips resource gating, and a zero backoff keeps a
+			// failed flush from sleeping.
+			if err := trySend(fctx, cfg, httpClient, &batch, &batchBytes, &st, &ss, filepath.Base(st.IdxPath), &gz, true, newBackoff(0, 0)); err != nil {
+				logger.Warn().Err(err).Int("frames", len(batch)).Msg("final flush abandoned")
+			} else {
+				saver.Save(st)
+			}
+		}
+		return ctx.Err()
+	}
 
 	for {
 		// Handle context cancellation
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			return shutdown()
 		default:
 		}
+		// A rejected auth key will not start working on retry
+		if authErr != nil {
+			logger.Error().Err(authErr).Msg("service rejected the auth key; stopping")
+			return authErr
+		}
 
-		fm, line, nerr := func() (FrameMeta, []byte, error) { return nextFrame(r) }()
+		if cfg.SelfCheckInterval > 0 && time.Since(lastCheck) >= cfg.SelfCheckInterval {
+			lastCheck = time.Now()
+			tracked := trackedOffset(st, batch)
+			if actual, err := readerOffset(idx, r); err == nil && actual != tracked {
+				logger.Error().
+					Str("index", st.IdxPath).
+					Int64("tracked", tracked).
+					Int64("actual", actual).
+					Msg("index offset drifted from reader position")
+				if cfg.OnOffsetDrift != nil {
+					cfg.OnOffsetDrift(OffsetDriftEvent{IdxPath: st.IdxPath, Tracked: tracked, Actual: actual})
+				}
+			}
+		}
+
+		// Slow the read cadence while the service reports overload
+		if d := pace.SuggestDelay(); d > 0 {
+			select {
+			case <-ctx.Done():
+				return shutdown()
+			case <-clk.After(d):
+			}
+		}
+
+		// Stop reading ahead while the pending batch is at the buffer bound.
+		// Unsent frames stay in the WAL, so nothing is lost and reading
+		// resumes in order once the batch goes through.
+		if cfg.MaxBufferedBytes > 0 && batchBytes >= cfg.MaxBufferedBytes {
+			send()
+			if batchBytes >= cfg.MaxBufferedBytes {
+				select {
+				case <-ctx.Done():
+					return shutdown()
+				case <-time.After(cfg.PollInterval):
+				}
+			}
+			continue
+		}
+
+		// Read no further until a failed batch commits; frames appended to
+		// it would change its idempotency key and defeat the dedup
+		if failed {
+			send()
+			if failed {
+				if cfg.Once && !drain {
+					// Like EOF in once mode: the position is kept for the
+					// next run
+					return nil
+				}
+				select {
+				case <-ctx.Done():
+					return shutdown()
+				case <-time.After(cfg.PollInterval):
+				}
+			}
+			continue
+		}
+
+		fm, line, nerr := func() (FrameMeta, []byte, error) {
+			return nextFrame(r, st.IdxPath, cfg.LenientIndex, cfg.StrictIndexFields)
+		}()
 		if nerr != nil {
 			if errors.Is(nerr, os.ErrClosed) {
 				return nerr
 			}
+			if errors.Is(nerr, ErrMalformedIndexLine) {
+				// Reading on would ship garbage; commit what we have and stop
+				logger.Error().Err(nerr).Msg("malformed index line; set lenient_index to skip validation")
+				readError(st.IdxPath, nerr, true)
+				if len(batch) > 0 {
+					send()
+				}
+				return nerr
+			}
 			if errors.Is(nerr, io.EOF) {
+				// A partial last line is still being written; rewind so the
+				// next poll reads it whole
+				if len(line) > 0 {
+					if _, err := idx.Seek(-int64(len(line)), io.SeekCurrent); err == nil {
+						r.Reset(idx)
+					}
+				}
 				// Flush pending batch
 				if len(batch) > 0 {
-					trySend(cfg, httpClient, &batch, &batchBytes, &st, filepath.Base(st.IdxPath), &gz, lastSend, back)
-					lastSend = st.LastSendAt
+					send()
 				}
-				if cfg.Once {
+				// Caught up: persist now rather than at the next interval,
+				// since the reader is about to wait anyway
+				saver.Flush()
+				if cfg.Once && !drain {
 					return nil
 				}
-				// rotation discovery: move to next index after current
-				if next, ok, _ := nextIndexAfter(st.IdxPath); ok {
+				if failed {
+					// Retry before moving past the batch's index
+					continue
+				}
+				// rotation discovery: move to next index after current, or
+				// to the next WAL directory once this one is exhausted
+				next, ok, _ := nextIndexAfter(st.IdxPath, cfg.MinDay)
+				dirIdx := st.DirIndex
+				if !ok && dirIdx+1 < len(dirs) {
+					if p, i, err := firstIndexFrom(dirs, dirIdx+1, cfg.MinDay); err == nil {
+						next, ok, dirIdx = p, true, i
+					}
+				}
+				if ok {
 					idx.Close()
 					if gz != nil {
 						gz.Close()
@@ -113,18 +355,116 @@ func Run(ctx context.Context, cfg Config) error {
 					idx2, r2, oerr := openIdx(next)
 					if oerr == nil {
 						idx, r = idx2, r2
-						st.IdxPath, st.IdxOffset, st.CurGz = next, 0, ""
-						_ = saveState(cfg.StateDir, st)
+						st.IdxPath, st.IdxOffset, st.CurGz, st.DirIndex = next, 0, "", dirIdx
+						saver.Save(st)
 						continue
 					}
 				}
-				time.Sleep(cfg.PollInterval)
+				if drain {
+					if len(batch) == 0 {
+						return nil
+					}
+					// The last send was gated or failed; try again
+					select {
+					case <-ctx.Done():
+						return shutdown()
+					case <-time.After(cfg.PollInterval):
+					}
+					continue
+				}
+				dirIdx = st.DirIndex
+				if dirIdx >= len(dirs) {
+					dirIdx = len(dirs) - 1
+				}
+				// The WAL directory may have been moved or deleted under us;
+				// wait for it with a longer backoff rather than polling
+				if missing, first, serr := src.Check(dirs[dirIdx]); missing {
+					if first {
+						logger.Warn().Err(serr).Str("dir", dirs[dirIdx]).Msg("wal directory unavailable; waiting for it to reappear")
+						if cfg.OnSourceUnavailable != nil {
+							cfg.OnSourceUnavailable(SourceUnavailableEvent{Dir: dirs[dirIdx], Err: serr})
+						}
+					}
+					select {
+					case <-ctx.Done():
+						return shutdown()
+					case <-time.After(src.Next()):
+					}
+					continue
+				}
+				if src.Missing() {
+					if p, off, i, rerr := resumeIndex(st, dirs, dirIdx, cfg.MinDay); rerr == nil {
+						if idx2, r2, oerr := openIdx(p); oerr == nil {
+							idx.Close()
+							if gz != nil {
+								gz.Close()
+								gz = nil
+							}
+							if off > 0 {
+								if _, err := idx2.Seek(off, io.SeekStart); err == nil {
+									r2.Reset(idx2)
+								}
+							}
+							idx, r = idx2, r2
+							st.IdxPath, st.IdxOffset, st.CurGz, st.DirIndex = p, off, "", i
+							saver.Save(st)
+							src.Recovered()
+							logger.Info().Str("dir", dirs[i]).Str("index", p).Msg("wal directory available again; resuming")
+							continue
+						}
+					}
+				}
+				// Nothing to ship for a while: tell the service we are alive
+				if cfg.HeartbeatInterval > 0 && len(batch) == 0 &&
+					time.Since(lastBeat) >= cfg.HeartbeatInterval && time.Since(st.LastSendAt) >= cfg.HeartbeatInterval {
+					lastBeat = time.Now()
+					if err := sendHeartbeat(ctx, httpClient, &cfg, st); err != nil {
+						logger.Warn().Err(err).Msg("send heartbeat")
+					}
+				}
+				if ev, ok := idle.Poll(time.Now()); ok && cfg.OnIdle != nil {
+					cfg.OnIdle(ev)
+				}
+				waiter.Watch(filepath.Dir(st.IdxPath), dirs[dirIdx])
+				waiter.Wait(ctx, cfg.PollInterval)
 				continue
 			}
 			// other read error
+			logger.Warn().Err(nerr).Str("index", st.IdxPath).Msg("read index")
+			readError(st.IdxPath, nerr, false)
 			time.Sleep(cfg.PollInterval)
 			continue
 		}
+		idle.Active()
+		checkTimestamps(cfg, &skew, &fm)
+
+		// Drop filtered and oversized frames before reading their payload.
+		// Their index lines still have to be committed so a restart does not
+		// re-read them: with nothing pending, commit now; otherwise ride
+		// along with the last batched frame.
+		skip := func() {
+			if len(batch) == 0 {
+				st.IdxOffset += int64(len(line))
+				saver.Save(st)
+			} else {
+				batch[len(batch)-1].IdxLineLen += len(line)
+			}
+		}
+		if skipFrame(cfg, fm) {
+			if frameTooLarge(cfg, fm) {
+				logger.Warn().
+					Str("file", fm.File).
+					Uint64("frame", fm.Frame).
+					Uint64("len", fm.Len).
+					Int("max_frame_bytes", cfg.MaxFrameBytes).
+					Msg("frame exceeds max_frame_bytes; skipped")
+				if cfg.OnFrameTooLarge != nil {
+					cfg.OnFrameTooLarge(FrameTooLargeEvent{Frame: fm, Limit: cfg.MaxFrameBytes})
+				}
+			}
+			skip()
+			continue
+		}
 
 		// Ensure gz open for this frame
 		if gz == nil || filepath.Base(st.CurGz) != fm.File {
@@ -134,6 +474,8 @@ func Run(ctx context.Context, cfg Config) error {
 			path := filepath.Join(filepath.Dir(st.IdxPath), fm.File)
 			ngz, gerr := openGz(path)
 			if gerr != nil {
+				logger.Warn().Err(gerr).Str("file", path).Msg("open wal segment")
+				readError(path, gerr, false)
 				time.Sleep(cfg.PollInterval)
 				continue
 			}
@@ -152,11 +494,41 @@ func Run(ctx context.Context, cfg Config) error {
 		// Read compressed bytes for this frame
 		b, rerr := preadSection(gz, int64(fm.Off), int64(fm.Len))
 		if rerr != nil {
+			logger.Warn().Err(rerr).Str("file", st.CurGz).Uint64("frame", fm.Frame).Msg("read frame")
+			readError(filepath.Join(filepath.Dir(st.IdxPath), fm.File), rerr, false)
 			time.Sleep(cfg.PollInterval)
 			continue
 		}
+		if cfg.StrictFrameBounds {
+			if err := checkFrameBounds(fm, b); err != nil {
+				// The index no longer lines up with the data; stop rather
+				// than ship misaligned bytes
+				logger.Error().Err(err).Msg("frame bounds check failed")
+				readError(filepath.Join(filepath.Dir(st.IdxPath), fm.File), err, true)
+				if len(batch) > 0 {
+					send()
+				}
+				return err
+			}
+		}
 		if cfg.Verify {
-			_ = verifyFrame(fm, io.NopCloser(bytes.NewReader(b)))
+			if err := verifyFrame(fm, io.NopCloser(bytes.NewReader(b))); err != nil {
+				logger.Warn().Err(err).Msg("frame failed verification")
+			}
+		}
+		if cfg.SendUncompressed {
+			raw, err := gunzipFrame(b)
+			if err != nil {
+				// Raw mode cannot ship bytes it failed to decode
+				err = fmt.Errorf("decompress %s frame %d: %w", fm.File, fm.Frame, err)
+				logger.Error().Err(err).Msg("decompress frame")
+				readError(filepath.Join(filepath.Dir(st.IdxPath), fm.File), err, true)
+				if len(batch) > 0 {
+					send()
+				}
+				return err
+			}
+			b = raw
 		}
 
 		// Large frame: send alone
@@ -178,35 +550,38 @@ func Run(ctx context.Context, cfg Config) error {
 			bf := batchFrame{Meta: fm, Compressed: b, IdxLineLen: len(line)}
 			batch = append(batch, bf)
 			batchBytes += len(b)
-			trySend(cfg, httpClient, &batch, &batchBytes, &st, filepath.Base(st.IdxPath), &gz, lastSend, back)
-			lastSend = st.LastSendAt
+			send()
 			continue
 		}
 		// Normal batch
 		if cfg.MaxBatchBytes > 0 && batchBytes+len(b) > cfg.MaxBatchBytes {
-			trySend(cfg, httpClient, &batch, &batchBytes, &st, filepath.Base(st.IdxPath), &gz, lastSend, back)
-			lastSend = st.LastSendAt
+			send()
+		} else if exceedsTimeSpan(batch, fm, cfg.MaxBatchTimeSpan) {
+			send()
 		}
 		batch = append(batch, batchFrame{Meta: fm, Compressed: b, IdxLineLen: len(line)})
 		batchBytes += len(b)
 
 		// Time-based send
-		if time.Since(lastSend) >= cfg.SendInterval || time.Since(lastSend) >= cfg.HardInterval {
-			trySend(cfg, httpClient, &batch, &batchBytes, &st, filepath.Base(st.IdxPath), &gz, lastSend, back)
-			lastSend = st.LastSendAt
+		if since := clk.Now().Sub(lastSend); since >= cfg.SendInterval || since >= cfg.HardInterval {
+			send()
 		}
 	}
 }
 
-func trySend(cfg Config, httpClient *http.Client, batch *[]batchFrame, batchBytes *int, st *state, curIdxBase string, gz **os.File, lastSend time.Time, back *backoff) {
+func trySend(ctx context.Context, cfg Config, httpClient *http.Client, batch *[]batchFrame, batchBytes *int, st *state, ss *sendState, curIdxBase string, gz **os.File, hard bool, back *backoff.Backoff) (err error) {
 	if len(*batch) == 0 {
-		return
+		return nil
 	}
-	// Resource gating (soft)
-	hard := time.Since(lastSend) >= cfg.HardInterval
+	// Resource gating (soft) until the hard interval has passed
 	if !hard && !resourcesOK(cfg) {
-		return
+		return nil
 	}
+	defer func() {
+		if err != nil {
+			sendFailed(ctx, cfg, ss, len(*batch), err)
+		}
+	}()
 
 	// Build payload
 	manifest := make([]FrameMeta, 0, len(*batch))
@@ -215,9 +590,25 @@ func trySend(cfg Config, httpClient *http.Client, batch *[]batchFrame, batchByte
 		manifest = append(manifest, fr.Meta)
 		advance += int64(fr.IdxLineLen)
 	}
-	url := cfg.ServiceURL + walFramesEndpoint
-	var body bytes.Buffer
-	writer := multipart.NewWriter(&body)
+	url := cfg.serviceBase() + walFramesEndpoint
+	key := batchKey(cfg.ChainID, cfg.NodeID, filepath.Dir(st.IdxPath), *batch, *batchBytes)
+
+	// The last upload of this batch timed out; it may have been ingested.
+	// Run holds a failed batch unchanged, so its retry carries the same key
+	if cfg.ProbeBeforeRetry && ss.PendingKey == key && time.Since(ss.PendingAt) < probeTTL {
+		ss.PendingKey = ""
+		accepted, err := probeBatch(ctx, cfg, httpClient, key)
+		if err != nil {
+			logger.Warn().Err(err).Msg("probe batch status; uploading again")
+		} else if accepted {
+			logger.Info().
+				Int("frames", len(*batch)).
+				Str("key", key).
+				Msg("batch already accepted; not uploading again")
+			commitBatch(batch, batchBytes, st, ss, manifest, advance, back)
+			return nil
+		}
+	}
 
 	// Log batch details before building payload
 	logger.Debug().
@@ -226,77 +617,160 @@ func trySend(cfg Config, httpClient *http.Client, batch *[]batchFrame, batchByte
 		Int("max_batch_bytes", cfg.MaxBatchBytes).
 		Msg("Building multipart payload")
 
-	manifestJSON, err := json.Marshal(manifest)
-	if err != nil {
-		logger.Error().Err(err).Msg("marshal manifest")
-		back.Sleep()
-		return
-	}
-	manifestPart, err := writer.CreateFormField("manifest")
-	if err != nil {
-		logger.Error().Err(err).Msg("create manifest field")
-		back.Sleep()
-		return
-	}
-	if _, err := manifestPart.Write(manifestJSON); err != nil {
-		logger.Error().Err(err).Msg("write manifest field")
-		back.Sleep()
-		return
+	var (
+		manifestData []byte
+		manifestType = "application/json"
+	)
+	if cfg.SendUncompressed {
+		entries := make([]manifestFrame, len(*batch))
+		for i, fr := range *batch {
+			entries[i] = manifestFrame{FrameMeta: fr.Meta, Raw: true, RawLen: uint64(len(fr.Compressed))}
+		}
+		manifestData, err = json.Marshal(entries)
+	} else {
+		var enc ManifestEncoder = JSONManifestEncoder{}
+		if cfg.ManifestEncoder != nil {
+			enc = cfg.ManifestEncoder
+		}
+		manifestData, manifestType, err = enc.Encode(manifest)
 	}
-
-	framesPart, err := writer.CreateFormFile("frames", curIdxBase)
 	if err != nil {
-		logger.Error().Err(err).Msg("create frames field")
-		back.Sleep()
-		return
+		logger.Error().Err(err).Msg("marshal manifest")
+		retryWait(ctx, ss, back)
+		return err
 	}
-	for _, fr := range *batch {
-		if _, err := framesPart.Write(fr.Compressed); err != nil {
-			logger.Error().Err(err).Msg("write frames payload")
-			back.Sleep()
-			return
+	gzipped := false
+	if cfg.ManifestGzipLevel != 0 {
+		if manifestData, err = gzipBytes(manifestData, cfg.ManifestGzipLevel); err != nil {
+			logger.Error().Err(err).Msg("compress manifest")
+			retryWait(ctx, ss, back)
+			return err
 		}
+		gzipped = true
 	}
-	if err := writer.Close(); err != nil {
-		logger.Error().Err(err).Msg("finalize multipart payload")
-		back.Sleep()
-		return
+	body := newBatchBody(cfg.PartNames.withDefaults(), manifestData, curIdxBase, *batch)
+	body.manifestType = manifestType
+	body.manifestGzipped = gzipped
+	bodySize, err := body.Size()
+	if err != nil {
+		logger.Error().Err(err).Msg("build multipart payload")
+		retryWait(ctx, ss, back)
+		return err
 	}
 
 	// Log actual multipart body size
-	bodySize := body.Len()
 	logger.Debug().
-		Int("body_size_bytes", bodySize).
-		Int("body_size_mb", bodySize/(1<<20)).
+		Int64("body_size_bytes", bodySize).
+		Int64("body_size_mb", bodySize/(1<<20)).
 		Int("frames_in_manifest", len(manifest)).
 		Msg("Multipart payload ready")
 
-	req, err := http.NewRequest(http.MethodPost, url, &body)
+	// Stream the body unless a codec needs it whole
+	var (
+		reqBody  io.Reader
+		encoding string
+	)
+	if cfg.BatchCodec == nil {
+		rc := body.Reader()
+		defer rc.Close()
+		reqBody = rc
+	} else {
+		raw, err := body.Bytes()
+		if err == nil {
+			var payload []byte
+			payload, encoding, err = encodeBatch(cfg.BatchCodec, raw)
+			bodySize = int64(len(payload))
+			reqBody = bytes.NewReader(payload)
+		}
+		if err != nil {
+			logger.Error().Err(err).Msg("encode batch payload")
+			retryWait(ctx, ss, back)
+			return err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, reqBody)
 	if err != nil {
-		return
+		return err
 	}
+	req.ContentLength = bodySize
 	req.Header.Set("Authorization", "Bearer "+cfg.AuthKey)
-	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Content-Type", body.ContentType())
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	if cfg.SendUncompressed {
+		req.Header.Set("X-Frames-Encoding", "identity")
+	}
+	req.Header.Set("User-Agent", userAgent(&cfg))
 	req.Header.Set("X-Agent-Hostname", hostname())
 	req.Header.Set("X-Agent-OSArch", runtime.GOOS+"/"+runtime.GOARCH)
 	req.Header.Set("X-Cosmos-Analyzer-Chain-Id", cfg.ChainID)
 	req.Header.Set("X-Cosmos-Analyzer-Node-Id", cfg.NodeID)
+	req.Header.Set("Idempotency-Key", key)
+	req.Header.Set("X-Batch-Id", key)
+	setLabelHeaders(req.Header, cfg.Labels)
+	// Lets the service detect a truncated frames part
+	req.Header.Set("X-Content-SHA256", hex.EncodeToString(body.Checksum()))
 
+	sendStart := time.Now()
 	resp, err := httpClient.Do(req)
 	if err != nil {
+		var dnsErr *net.DNSError
+		if ctx.Err() == nil && errors.As(err, &dnsErr) {
+			// Logged and paced separately; resolver outages are noisy
+			handleDNSError(ctx, cfg, ss, dnsErr, err, back)
+			return err
+		}
 		logger.Error().Err(err).Msg("send batch")
-		back.Sleep()
-		return
+		if ctx.Err() != nil {
+			// Cancelled: leave the batch for the final flush without sleeping
+			return err
+		}
+		if isTimeout(err) {
+			ss.PendingKey, ss.PendingAt = key, time.Now()
+		}
+		retryWait(ctx, ss, back)
+		return err
 	}
 	defer resp.Body.Close()
+	ss.DNSFailures = 0
+	if err := checkRedirect(req, resp); err != nil {
+		var re *redirectError
+		if errors.As(err, &re) {
+			logger.Error().
+				Int("status", re.Status).
+				Str("location", re.Location).
+				Msg("service url redirected; update service_url in config")
+		}
+		retryWait(ctx, ss, back)
+		return err
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		body, _ := io.ReadAll(resp.Body)
+		rl := &rateLimitedError{
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+			Body:       string(body),
+		}
+		logger.Warn().
+			Dur("retry_after", rl.RetryAfter).
+			Str("body", rl.Body).
+			Msg("server rate limited batch")
+		// No backoff here: the read loop's pacer waits out Retry-After
+		return rl
+	}
 	if resp.StatusCode/100 != 2 {
 		body, _ := io.ReadAll(resp.Body)
 		logger.Error().
 			Int("status", resp.StatusCode).
 			Str("body", string(body)).
 			Msg("server returned error")
-		back.Sleep()
-		return
+		herr := &HTTPStatusError{Status: resp.StatusCode, Body: string(body)}
+		if !errors.Is(herr, ErrUnauthorized) {
+			// Run stops on auth errors; no point waiting
+			retryWait(ctx, ss, back)
+		}
+		return herr
 	}
 
 	logger.Info().
@@ -304,16 +778,25 @@ func trySend(cfg Config, httpClient *http.Client, batch *[]batchFrame, batchByte
 		Int("bytes", *batchBytes).
 		Int("size_mb", *batchBytes/(1<<20)).
 		Msg("sent batch")
+	if cfg.SendLatency != nil {
+		cfg.SendLatency.Record(time.Since(sendStart))
+	}
+
+	commitBatch(batch, batchBytes, st, ss, manifest, advance, back)
+	return nil
+}
 
-	// Success: commit idx offset
+// commitBatch advances st past a batch the service accepted and resets
+// the batch, its retry bookkeeping in ss and the backoff.
+func commitBatch(batch *[]batchFrame, batchBytes *int, st *state, ss *sendState, manifest []FrameMeta, advance int64, back *backoff.Backoff) {
 	st.IdxOffset += advance
 	st.LastFile = manifest[len(manifest)-1].File
 	st.LastFrame = manifest[len(manifest)-1].Frame
 	st.LastSendAt = time.Now()
 	st.LastCommitAt = st.LastSendAt
-	_ = saveState(cfg.StateDir, *st)
+	ss.PendingKey = ""
+	ss.SendAttempt = 0
 
-	// reset batch
 	*batch = (*batch)[:0]
 	*batchBytes = 0
 	back.Reset()
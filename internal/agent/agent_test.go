@@ -1,12 +1,17 @@
 package agent
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/ed25519"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"hash/crc32"
 	"io"
+	"math/rand"
 	"mime"
 	"mime/multipart"
 	"net/http"
@@ -14,6 +19,8 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -92,9 +99,10 @@ func TestTrySend(t *testing.T) {
 	}
 	batchBytes := 15
 	st := state{IdxOffset: 0}
+	var ss sendState
 	back := newBackoff(time.Millisecond, time.Second)
 
-	trySend(cfg, http.DefaultClient, &batch, &batchBytes, &st, "000.idx", nil, time.Now(), back)
+	trySend(context.Background(), cfg, http.DefaultClient, &batch, &batchBytes, &st, &ss, "000.idx", nil, false, back)
 
 	if len(batch) != 0 {
 		t.Errorf("batch length = %d, want 0", len(batch))
@@ -177,10 +185,11 @@ func TestTrySend_EmptyBatch(t *testing.T) {
 	batch := []batchFrame{}
 	batchBytes := 0
 	st := state{}
+	var ss sendState
 	back := newBackoff(time.Millisecond, time.Second)
 
 	// Should return immediately without error or panic
-	trySend(cfg, http.DefaultClient, &batch, &batchBytes, &st, "000.idx", nil, time.Now(), back)
+	trySend(context.Background(), cfg, http.DefaultClient, &batch, &batchBytes, &st, &ss, "000.idx", nil, false, back)
 }
 
 func TestTrySend_ServerError(t *testing.T) {
@@ -193,10 +202,11 @@ func TestTrySend_ServerError(t *testing.T) {
 	batch := []batchFrame{{Meta: FrameMeta{File: "f", Frame: 1}}}
 	batchBytes := 10
 	st := state{IdxOffset: 0}
+	var ss sendState
 	back := newBackoff(time.Millisecond, time.Second)
 
 	// Should handle 500 error gracefully (backoff and return, no state update)
-	trySend(cfg, http.DefaultClient, &batch, &batchBytes, &st, "000.idx", nil, time.Now(), back)
+	trySend(context.Background(), cfg, http.DefaultClient, &batch, &batchBytes, &st, &ss, "000.idx", nil, false, back)
 
 	if len(batch) == 0 {
 		t.Error("batch should not be cleared on server error")
@@ -223,9 +233,10 @@ func TestTrySend_Timeout(t *testing.T) {
 	batch := []batchFrame{{Meta: FrameMeta{File: "f", Frame: 1}}}
 	batchBytes := 10
 	st := state{IdxOffset: 0}
+	var ss sendState
 	back := newBackoff(time.Millisecond, time.Second)
 
-	trySend(cfg, httpClient, &batch, &batchBytes, &st, "000.idx", nil, time.Now(), back)
+	trySend(context.Background(), cfg, httpClient, &batch, &batchBytes, &st, &ss, "000.idx", nil, false, back)
 
 	if len(batch) == 0 {
 		t.Error("batch should not be cleared on timeout")
@@ -277,9 +288,10 @@ func TestTrySend_StateVerification(t *testing.T) {
 	}
 	batchBytes := 8
 	st := state{IdxOffset: 100}
+	var ss sendState
 	back := newBackoff(time.Millisecond, time.Second)
 
-	trySend(cfg, http.DefaultClient, &batch, &batchBytes, &st, "seg-000001.wal.idx", nil, time.Now(), back)
+	trySend(context.Background(), cfg, http.DefaultClient, &batch, &batchBytes, &st, &ss, "seg-000001.wal.idx", nil, false, back)
 
 	// Verify state updates
 	if st.IdxOffset != 135 { // 100 + 20 + 15
@@ -363,11 +375,12 @@ func TestTrySend_LargeFrame(t *testing.T) {
 	}
 	batchBytes := len(largeData)
 	st := state{}
+	var ss sendState
 	back := newBackoff(time.Millisecond, time.Second)
 
 	// In actual Run(), large frames are added to batch then immediately sent
 	// Here we verify trySend processes it correctly
-	trySend(cfg, http.DefaultClient, &batch, &batchBytes, &st, "test.idx", nil, time.Now(), back)
+	trySend(context.Background(), cfg, http.DefaultClient, &batch, &batchBytes, &st, &ss, "test.idx", nil, false, back)
 
 	if sentBatches != 1 {
 		t.Errorf("Expected 1 batch sent, got %d", sentBatches)
@@ -402,10 +415,11 @@ func TestTrySend_BatchOverflow(t *testing.T) {
 	}
 	batchBytes := 80
 	st := state{}
+	var ss sendState
 	back := newBackoff(time.Millisecond, time.Second)
 
 	// Try to send - should succeed
-	trySend(cfg, http.DefaultClient, &batch, &batchBytes, &st, "test.idx", nil, time.Now(), back)
+	trySend(context.Background(), cfg, http.DefaultClient, &batch, &batchBytes, &st, &ss, "test.idx", nil, false, back)
 
 	if sendCount != 1 {
 		t.Errorf("Expected 1 send, got %d", sendCount)
@@ -439,12 +453,979 @@ func TestTrySend_URLConstruction(t *testing.T) {
 	}
 	batchBytes := 4
 	st := state{IdxOffset: 0}
+	var ss sendState
 	back := newBackoff(time.Millisecond, time.Second)
 
-	trySend(cfg, http.DefaultClient, &batch, &batchBytes, &st, "000.idx", nil, time.Now(), back)
+	trySend(context.Background(), cfg, http.DefaultClient, &batch, &batchBytes, &st, &ss, "000.idx", nil, false, back)
 
 	expectedPath := "/v1/ingest/wal-frames"
 	if requestPath != expectedPath {
 		t.Errorf("Request path = %v, want %v", requestPath, expectedPath)
 	}
 }
+
+// writeTestSegment writes seg-NNNNNN.wal.gz/.wal.idx into dir with one gzip
+// member per payload and returns the frame metadata in index order.
+func writeTestSegment(t *testing.T, dir string, seg int, payloads ...string) []FrameMeta {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	gzName := fmt.Sprintf("seg-%06d.wal.gz", seg)
+	var gzBuf, idxBuf bytes.Buffer
+	metas := make([]FrameMeta, 0, len(payloads))
+	for i, p := range payloads {
+		off := gzBuf.Len()
+		zw := gzip.NewWriter(&gzBuf)
+		if _, err := zw.Write([]byte(p)); err != nil {
+			t.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		fm := FrameMeta{
+			File:  gzName,
+			Frame: uint64(i + 1),
+			Off:   uint64(off),
+			Len:   uint64(gzBuf.Len() - off),
+			Recs:  uint32(strings.Count(p, "\n")),
+			CRC32: crc32.ChecksumIEEE([]byte(p)),
+		}
+		line, err := json.Marshal(fm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		idxBuf.Write(append(line, '\n'))
+		metas = append(metas, fm)
+	}
+	if err := os.WriteFile(filepath.Join(dir, gzName), gzBuf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("seg-%06d.wal.idx", seg)), idxBuf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return metas
+}
+
+func TestRun_FlushTimeoutOnShutdown(t *testing.T) {
+	// The first batch is accepted; every later send hangs until the client
+	// gives up, simulating an ingest service that stalls during shutdown.
+	var sends int32
+	var mu sync.Mutex
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != walFramesEndpoint {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		mu.Lock()
+		sends++
+		n := sends
+		mu.Unlock()
+		if n > 1 {
+			// Drain the body so the server notices the client hanging up
+			_, _ = io.Copy(io.Discard, r.Body)
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	tmpDir := t.TempDir()
+	walDir := filepath.Join(tmpDir, "wal")
+	writeTestSegment(t, walDir, 1, "f1\n", "f2\n")
+
+	flushTimeout := 100 * time.Millisecond
+	cfg := Config{
+		ServiceURL:   ts.URL,
+		WALDir:       walDir,
+		StateDir:     filepath.Join(tmpDir, "state"),
+		PollInterval: 10 * time.Millisecond,
+		SendInterval: time.Hour,
+		HardInterval: time.Hour,
+		HTTPTimeout:  time.Minute,
+		FlushTimeout: flushTimeout,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Run(ctx, cfg) }()
+
+	time.Sleep(200 * time.Millisecond)
+	start := time.Now()
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Run() error = %v, want context.Canceled", err)
+		}
+	case <-time.After(flushTimeout + 2*time.Second):
+		t.Fatal("Run did not return within FlushTimeout after cancel")
+	}
+	if elapsed := time.Since(start); elapsed > flushTimeout+time.Second {
+		t.Errorf("shutdown took %v, want within %v+slack", elapsed, flushTimeout)
+	}
+
+	st, err := loadState(cfg.StateDir)
+	if err != nil {
+		t.Fatalf("load state: %v", err)
+	}
+	if st.LastFrame != 1 {
+		t.Errorf("LastFrame = %d, want 1 (only the confirmed send)", st.LastFrame)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if sends < 2 {
+		t.Errorf("sends = %d, want the second batch to have been attempted", sends)
+	}
+}
+
+func TestRun_FrameFilter(t *testing.T) {
+	rec := &frameRecorder{}
+	ts := httptest.NewServer(rec)
+	defer ts.Close()
+
+	tmpDir := t.TempDir()
+	walDir := filepath.Join(tmpDir, "wal")
+	writeTestSegment(t, walDir, 1, "f1\n", "f2\n", "f3\n", "f4\n")
+
+	var seen []uint64
+	cfg := Config{
+		ServiceURL:   ts.URL,
+		WALDir:       walDir,
+		StateDir:     filepath.Join(tmpDir, "state"),
+		PollInterval: time.Millisecond,
+		// The first frame goes out immediately; later ones wait for EOF, so
+		// frame 2 is skipped with nothing pending and frame 4 behind frame 3.
+		SendInterval: time.Hour,
+		HardInterval: time.Hour,
+		Once:         true,
+		FrameFilter: func(fm FrameMeta) bool {
+			seen = append(seen, fm.Frame)
+			return fm.Frame%2 == 1
+		},
+	}
+	if err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if len(seen) != 4 {
+		t.Errorf("filter saw frames %v, want all 4", seen)
+	}
+	rec.mu.Lock()
+	var shipped []uint64
+	for _, fm := range rec.frames {
+		shipped = append(shipped, fm.Frame)
+	}
+	rec.mu.Unlock()
+	if fmt.Sprint(shipped) != "[1 3]" {
+		t.Errorf("shipped frames %v, want [1 3]", shipped)
+	}
+
+	fi, err := os.Stat(filepath.Join(walDir, "seg-000001.wal.idx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := loadState(cfg.StateDir)
+	if err != nil {
+		t.Fatalf("load state: %v", err)
+	}
+	if st.IdxOffset != fi.Size() {
+		t.Errorf("IdxOffset = %d, want %d (past every line, skipped or not)", st.IdxOffset, fi.Size())
+	}
+}
+
+func TestRun_SendIntervalFollowsClock(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		batches []string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != walFramesEndpoint {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err != nil {
+				break
+			}
+			if part.FormName() != "manifest" {
+				continue
+			}
+			data, _ := io.ReadAll(part)
+			var manifest []FrameMeta
+			_ = json.Unmarshal(data, &manifest)
+			var frames []uint64
+			for _, fm := range manifest {
+				frames = append(frames, fm.Frame)
+			}
+			mu.Lock()
+			batches = append(batches, fmt.Sprint(frames))
+			mu.Unlock()
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	tmpDir := t.TempDir()
+	walDir := filepath.Join(tmpDir, "wal")
+	writeTestSegment(t, walDir, 1, "f1\n", "f2\n", "f3\n", "f4\n", "f5\n")
+
+	clk := newFakeClock()
+	cfg := Config{
+		ServiceURL:   ts.URL,
+		WALDir:       walDir,
+		StateDir:     filepath.Join(tmpDir, "state"),
+		PollInterval: time.Millisecond,
+		SendInterval: time.Hour,
+		HardInterval: 2 * time.Hour,
+		Once:         true,
+		FrameFilter: func(fm FrameMeta) bool {
+			// The interval passes while frame 3 is read, so it closes the
+			// second batch instead of waiting for EOF
+			if fm.Frame == 3 {
+				clk.Advance(time.Hour)
+			}
+			return true
+		},
+	}
+	if err := run(context.Background(), cfg, false, clk); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got := strings.Join(batches, " "); got != "[1] [2 3] [4 5]" {
+		t.Errorf("batches = %s, want [1] [2 3] [4 5]", got)
+	}
+}
+
+func TestRun_RetriesFailedBatchUnchanged(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests []string
+		keys     []string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != walFramesEndpoint {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		var frames []uint64
+		for {
+			part, err := mr.NextPart()
+			if err != nil {
+				break
+			}
+			if part.FormName() == "manifest" {
+				var manifest []FrameMeta
+				_ = json.NewDecoder(part).Decode(&manifest)
+				for _, fm := range manifest {
+					frames = append(frames, fm.Frame)
+				}
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, fmt.Sprint(frames))
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		if len(requests) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	tmpDir := t.TempDir()
+	walDir := filepath.Join(tmpDir, "wal")
+	writeTestSegment(t, walDir, 1, "f1\n", "f2\n", "f3\n")
+	cfg := Config{
+		ServiceURL:     ts.URL,
+		WALDir:         walDir,
+		StateDir:       filepath.Join(tmpDir, "state"),
+		PollInterval:   time.Millisecond,
+		SendInterval:   time.Hour,
+		HardInterval:   time.Hour,
+		BackoffInitial: time.Millisecond,
+		BackoffMax:     time.Millisecond,
+		Once:           true,
+	}
+	if err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	// Frames 2 and 3 wait for the failed batch rather than joining it
+	if got := strings.Join(requests, " "); got != "[1] [1] [2 3]" {
+		t.Fatalf("requests = %s, want [1] [1] [2 3]", got)
+	}
+	if keys[0] == "" || keys[0] != keys[1] {
+		t.Errorf("retry keys = %q, %q; want equal and non-empty", keys[0], keys[1])
+	}
+}
+
+func TestRun_ProbeAfterTimeoutWhileFramesArrive(t *testing.T) {
+	tmpDir := t.TempDir()
+	walDir := filepath.Join(tmpDir, "wal")
+	writeTestSegment(t, walDir, 1, "f1\n", "f2\n")
+
+	var (
+		mu       sync.Mutex
+		uploads  []string
+		probes   int
+		accepted = map[string]bool{}
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		key := r.Header.Get("Idempotency-Key")
+		switch {
+		case r.Method == http.MethodHead && r.URL.Path == statusEndpoint:
+			probes++
+			if !accepted[key] {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		case r.URL.Path == walFramesEndpoint:
+			_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			mr := multipart.NewReader(r.Body, params["boundary"])
+			var frames []uint64
+			for {
+				part, err := mr.NextPart()
+				if err != nil {
+					break
+				}
+				if part.FormName() == "manifest" {
+					var manifest []FrameMeta
+					_ = json.NewDecoder(part).Decode(&manifest)
+					for _, fm := range manifest {
+						frames = append(frames, fm.Frame)
+					}
+				}
+			}
+			uploads = append(uploads, fmt.Sprint(frames))
+			accepted[key] = true
+			if len(uploads) == 1 {
+				// The node keeps writing while the upload is ingested, and
+				// the answer comes too late for the client
+				writeTestSegment(t, walDir, 1, "f1\n", "f2\n", "f3\n", "f4\n")
+				mu.Unlock()
+				time.Sleep(300 * time.Millisecond)
+				mu.Lock()
+			}
+		}
+	}))
+	defer ts.Close()
+
+	cfg := Config{
+		ServiceURL:       ts.URL,
+		WALDir:           walDir,
+		StateDir:         filepath.Join(tmpDir, "state"),
+		PollInterval:     time.Millisecond,
+		SendInterval:     time.Hour,
+		HardInterval:     time.Hour,
+		HTTPTimeout:      100 * time.Millisecond,
+		BackoffInitial:   time.Millisecond,
+		BackoffMax:       time.Millisecond,
+		ProbeBeforeRetry: true,
+		Once:             true,
+	}
+	if err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	// The timed-out batch is confirmed by the probe, not uploaded again
+	if got := strings.Join(uploads, " "); got != "[1] [2 3 4]" || probes != 1 {
+		t.Errorf("uploads = %s, probes = %d; want [1] [2 3 4] and 1 probe", got, probes)
+	}
+	st, err := loadState(cfg.StateDir)
+	if err != nil {
+		t.Fatalf("load state: %v", err)
+	}
+	if st.LastFrame != 4 {
+		t.Errorf("LastFrame = %d, want 4", st.LastFrame)
+	}
+}
+
+func TestRun_SkipEmptyFrames(t *testing.T) {
+	rec := &frameRecorder{}
+	ts := httptest.NewServer(rec)
+	defer ts.Close()
+
+	tmpDir := t.TempDir()
+	walDir := filepath.Join(tmpDir, "wal")
+	// Payloads without a newline index as recs 0
+	writeTestSegment(t, walDir, 1, "", "f2\n", "", "", "f5\n", "")
+
+	cfg := Config{
+		ServiceURL:      ts.URL,
+		WALDir:          walDir,
+		StateDir:        filepath.Join(tmpDir, "state"),
+		PollInterval:    time.Millisecond,
+		SendInterval:    time.Hour,
+		HardInterval:    time.Hour,
+		Once:            true,
+		SkipEmptyFrames: true,
+	}
+	if err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	rec.mu.Lock()
+	var shipped []uint64
+	for _, fm := range rec.frames {
+		shipped = append(shipped, fm.Frame)
+	}
+	rec.mu.Unlock()
+	if fmt.Sprint(shipped) != "[2 5]" {
+		t.Errorf("shipped frames %v, want [2 5]", shipped)
+	}
+
+	fi, err := os.Stat(filepath.Join(walDir, "seg-000001.wal.idx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := loadState(cfg.StateDir)
+	if err != nil {
+		t.Fatalf("load state: %v", err)
+	}
+	if st.IdxOffset != fi.Size() {
+		t.Errorf("IdxOffset = %d, want %d (past the empty frames too)", st.IdxOffset, fi.Size())
+	}
+}
+
+func TestTrySend_CustomPartNames(t *testing.T) {
+	var names []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil {
+			t.Errorf("parse content-type: %v", err)
+			return
+		}
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err != nil {
+				break
+			}
+			names = append(names, part.FormName())
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	send := func(parts PartNames) {
+		names = nil
+		cfg := Config{ServiceURL: ts.URL, StateDir: t.TempDir(), PartNames: parts}
+		batch := []batchFrame{{Meta: FrameMeta{File: "a.gz", Frame: 1}, Compressed: []byte("x"), IdxLineLen: 1}}
+		batchBytes := 1
+		st := state{}
+		var ss sendState
+		if err := trySend(context.Background(), cfg, http.DefaultClient, &batch, &batchBytes, &st, &ss, "a.idx", nil, false, newBackoff(time.Millisecond, time.Millisecond)); err != nil {
+			t.Fatalf("trySend() error = %v", err)
+		}
+	}
+
+	send(PartNames{Frames: "payload", Manifest: "meta"})
+	if strings.Join(names, ",") != "meta,payload" {
+		t.Errorf("custom part names = %v, want [meta payload]", names)
+	}
+
+	send(PartNames{})
+	if strings.Join(names, ",") != "manifest,frames" {
+		t.Errorf("default part names = %v, want [manifest frames]", names)
+	}
+}
+
+func TestRun_MaxFrameBytes(t *testing.T) {
+	rec := &frameRecorder{}
+	ts := httptest.NewServer(rec)
+	defer ts.Close()
+
+	tmpDir := t.TempDir()
+	walDir := filepath.Join(tmpDir, "wal")
+	noise := make([]byte, 8<<10)
+	rand.New(rand.NewSource(1)).Read(noise)
+	huge := string(noise) // incompressible, so its gzip member stays large
+	metas := writeTestSegment(t, walDir, 1, "small\n", huge, "small again\n")
+	limit := int(metas[0].Len) * 4
+	if metas[1].Len <= uint64(limit) {
+		t.Fatalf("test frame too small: len %d, limit %d", metas[1].Len, limit)
+	}
+
+	var tooLarge []FrameTooLargeEvent
+	cfg := Config{
+		ServiceURL:    ts.URL,
+		WALDir:        walDir,
+		StateDir:      filepath.Join(tmpDir, "state"),
+		PollInterval:  time.Millisecond,
+		SendInterval:  time.Hour,
+		HardInterval:  time.Hour,
+		Once:          true,
+		MaxFrameBytes: limit,
+		OnFrameTooLarge: func(ev FrameTooLargeEvent) {
+			tooLarge = append(tooLarge, ev)
+		},
+	}
+	if err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if len(tooLarge) != 1 || tooLarge[0].Frame.Frame != 2 || tooLarge[0].Limit != limit {
+		t.Errorf("OnFrameTooLarge events = %+v, want one for frame 2", tooLarge)
+	}
+	rec.mu.Lock()
+	var shipped []uint64
+	for _, fm := range rec.frames {
+		shipped = append(shipped, fm.Frame)
+	}
+	rec.mu.Unlock()
+	if fmt.Sprint(shipped) != "[1 3]" {
+		t.Errorf("shipped frames %v, want [1 3]", shipped)
+	}
+
+	fi, err := os.Stat(filepath.Join(walDir, "seg-000001.wal.idx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := loadState(cfg.StateDir)
+	if err != nil {
+		t.Fatalf("load state: %v", err)
+	}
+	if st.IdxOffset != fi.Size() {
+		t.Errorf("IdxOffset = %d, want %d (past the skipped frame)", st.IdxOffset, fi.Size())
+	}
+}
+
+func TestRun_CancelDeliversPendingBatch(t *testing.T) {
+	// The second send is rate limited for a minute, so its batch is still
+	// pending when Run is cancelled; only the final flush can deliver it.
+	rec := &frameRecorder{}
+	var sends, limited atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == walFramesEndpoint && sends.Add(1) == 2 {
+			limited.Store(1)
+			w.Header().Set("Retry-After", "60")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		rec.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	tmpDir := t.TempDir()
+	walDir := filepath.Join(tmpDir, "wal")
+	day := filepath.Join(walDir, "2026-01-01")
+	writeTestSegment(t, day, 1, "f1\n")
+
+	cfg := Config{
+		ServiceURL:     ts.URL,
+		WALDir:         walDir,
+		StateDir:       filepath.Join(tmpDir, "state"),
+		PollInterval:   5 * time.Millisecond,
+		SendInterval:   time.Millisecond,
+		HardInterval:   time.Millisecond,
+		BackoffInitial: time.Millisecond,
+		BackoffMax:     time.Millisecond,
+		FlushTimeout:   5 * time.Second,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Run(ctx, cfg) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for rec.count() < 1 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	writeTestSegment(t, day, 2, "f2\n")
+	for limited.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := rec.count(); n != 1 || limited.Load() == 0 {
+		t.Fatalf("shipped %d frames before cancel (limited=%d), want 1 with the next batch pending", n, limited.Load())
+	}
+
+	start := time.Now()
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run() error = %v, want context.Canceled", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("shutdown took %v; the flush should not wait out Retry-After", d)
+	}
+	if n := rec.count(); n != 2 {
+		t.Errorf("shipped %d frames, want the pending batch delivered on cancel", n)
+	}
+	st, err := loadState(cfg.StateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.LastFile != "seg-000002.wal.gz" || st.LastFrame != 1 {
+		t.Errorf("state = %s#%d, want seg-000002.wal.gz#1", st.LastFile, st.LastFrame)
+	}
+}
+
+func TestRun_OnReadError(t *testing.T) {
+	rec := &frameRecorder{}
+	ts := httptest.NewServer(rec)
+	defer ts.Close()
+
+	tmpDir := t.TempDir()
+	walDir := filepath.Join(tmpDir, "wal")
+	metas := writeTestSegment(t, walDir, 1, "ok\n", "short\n")
+	// Cut the second frame short
+	gzPath := filepath.Join(walDir, metas[1].File)
+	if err := os.Truncate(gzPath, int64(metas[1].Off+metas[1].Len-1)); err != nil {
+		t.Fatal(err)
+	}
+
+	var events []ReadErrorEvent
+	cfg := Config{
+		ServiceURL:   ts.URL,
+		WALDir:       walDir,
+		StateDir:     filepath.Join(tmpDir, "state"),
+		PollInterval: time.Millisecond,
+		SendInterval: time.Hour,
+		HardInterval: time.Hour,
+		Once:         true,
+		OnReadError:  func(ev ReadErrorEvent) { events = append(events, ev) },
+	}
+	if err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("OnReadError called %d times, want 1: %+v", len(events), events)
+	}
+	if ev := events[0]; ev.Path != gzPath || ev.Fatal || !errors.Is(ev.Err, io.ErrUnexpectedEOF) {
+		t.Errorf("event = %+v, want non-fatal short read of %s", ev, gzPath)
+	}
+	if got := rec.count(); got != 1 {
+		t.Errorf("shipped %d frames, want 1", got)
+	}
+}
+
+func TestRun_OnReadErrorFatal(t *testing.T) {
+	ts := httptest.NewServer(&frameRecorder{})
+	defer ts.Close()
+
+	tmpDir := t.TempDir()
+	walDir := filepath.Join(tmpDir, "wal")
+	if err := os.MkdirAll(walDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	idxPath := filepath.Join(walDir, "seg-000001.wal.idx")
+	if err := os.WriteFile(idxPath, []byte("{\"file\":\"seg-000001.wal.gz\",\"bogus\":1}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var events []ReadErrorEvent
+	cfg := Config{
+		ServiceURL:   ts.URL,
+		WALDir:       walDir,
+		StateDir:     filepath.Join(tmpDir, "state"),
+		PollInterval: time.Millisecond,
+		Once:         true,
+		OnReadError:  func(ev ReadErrorEvent) { events = append(events, ev) },
+	}
+	err := Run(context.Background(), cfg)
+	if !errors.Is(err, ErrMalformedIndexLine) {
+		t.Fatalf("Run() error = %v, want ErrMalformedIndexLine", err)
+	}
+	if len(events) != 1 || !events[0].Fatal || events[0].Path != idxPath || !errors.Is(events[0].Err, ErrMalformedIndexLine) {
+		t.Errorf("events = %+v, want one fatal event for %s", events, idxPath)
+	}
+}
+
+func TestDrain_ShipsAllSegments(t *testing.T) {
+	rec := &frameRecorder{}
+	var failed atomic.Bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Fail the first upload so Drain has to retry before returning
+		if r.URL.Path == walFramesEndpoint && failed.CompareAndSwap(false, true) {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		rec.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	tmpDir := t.TempDir()
+	walDir := filepath.Join(tmpDir, "wal")
+	writeTestSegment(t, filepath.Join(walDir, "2025-01-01"), 1, "a1\n", "a2\n")
+	writeTestSegment(t, filepath.Join(walDir, "2025-01-01"), 2, "b1\n")
+	writeTestSegment(t, filepath.Join(walDir, "2025-01-02"), 1, "c1\n", "c2\n", "c3\n")
+
+	cfg := Config{
+		ServiceURL:     ts.URL,
+		WALDir:         walDir,
+		StateDir:       filepath.Join(tmpDir, "state"),
+		PollInterval:   time.Millisecond,
+		SendInterval:   time.Hour,
+		HardInterval:   time.Hour,
+		BackoffInitial: time.Millisecond,
+		BackoffMax:     time.Millisecond,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := Drain(ctx, cfg); err != nil {
+		t.Fatalf("Drain() error = %v", err)
+	}
+	if got := rec.count(); got != 6 {
+		t.Errorf("shipped %d frames before Drain returned, want 6", got)
+	}
+
+	st, err := loadState(cfg.StateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lastIdx := filepath.Join(walDir, "2025-01-02", "seg-000001.wal.idx")
+	fi, err := os.Stat(lastIdx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.IdxPath != lastIdx || st.IdxOffset != fi.Size() {
+		t.Errorf("state = %s@%d, want %s@%d", st.IdxPath, st.IdxOffset, lastIdx, fi.Size())
+	}
+
+	// Nothing is left to ship the second time
+	if err := Drain(ctx, cfg); err != nil {
+		t.Fatalf("second Drain() error = %v", err)
+	}
+	if got := rec.count(); got != 6 {
+		t.Errorf("second Drain shipped %d more frames", got-6)
+	}
+}
+
+func TestDrain_BoundedByContext(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	tmpDir := t.TempDir()
+	walDir := filepath.Join(tmpDir, "wal")
+	writeTestSegment(t, walDir, 1, "a1\n")
+
+	cfg := Config{
+		ServiceURL:     ts.URL,
+		WALDir:         walDir,
+		StateDir:       filepath.Join(tmpDir, "state"),
+		PollInterval:   time.Millisecond,
+		BackoffInitial: time.Millisecond,
+		BackoffMax:     time.Millisecond,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := Drain(ctx, cfg); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Drain() error = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestRun_MaxBufferedBytesDuringOutage(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		fails   = 10
+		batches [][]FrameMeta
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != walFramesEndpoint {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if fails > 0 {
+			fails--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var manifest []FrameMeta
+		_ = json.Unmarshal([]byte(r.FormValue("manifest")), &manifest)
+		batches = append(batches, manifest)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	tmpDir := t.TempDir()
+	walDir := filepath.Join(tmpDir, "wal")
+	var payloads []string
+	for i := 0; i < 40; i++ {
+		payloads = append(payloads, fmt.Sprintf("frame-%02d\n", i))
+	}
+	metas := writeTestSegment(t, walDir, 1, payloads...)
+	var maxFrame uint64
+	for _, fm := range metas {
+		if fm.Len > maxFrame {
+			maxFrame = fm.Len
+		}
+	}
+
+	const bound = 128
+	cfg := Config{
+		ServiceURL:       ts.URL,
+		WALDir:           walDir,
+		StateDir:         filepath.Join(tmpDir, "state"),
+		PollInterval:     time.Millisecond,
+		SendInterval:     time.Hour,
+		HardInterval:     time.Hour,
+		MaxBatchBytes:    64,
+		MaxBufferedBytes: bound,
+		BackoffInitial:   time.Millisecond,
+		BackoffMax:       time.Millisecond,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := Drain(ctx, cfg); err != nil {
+		t.Fatalf("Drain() error = %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	var next uint64 = 1
+	for i, b := range batches {
+		var size uint64
+		for _, fm := range b {
+			size += fm.Len
+			if fm.Frame != next {
+				t.Fatalf("batch %d: frame %d out of order, want %d", i, fm.Frame, next)
+			}
+			next++
+		}
+		if size > bound+maxFrame {
+			t.Errorf("batch %d held %d bytes, want at most %d", i, size, bound+maxFrame)
+		}
+	}
+	if next != 41 {
+		t.Errorf("delivered %d frames, want 40", next-1)
+	}
+}
+
+func TestExceedsTimeSpan(t *testing.T) {
+	at := func(sec int64) FrameMeta { return FrameMeta{FirstTS: sec * int64(time.Second)} }
+	batch := []batchFrame{{Meta: at(100)}, {Meta: at(103)}}
+	tests := []struct {
+		name  string
+		batch []batchFrame
+		fm    FrameMeta
+		span  time.Duration
+		want  bool
+	}{
+		{"disabled", batch, at(200), 0, false},
+		{"empty batch", nil, at(200), 5 * time.Second, false},
+		{"within span", batch, at(105), 5 * time.Second, false},
+		{"past span", batch, at(106), 5 * time.Second, true},
+		{"frame without timestamp", batch, FrameMeta{}, 5 * time.Second, false},
+	}
+	for _, tt := range tests {
+		if got := exceedsTimeSpan(tt.batch, tt.fm, tt.span); got != tt.want {
+			t.Errorf("%s: exceedsTimeSpan() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRun_MaxBatchTimeSpanSplits(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		batches [][]uint64
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == walFramesEndpoint {
+			var manifest []FrameMeta
+			_ = json.Unmarshal([]byte(r.FormValue("manifest")), &manifest)
+			var frames []uint64
+			for _, fm := range manifest {
+				frames = append(frames, fm.Frame)
+			}
+			mu.Lock()
+			batches = append(batches, frames)
+			mu.Unlock()
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	tmpDir := t.TempDir()
+	walDir := filepath.Join(tmpDir, "wal")
+	metas := writeTestSegment(t, walDir, 1, "f1\n", "f2\n", "f3\n", "f4\n", "f5\n", "f6\n")
+	// Rewrite the index with synthetic timestamps, in seconds
+	base := time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC).UnixNano()
+	var idx bytes.Buffer
+	for i, sec := range []int64{0, 1, 2, 10, 11, 30} {
+		metas[i].FirstTS = base + sec*int64(time.Second)
+		metas[i].LastTS = metas[i].FirstTS
+		line, _ := json.Marshal(metas[i])
+		idx.Write(append(line, '\n'))
+	}
+	if err := os.WriteFile(filepath.Join(walDir, "seg-000001.wal.idx"), idx.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := Config{
+		ServiceURL:       ts.URL,
+		WALDir:           walDir,
+		StateDir:         filepath.Join(tmpDir, "state"),
+		PollInterval:     time.Millisecond,
+		SendInterval:     time.Hour,
+		HardInterval:     time.Hour,
+		MaxBatchTimeSpan: 5 * time.Second,
+		Once:             true,
+	}
+	if err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	// The first frame goes out alone: nothing has been sent yet, so the
+	// send interval has already elapsed
+	want := [][]uint64{{1}, {2, 3}, {4, 5}, {6}}
+	mu.Lock()
+	defer mu.Unlock()
+	if fmt.Sprint(batches) != fmt.Sprint(want) {
+		t.Errorf("batches = %v, want %v", batches, want)
+	}
+}
+
+func TestRun_EOFFlushesBeforePollWait(t *testing.T) {
+	rec := &frameRecorder{}
+	ts := httptest.NewServer(rec)
+	defer ts.Close()
+
+	tmpDir := t.TempDir()
+	walDir := filepath.Join(tmpDir, "wal")
+	writeTestSegment(t, walDir, 1, "a1\n", "a2\n")
+
+	// Neither interval can trigger the second frame's send within the
+	// test, so only the flush at EOF can ship it before the poll wait
+	cfg := Config{
+		ServiceURL:   ts.URL,
+		WALDir:       walDir,
+		StateDir:     filepath.Join(tmpDir, "state"),
+		PollInterval: time.Hour,
+		SendInterval: time.Hour,
+		HardInterval: time.Hour,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Run(ctx, cfg) }()
+	deadline := time.Now().Add(2 * time.Second)
+	for rec.count() < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	got := rec.count()
+	cancel()
+	<-done
+	if got != 2 {
+		t.Fatalf("shipped %d frames before the poll wait, want 2", got)
+	}
+}
@@ -1,30 +1,27 @@
 package agent
 
 import (
-	"math/rand"
 	"time"
+
+	"github.com/bft-labs/walship/pkg/backoff"
 )
 
-type backoff struct {
-	base time.Duration
-	max  time.Duration
-	cur  time.Duration
+// newBackoff returns the send retry backoff: doubling from base to max,
+// with full jitter so agents that failed together do not retry in
+// lockstep.
+func newBackoff(base, max time.Duration) *backoff.Backoff {
+	return backoff.New(base, max, backoff.WithJitter())
 }
 
-func newBackoff(base, max time.Duration) *backoff { return &backoff{base: base, max: max} }
-
-func (b *backoff) Sleep() {
-	if b.cur <= 0 {
-		b.cur = b.base
-	} else {
-		b.cur *= 2
-		if b.cur > b.max {
-			b.cur = b.max
-		}
+// newConfigBackoff builds the send backoff from cfg, falling back to the
+// defaults for unset values.
+func newConfigBackoff(cfg Config) *backoff.Backoff {
+	base, max := cfg.BackoffInitial, cfg.BackoffMax
+	if base <= 0 {
+		base = DefaultBackoffInitial
 	}
-	// jitter ~ +/-20%
-	j := 0.8 + 0.4*rand.Float64()
-	time.Sleep(time.Duration(float64(b.cur) * j))
+	if max <= 0 {
+		max = DefaultBackoffMax
+	}
+	return newBackoff(base, max)
 }
-
-func (b *backoff) Reset() { b.cur = 0 }
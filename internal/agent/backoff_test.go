@@ -0,0 +1,48 @@
+package agent
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff_FullJitterWithinBounds(t *testing.T) {
+	base, max := 10*time.Millisecond, 80*time.Millisecond
+	b := newBackoff(base, max)
+
+	steps := []time.Duration{10, 20, 40, 80, 80, 80}
+	for i, step := range steps {
+		step *= time.Millisecond
+		d := b.Next()
+		if d < 0 || d > step {
+			t.Fatalf("attempt %d: delay %v outside [0, %v]", i, d, step)
+		}
+	}
+
+	b.Reset()
+	if d := b.Next(); d > base {
+		t.Fatalf("after Reset: delay %v exceeds base %v", d, base)
+	}
+}
+
+func TestBackoff_JitterVaries(t *testing.T) {
+	b := newBackoff(time.Second, time.Second)
+	seen := map[time.Duration]bool{}
+	for i := 0; i < 20; i++ {
+		seen[b.Next()] = true
+	}
+	if len(seen) < 2 {
+		t.Fatalf("expected jittered delays to vary, got %v", seen)
+	}
+}
+
+func TestNewConfigBackoff(t *testing.T) {
+	b := newConfigBackoff(Config{})
+	if b.Initial() != DefaultBackoffInitial || b.Max() != DefaultBackoffMax {
+		t.Fatalf("zero config: got base=%v max=%v, want defaults", b.Initial(), b.Max())
+	}
+
+	b = newConfigBackoff(Config{BackoffInitial: 2 * time.Second, BackoffMax: time.Minute})
+	if b.Initial() != 2*time.Second || b.Max() != time.Minute {
+		t.Fatalf("got base=%v max=%v, want 2s/1m", b.Initial(), b.Max())
+	}
+}
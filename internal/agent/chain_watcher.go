@@ -0,0 +1,186 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const chainFilesEndpoint = "/v1/ingest/chain-files"
+
+// ChainWatcher monitors genesis.json and upgrade-info.json via fsnotify so
+// chain upgrades can be tracked alongside the node's configuration.
+type ChainWatcher struct {
+	cfg        *Config
+	httpClient *http.Client
+
+	mu       sync.Mutex
+	debounce timer
+	clock    clock
+}
+
+func NewChainWatcher(cfg *Config) *ChainWatcher {
+	return &ChainWatcher{
+		cfg:        cfg,
+		httpClient: newHTTPClient(30*time.Second, cfg),
+		clock:      realClock{},
+	}
+}
+
+// Run watches $NODE_HOME/config and $NODE_HOME/data and sends the chain
+// files to {ServiceURL}{ChainFilesEndpoint} on start and on every change.
+func (w *ChainWatcher) Run(ctx context.Context) {
+	if w.cfg.NodeHome == "" || w.cfg.ServiceURL == "" {
+		return
+	}
+	if err := w.cfg.checkServiceScheme(); err != nil {
+		logger.Error().Err(err).Msg("chain watcher: not starting")
+		return
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		logger.Error().Err(err).Msg("chain watcher: failed to create watcher")
+		return
+	}
+	defer watcher.Close()
+
+	dirs, failed := newDirWatch(watcher, []string{filepath.Dir(w.genesisPath()), filepath.Dir(w.upgradeInfoPath())})
+	for dir, err := range failed {
+		logger.Error().Err(err).Str("dir", dir).Msg("chain watcher: failed to watch; retrying")
+	}
+	retry := w.clock.NewTicker(watchRetryInterval)
+	defer retry.Stop()
+
+	w.sendWithRetry(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-retry.C():
+			// Resend on reattach; changes while unwatched were missed
+			if back := dirs.retry(); len(back) > 0 {
+				logger.Info().Strs("dirs", back).Msg("chain watcher: watching again")
+				w.sendWithRetry(ctx)
+			}
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if dirs.lost(event) {
+				logger.Warn().Str("dir", event.Name).Msg("chain watcher: watched directory removed; waiting for it")
+				continue
+			}
+			filename := filepath.Base(event.Name)
+			if filename != "genesis.json" && filename != "upgrade-info.json" {
+				continue
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) == 0 {
+				continue
+			}
+			w.debounceSend(ctx, 500*time.Millisecond)
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			logger.Error().Err(err).Msg("chain watcher: watcher error")
+		}
+	}
+}
+
+func (w *ChainWatcher) debounceSend(ctx context.Context, delay time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.debounce != nil {
+		w.debounce.Stop()
+	}
+
+	w.debounce = w.clock.AfterFunc(delay, func() {
+		w.sendWithRetry(ctx)
+	})
+}
+
+func (w *ChainWatcher) genesisPath() string {
+	return filepath.Join(w.cfg.NodeHome, "config", "genesis.json")
+}
+
+func (w *ChainWatcher) upgradeInfoPath() string {
+	return filepath.Join(w.cfg.NodeHome, "data", "upgrade-info.json")
+}
+
+func (w *ChainWatcher) url() string {
+	endpoint := w.cfg.ChainFilesEndpoint
+	if endpoint == "" {
+		endpoint = chainFilesEndpoint
+	}
+	return w.cfg.serviceBase() + endpoint
+}
+
+// buildMultipartPayload builds multipart form-data with the chain files and
+// captured_at timestamp. A file that cannot be read is reported as an
+// error code field instead.
+func (w *ChainWatcher) buildMultipartPayload() (*bytes.Buffer, string) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+
+	writer.WriteField("captured_at", w.clock.Now().UTC().Format(time.RFC3339Nano))
+
+	files := []struct {
+		field, name, path string
+	}{
+		{"genesis", "genesis.json", w.genesisPath()},
+		{"upgrade_info", "upgrade-info.json", w.upgradeInfoPath()},
+	}
+	for _, f := range files {
+		content, err := os.ReadFile(f.path)
+		if err != nil {
+			writer.WriteField(f.field+"_error", fileErrorCode(err))
+		} else if part, err := writer.CreateFormFile(f.field, f.name); err == nil {
+			part.Write(content)
+		}
+	}
+
+	contentType := writer.FormDataContentType()
+	writer.Close()
+
+	return &buf, contentType
+}
+
+// sendWithRetry retries until success or context cancellation.
+// Snapshot is captured once at start to preserve history.
+func (w *ChainWatcher) sendWithRetry(ctx context.Context) {
+	const retryInterval = 5 * time.Second
+	retryCount := 0
+
+	snapshot, contentType := w.buildMultipartPayload()
+	snapshotBytes := snapshot.Bytes()
+
+	for {
+		err := postSnapshot(ctx, w.httpClient, w.cfg, w.url(), bytes.NewReader(snapshotBytes), contentType)
+		if err == nil {
+			logger.Info().Int("retries", retryCount).Msg("chain watcher: sent chain files")
+			return
+		}
+
+		retryCount++
+		logger.Error().Err(err).Int("retry", retryCount).Dur("retry_in", retryInterval).Msg("chain watcher: send failed")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.clock.After(retryInterval):
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package agent
+
+import (
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// chainUploads records the multipart fields of each chain file upload.
+type chainUploads struct {
+	mu      sync.Mutex
+	paths   []string
+	uploads []map[string]string
+}
+
+func (c *chainUploads) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fields := map[string]string{}
+	_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+		data, _ := io.ReadAll(part)
+		fields[part.FormName()] = string(data)
+	}
+	fields["auth"] = r.Header.Get("Authorization")
+	c.mu.Lock()
+	c.paths = append(c.paths, r.URL.Path)
+	c.uploads = append(c.uploads, fields)
+	c.mu.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+func (c *chainUploads) last() (string, map[string]string, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.uploads) == 0 {
+		return "", nil, 0
+	}
+	return c.paths[len(c.paths)-1], c.uploads[len(c.uploads)-1], len(c.uploads)
+}
+
+func TestChainWatcher_DetectsChanges(t *testing.T) {
+	home := t.TempDir()
+	for _, d := range []string{"config", "data"} {
+		if err := os.MkdirAll(filepath.Join(home, d), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(home, "config", "genesis.json"), []byte(`{"chain_id":"test"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := &chainUploads{}
+	ts := httptest.NewServer(rec)
+	defer ts.Close()
+
+	cfg := &Config{NodeHome: home, ServiceURL: ts.URL, AuthKey: "secret", AllowInsecure: true, ChainID: "test", NodeID: "node"}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go NewChainWatcher(cfg).Run(ctx)
+
+	time.Sleep(200 * time.Millisecond)
+	path, fields, n := rec.last()
+	if n != 1 {
+		t.Fatalf("initial uploads = %d, want 1", n)
+	}
+	if path != chainFilesEndpoint {
+		t.Errorf("path = %s, want %s", path, chainFilesEndpoint)
+	}
+	if fields["genesis"] != `{"chain_id":"test"}` || fields["auth"] != "Bearer secret" {
+		t.Errorf("initial upload = %v", fields)
+	}
+	if fields["upgrade_info_error"] != ErrCodeFileNotFound {
+		t.Errorf("upgrade_info_error = %q, want %s", fields["upgrade_info_error"], ErrCodeFileNotFound)
+	}
+
+	// The node writes upgrade-info.json when it halts for an upgrade.
+	upgrade := `{"name":"v2","height":100}`
+	if err := os.WriteFile(filepath.Join(home, "data", "upgrade-info.json"), []byte(upgrade), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(800 * time.Millisecond)
+
+	_, fields, n = rec.last()
+	if n != 2 {
+		t.Fatalf("uploads after change = %d, want 2 (debounced)", n)
+	}
+	if fields["upgrade_info"] != upgrade {
+		t.Errorf("upgrade_info = %q, want %q", fields["upgrade_info"], upgrade)
+	}
+	if _, ok := fields["upgrade_info_error"]; ok {
+		t.Error("upgrade_info_error still set after the file appeared")
+	}
+}
+
+func TestChainWatcher_MissingFiles(t *testing.T) {
+	rec := &chainUploads{}
+	ts := httptest.NewServer(rec)
+	defer ts.Close()
+
+	// No config or data directory at all: report codes, do not crash.
+	cfg := &Config{NodeHome: t.TempDir(), ServiceURL: ts.URL, ChainFilesEndpoint: "/custom/chain"}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go NewChainWatcher(cfg).Run(ctx)
+
+	time.Sleep(200 * time.Millisecond)
+	path, fields, n := rec.last()
+	if n != 1 {
+		t.Fatalf("uploads = %d, want 1", n)
+	}
+	if path != "/custom/chain" {
+		t.Errorf("path = %s, want /custom/chain", path)
+	}
+	if fields["genesis_error"] != ErrCodeFileNotFound || fields["upgrade_info_error"] != ErrCodeFileNotFound {
+		t.Errorf("fields = %v, want FILE_NOT_FOUND for both files", fields)
+	}
+	if fields["captured_at"] == "" {
+		t.Error("captured_at missing")
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -22,6 +23,7 @@ var (
 
 type walSegment struct {
 	day     string
+	num     int
 	gzPath  string
 	idxPath string
 	gzSize  int64
@@ -32,7 +34,7 @@ type walSegment struct {
 // directory grows beyond the high watermark. It removes the oldest segments
 // (by day dir then segment number) until the directory shrinks below the low
 // watermark, deleting the matching .idx alongside each .gz.
-func walCleanupLoop(ctx context.Context, walDir, stateDir string) {
+func walCleanupLoop(ctx context.Context, clk clock, walDir, stateDir string) {
 	if walDir == "" {
 		return
 	}
@@ -41,19 +43,31 @@ func walCleanupLoop(ctx context.Context, walDir, stateDir string) {
 		walCleanupOnce(ctx, walDir, stateDir)
 	}
 
-	t := time.NewTicker(walCleanupCheckInterval)
+	t := clk.NewTicker(walCleanupCheckInterval)
 	defer t.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-t.C:
+		case <-t.C():
 			walCleanupOnce(ctx, walDir, stateDir)
 		}
 	}
 }
 
+// startWALCleanup runs walCleanupLoop for each of dirs, each against its
+// own watermarks, as goroutines tracked by bg.
+func startWALCleanup(ctx context.Context, bg *sync.WaitGroup, clk clock, dirs []string, stateDir string) {
+	for _, dir := range dirs {
+		bg.Add(1)
+		go func(dir string) {
+			defer bg.Done()
+			walCleanupLoop(ctx, clk, dir, stateDir)
+		}(dir)
+	}
+}
+
 func walCleanupOnce(ctx context.Context, walDir, stateDir string) {
 	curSize, err := walDirSize(walDir)
 	if err != nil {
@@ -236,7 +250,7 @@ func getSegment(m map[int]*walSegment, num int) *walSegment {
 	if seg, ok := m[num]; ok {
 		return seg
 	}
-	seg := &walSegment{}
+	seg := &walSegment{num: num}
 	m[num] = seg
 	return seg
 }
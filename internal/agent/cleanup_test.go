@@ -5,6 +5,7 @@ import (
 	"context"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 )
@@ -152,3 +153,88 @@ func patchCleanupThresholds(high, low int64) func() {
 		walCleanupTickerNow = prevNow
 	}
 }
+
+func TestWalCleanupLoop_RunsOnTick(t *testing.T) {
+	tmp := t.TempDir()
+	walDir := filepath.Join(tmp, "wal")
+	restore := patchCleanupThresholds(300, 150)
+	t.Cleanup(restore)
+	prevNow := walCleanupTickerNow
+	walCleanupTickerNow = false
+	t.Cleanup(func() { walCleanupTickerNow = prevNow })
+
+	createSegment(t, filepath.Join(walDir, "2025-12-05"), "seg-000001", 200, 10)
+	createSegment(t, filepath.Join(walDir, "2025-12-06"), "seg-000001", 200, 10)
+	oldest := filepath.Join(walDir, "2025-12-05", "seg-000001.wal.gz")
+
+	clk := newFakeClock()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		walCleanupLoop(ctx, clk, walDir, walDir)
+	}()
+	// Registered last, so it runs before the globals are restored
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	// Wait for the loop to register its ticker
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		clk.mu.Lock()
+		n := len(clk.waiters)
+		clk.mu.Unlock()
+		if n == 1 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	clk.Advance(walCleanupCheckInterval - time.Second)
+	time.Sleep(20 * time.Millisecond)
+	if !pathExists(oldest) {
+		t.Fatal("cleanup ran before the check interval elapsed")
+	}
+
+	clk.Advance(time.Second)
+	for pathExists(oldest) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if pathExists(oldest) {
+		t.Fatal("cleanup did not run on the tick")
+	}
+}
+
+func TestStartWALCleanup_EveryDir(t *testing.T) {
+	tmp := t.TempDir()
+	dirs := []string{filepath.Join(tmp, "wal"), filepath.Join(tmp, "wal-old")}
+	restore := patchCleanupThresholds(300, 150)
+	t.Cleanup(restore)
+
+	var oldest []string
+	for _, dir := range dirs {
+		createSegment(t, filepath.Join(dir, "2025-12-05"), "seg-000001", 200, 10)
+		createSegment(t, filepath.Join(dir, "2025-12-06"), "seg-000001", 200, 10)
+		oldest = append(oldest, filepath.Join(dir, "2025-12-05", "seg-000001.wal.gz"))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var bg sync.WaitGroup
+	startWALCleanup(ctx, &bg, newFakeClock(), dirs, tmp)
+	// Registered last, so it runs before the globals are restored
+	t.Cleanup(func() {
+		cancel()
+		bg.Wait()
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for _, p := range oldest {
+		for pathExists(p) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s was not trimmed", p)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package agent
+
+import "time"
+
+// clock is the source of time for the agent's timers. Components default to
+// realClock; tests substitute a fake to drive intervals without sleeping.
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	NewTicker(d time.Duration) ticker
+	AfterFunc(d time.Duration, f func()) timer
+}
+
+// ticker is the part of *time.Ticker the agent uses.
+type ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// timer is the part of *time.Timer the agent uses.
+type timer interface {
+	Stop() bool
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                            { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time    { return time.After(d) }
+func (realClock) NewTicker(d time.Duration) ticker          { return realTicker{time.NewTicker(d)} }
+func (realClock) AfterFunc(d time.Duration, f func()) timer { return time.AfterFunc(d, f) }
+
+type realTicker struct{ t *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.t.C }
+func (t realTicker) Stop()               { t.t.Stop() }
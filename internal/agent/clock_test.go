@@ -0,0 +1,109 @@
+package agent
+
+import (
+	"sync"
+	"time"
+)
+
+// fakeClock is a clock that only moves when Advance is called. AfterFunc
+// callbacks run synchronously inside Advance.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+type fakeWaiter struct {
+	at      time.Time
+	period  time.Duration // non-zero for tickers
+	ch      chan time.Time
+	f       func()
+	stopped bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) add(d, period time.Duration, f func()) *fakeWaiter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w := &fakeWaiter{at: c.now.Add(d), period: period, ch: make(chan time.Time, 1), f: f}
+	c.waiters = append(c.waiters, w)
+	return w
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time { return c.add(d, 0, nil).ch }
+
+func (c *fakeClock) NewTicker(d time.Duration) ticker { return fakeTicker{c, c.add(d, d, nil)} }
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) timer { return fakeTimer{c, c.add(d, 0, f)} }
+
+// Advance moves the clock forward by d, firing everything that falls due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	var funcs []func()
+	kept := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.stopped {
+			continue
+		}
+		if w.at.After(c.now) {
+			kept = append(kept, w)
+			continue
+		}
+		if w.f != nil {
+			funcs = append(funcs, w.f)
+		} else {
+			select {
+			case w.ch <- c.now:
+			default: // like time.Ticker, drop ticks nobody reads
+			}
+		}
+		if w.period > 0 {
+			for !w.at.After(c.now) {
+				w.at = w.at.Add(w.period)
+			}
+			kept = append(kept, w)
+		}
+	}
+	c.waiters = kept
+	c.mu.Unlock()
+	for _, f := range funcs {
+		f()
+	}
+}
+
+func (c *fakeClock) stop(w *fakeWaiter) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, x := range c.waiters {
+		if x == w && !w.stopped {
+			w.stopped = true
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTicker struct {
+	c *fakeClock
+	w *fakeWaiter
+}
+
+func (t fakeTicker) C() <-chan time.Time { return t.w.ch }
+func (t fakeTicker) Stop()               { t.c.stop(t.w) }
+
+type fakeTimer struct {
+	c *fakeClock
+	w *fakeWaiter
+}
+
+func (t fakeTimer) Stop() bool { return t.c.stop(t.w) }
@@ -0,0 +1,56 @@
+package agent
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+)
+
+// BatchCodec transforms the assembled multipart body of a batch before it
+// is sent, e.g. to recompress it with an encoding the service accepts. It
+// operates on the whole request body, not on individual frames.
+type BatchCodec interface {
+	// ContentEncoding is sent as the Content-Encoding header of encoded
+	// requests. An empty value sends no header.
+	ContentEncoding() string
+	// Encode returns the encoded form of body.
+	Encode(body []byte) ([]byte, error)
+}
+
+// encodeBatch applies codec to body. A nil codec passes body through.
+func encodeBatch(codec BatchCodec, body []byte) ([]byte, string, error) {
+	if codec == nil {
+		return body, "", nil
+	}
+	enc, err := codec.Encode(body)
+	if err != nil {
+		return nil, "", err
+	}
+	return enc, codec.ContentEncoding(), nil
+}
+
+// gunzipFrame decompresses a frame's gzip member, for SendUncompressed.
+func gunzipFrame(b []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+// gzipBytes compresses b as a single gzip member at level.
+func gzipBytes(b []byte, level int) ([]byte, error) {
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(b); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
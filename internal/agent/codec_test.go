@@ -0,0 +1,260 @@
+package agent
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// reverseCodec is a stand-in transcoder whose output is only readable by
+// a server that knows to undo it.
+type reverseCodec struct{}
+
+func (reverseCodec) ContentEncoding() string { return "x-reverse" }
+
+func (reverseCodec) Encode(body []byte) ([]byte, error) {
+	out := make([]byte, len(body))
+	for i, b := range body {
+		out[len(body)-1-i] = b
+	}
+	return out, nil
+}
+
+func TestTrySend_BatchCodecRoundTrip(t *testing.T) {
+	var (
+		gotEncoding string
+		gotFrames   []byte
+		gotManifest []FrameMeta
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotEncoding = r.Header.Get("Content-Encoding")
+		raw, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("read body: %v", err)
+			return
+		}
+		body, _ := reverseCodec{}.Encode(raw) // reversing twice restores the body
+
+		_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil {
+			t.Errorf("parse content-type: %v", err)
+			return
+		}
+		mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				t.Errorf("multipart read: %v", err)
+				return
+			}
+			data, _ := io.ReadAll(part)
+			switch part.FormName() {
+			case "manifest":
+				if err := json.Unmarshal(data, &gotManifest); err != nil {
+					t.Errorf("manifest: %v", err)
+				}
+			case "frames":
+				gotFrames = data
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	cfg := Config{ServiceURL: ts.URL, StateDir: t.TempDir(), BatchCodec: reverseCodec{}}
+	batch := []batchFrame{
+		{Meta: FrameMeta{File: "seg-000001.wal.gz", Frame: 1}, Compressed: []byte("first-"), IdxLineLen: 10},
+		{Meta: FrameMeta{File: "seg-000001.wal.gz", Frame: 2}, Compressed: []byte("second"), IdxLineLen: 10},
+	}
+	batchBytes := 12
+	st := state{}
+	var ss sendState
+	back := newBackoff(time.Millisecond, time.Millisecond)
+
+	if err := trySend(context.Background(), cfg, http.DefaultClient, &batch, &batchBytes, &st, &ss, "seg-000001.wal.idx", nil, false, back); err != nil {
+		t.Fatalf("trySend() error = %v", err)
+	}
+	if gotEncoding != "x-reverse" {
+		t.Errorf("Content-Encoding = %q, want x-reverse", gotEncoding)
+	}
+	if string(gotFrames) != "first-second" {
+		t.Errorf("frames = %q, want first-second", gotFrames)
+	}
+	if len(gotManifest) != 2 || gotManifest[1].Frame != 2 {
+		t.Errorf("manifest = %+v, want frames 1 and 2", gotManifest)
+	}
+	if st.LastFrame != 2 {
+		t.Errorf("LastFrame = %d, want 2", st.LastFrame)
+	}
+}
+
+func TestTrySend_NoCodecSendsNoEncoding(t *testing.T) {
+	gotEncoding := "unset"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotEncoding = r.Header.Get("Content-Encoding")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	cfg := Config{ServiceURL: ts.URL, StateDir: t.TempDir()}
+	batch := []batchFrame{{Meta: FrameMeta{File: "a.gz", Frame: 1}, Compressed: []byte("x"), IdxLineLen: 1}}
+	batchBytes := 1
+	st := state{}
+	var ss sendState
+	back := newBackoff(time.Millisecond, time.Millisecond)
+
+	if err := trySend(context.Background(), cfg, http.DefaultClient, &batch, &batchBytes, &st, &ss, "a.idx", nil, false, back); err != nil {
+		t.Fatalf("trySend() error = %v", err)
+	}
+	if gotEncoding != "" {
+		t.Errorf("Content-Encoding = %q, want none", gotEncoding)
+	}
+}
+
+func TestTrySend_ManifestGzipLevel(t *testing.T) {
+	var (
+		encoding string
+		raw      []byte
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		for {
+			p, err := mr.NextPart()
+			if err != nil {
+				break
+			}
+			if p.FormName() == "manifest" {
+				encoding = p.Header.Get("Content-Encoding")
+				raw, _ = io.ReadAll(p)
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	var batch []batchFrame
+	for i := 0; i < 200; i++ {
+		batch = append(batch, batchFrame{Meta: FrameMeta{File: "seg-000001.wal.gz", Frame: uint64(i + 1), Off: uint64(i * 100), Len: 100}, Compressed: []byte("x"), IdxLineLen: 10})
+	}
+	batchBytes := len(batch)
+	st := state{}
+	var ss sendState
+	cfg := Config{ServiceURL: ts.URL, ManifestGzipLevel: gzip.BestCompression}
+	if err := trySend(context.Background(), cfg, http.DefaultClient, &batch, &batchBytes, &st, &ss, "seg-000001.wal.idx", nil, false, newBackoff(time.Millisecond, time.Millisecond)); err != nil {
+		t.Fatalf("trySend() error = %v", err)
+	}
+
+	if encoding != "gzip" {
+		t.Fatalf("manifest Content-Encoding = %q, want gzip", encoding)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("manifest is not gzip: %v", err)
+	}
+	manifestJSON, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest []FrameMeta
+	if err := json.Unmarshal(manifestJSON, &manifest); err != nil || len(manifest) != 200 {
+		t.Fatalf("manifest = %d frames (err %v), want 200", len(manifest), err)
+	}
+
+	// The requested level, not the default, produced the part
+	for level, wantSame := range map[int]bool{gzip.BestCompression: true, gzip.BestSpeed: false} {
+		ref, err := gzipBytes(manifestJSON, level)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(ref, raw) != wantSame {
+			t.Errorf("manifest matches level %d compression = %v, want %v", level, !wantSame, wantSame)
+		}
+	}
+}
+
+func TestRun_SendUncompressed(t *testing.T) {
+	payloads := []string{"first frame\n", "second\nframe\n", "third\n"}
+	var (
+		got      []string
+		encoding string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != walFramesEndpoint {
+			return
+		}
+		encoding = r.Header.Get("X-Frames-Encoding")
+		_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		var (
+			manifest []manifestFrame
+			frames   []byte
+		)
+		for {
+			p, err := mr.NextPart()
+			if err != nil {
+				break
+			}
+			data, _ := io.ReadAll(p)
+			switch p.FormName() {
+			case "manifest":
+				if err := json.Unmarshal(data, &manifest); err != nil {
+					t.Errorf("decode manifest: %v", err)
+				}
+			case "frames":
+				frames = data
+			}
+		}
+		// Split the frames part by each entry's raw length
+		for _, m := range manifest {
+			if !m.Raw || m.RawLen > uint64(len(frames)) {
+				t.Errorf("manifest entry %+v: want raw with a length within the part", m)
+				return
+			}
+			got = append(got, string(frames[:m.RawLen]))
+			frames = frames[m.RawLen:]
+		}
+	}))
+	defer ts.Close()
+
+	tmpDir := t.TempDir()
+	walDir := filepath.Join(tmpDir, "wal")
+	writeTestSegment(t, walDir, 1, payloads...)
+	cfg := Config{
+		ServiceURL:       ts.URL,
+		WALDir:           walDir,
+		StateDir:         filepath.Join(tmpDir, "state"),
+		PollInterval:     time.Millisecond,
+		SendInterval:     time.Hour,
+		HardInterval:     time.Hour,
+		Once:             true,
+		SendUncompressed: true,
+	}
+	if err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if encoding != "identity" {
+		t.Errorf("X-Frames-Encoding = %q, want identity", encoding)
+	}
+	if len(got) != len(payloads) {
+		t.Fatalf("received %q, want %q", got, payloads)
+	}
+	for i := range payloads {
+		if got[i] != payloads[i] {
+			t.Errorf("frame %d = %q, want %q", i, got[i], payloads[i])
+		}
+	}
+}
@@ -1,15 +1,25 @@
 package agent
 
 import (
+	"compress/gzip"
 	"fmt"
+	"io"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
 // DefaultServiceURL is the default endpoint for shipping WAL data.
 const DefaultServiceURL = "https://api.apphash.io"
 
+// Default bounds for the retry backoff after a failed send.
+const (
+	DefaultBackoffInitial = 500 * time.Millisecond
+	DefaultBackoffMax     = 10 * time.Second
+)
+
 // FrameMeta matches tools/memlogger/writer.go schema for index lines.
 // Fields are used to locate and read gzip members from the .gz file.
 type FrameMeta struct {
@@ -47,6 +57,301 @@ type Config struct {
 	Verify         bool
 	Meta           bool
 	Once           bool
+
+	// StateSaveInterval bounds how often status.json is rewritten while
+	// the agent is behind. Commits in between are kept in memory, so at most
+	// this much progress is replayed after a crash. State is always written
+	// when the reader catches up and on shutdown. Zero writes on every send.
+	StateSaveInterval time.Duration
+
+	// BackoffInitial and BackoffMax bound the exponential backoff between
+	// failed sends. Each retry waits a random duration up to the current step.
+	BackoffInitial time.Duration
+	BackoffMax     time.Duration
+
+	// FlushTimeout bounds the final send of the pending batch when Run's
+	// context is cancelled. Frames not confirmed within it are left for the
+	// next start. Zero skips the final flush.
+	FlushTimeout time.Duration
+
+	// IdleThreshold is how long polls must keep hitting EOF before OnIdle is
+	// called; it is called again after each further IdleThreshold while the
+	// agent stays idle. Zero disables idle reporting.
+	IdleThreshold time.Duration
+	// OnIdle, if set, is called from the read loop when the agent is caught
+	// up and has had nothing to ship for IdleThreshold. It distinguishes an
+	// idle chain from a stuck agent and must not block.
+	OnIdle func(IdleEvent) `json:"-"`
+
+	// BatchCodec, if set, encodes each assembled multipart body before it
+	// is sent. Nil sends the body as is.
+	BatchCodec BatchCodec `json:"-"`
+
+	// ExtraWALDirs are shipped after WALDir, in order. Each directory's
+	// segments are exhausted before moving on to the next.
+	ExtraWALDirs []string
+
+	// FrameFilter, if set, is called for every indexed frame before it is
+	// read; frames for which it returns false are skipped but still count
+	// as consumed, so they are not re-read after a restart.
+	FrameFilter func(FrameMeta) bool `json:"-"`
+
+	// PartNames overrides the multipart field names used for uploads, for
+	// ingest servers that expect different names. Empty names keep the
+	// defaults.
+	PartNames PartNames
+
+	// LenientIndex disables index line validation: lines without a file or
+	// length are not rejected.
+	LenientIndex bool
+
+	// StrictIndexFields rejects index lines with fields walship does not
+	// know. Off by default, so a node writing newer index lines keeps
+	// shipping. Ignored with LenientIndex.
+	StrictIndexFields bool
+
+	// WatchWAL wakes the reader on fsnotify events for the current segment
+	// and WAL directory instead of polling every PollInterval while idle.
+	// It falls back to polling if fsnotify is unavailable.
+	WatchWAL bool
+
+	// StrictFrameBounds checks that every frame's [off, off+len) holds
+	// exactly one gzip member, and stops Run with ErrFrameBounds otherwise.
+	StrictFrameBounds bool
+
+	// WatchChainFiles uploads config/genesis.json and data/upgrade-info.json
+	// from NodeHome on start and whenever they change, to track upgrades.
+	WatchChainFiles bool
+	// ChainFilesEndpoint overrides the path the chain files are posted to,
+	// relative to ServiceURL. Defaults to /v1/ingest/chain-files.
+	ChainFilesEndpoint string
+
+	// AppConfigPath and CometConfigPath locate the app and CometBFT config
+	// files the config watcher uploads, relative to NodeHome, for chains
+	// that keep them elsewhere. They default to config/app.toml and
+	// config/config.toml.
+	AppConfigPath   string
+	CometConfigPath string
+
+	// MaxFrameBytes caps the compressed size of a single frame. Larger
+	// frames are skipped, without being read into memory, and reported to
+	// OnFrameTooLarge. Zero means unlimited.
+	MaxFrameBytes int
+	// OnFrameTooLarge, if set, is called for every frame skipped because
+	// of MaxFrameBytes. It must not block.
+	OnFrameTooLarge func(FrameTooLargeEvent) `json:"-"`
+
+	// KeepAlive is how long an idle connection to the service is kept open
+	// between sends, and the TCP keep-alive period. Zero uses the net/http
+	// defaults.
+	KeepAlive time.Duration
+
+	// OnSourceUnavailable, if set, is called when the WAL directory being
+	// shipped disappears. Run waits for it to come back, checking with a
+	// growing interval, and resumes from the saved position or the oldest
+	// index. It must not block.
+	OnSourceUnavailable func(SourceUnavailableEvent) `json:"-"`
+
+	// ManifestGzipLevel, if non-zero, gzips the manifest part of each
+	// upload at this level (gzip.BestSpeed to gzip.BestCompression) and
+	// marks the part Content-Encoding: gzip. Frames are already gzipped by
+	// the memlogger and are sent as is.
+	ManifestGzipLevel int
+
+	// ManifestEncoder serializes the manifest part of each upload, e.g.
+	// CBORManifestEncoder for ingests that prefer it over JSON. The part's
+	// Content-Type follows the encoder. Nil uses JSONManifestEncoder.
+	ManifestEncoder ManifestEncoder `json:"-"`
+
+	// MinDay (YYYY-MM-DD), if set, makes the reader ignore day directories
+	// before it when finding the oldest index and when advancing, e.g. stale
+	// empty directories left behind by external pruning.
+	MinDay string
+
+	// UserAgent overrides the User-Agent header of every request. Empty
+	// sends walship/<version> with the chain, node and platform.
+	UserAgent string
+
+	// ProxyURL, if set, sends every request through this proxy, except to
+	// hosts listed in NO_PROXY. Empty uses HTTP_PROXY, HTTPS_PROXY and
+	// NO_PROXY from the environment.
+	ProxyURL string
+
+	// MaxSendsPerSecond caps how often batches are sent, on top of the
+	// load-based CPU and network gating. A send beyond the rate waits for
+	// the rate to allow it or for HardInterval, whichever comes first.
+	// Zero means unlimited.
+	MaxSendsPerSecond float64
+
+	// OnReadError, if set, is called for every ReadErrorEvent. It must not
+	// block.
+	OnReadError func(ReadErrorEvent) `json:"-"`
+
+	// Labels are attached to every upload, frames and snapshots alike, as
+	// X-Walship-Label-<key> headers, e.g. datacenter or validator moniker.
+	// Keys may use letters, digits and '-' and are not case-sensitive.
+	Labels map[string]string
+
+	// OnMissingState decides what Run does when the saved position names
+	// an index that no longer exists: MissingStateFail (the default when
+	// empty), MissingStateOldest or MissingStateLatest.
+	OnMissingState string
+	// OnStateRecovered, if set, is called when Run recovers from a missing
+	// index under OnMissingState. It must not block.
+	OnStateRecovered func(StateRecoveredEvent) `json:"-"`
+
+	// MaxBufferedBytes bounds the compressed bytes held in memory while
+	// sends fail or are held back. At the bound Run stops reading and
+	// retries the pending batch; the frames not yet read stay in the WAL
+	// and are shipped in order once the service recovers. The bound can be
+	// exceeded by one frame. Zero means unbounded.
+	MaxBufferedBytes int
+
+	// MaxBatchTimeSpan sends the pending batch before adding a frame whose
+	// FirstTS (Unix nanoseconds) is more than this after the batch's first
+	// frame, so a batch does not straddle a large time gap. Zero disables.
+	MaxBatchTimeSpan time.Duration
+
+	// NodeInfoResolver, if set, is used by LoadNodeInfo to derive ChainID
+	// and NodeID from NodeHome instead of the CometBFT files.
+	NodeInfoResolver NodeInfoResolver `json:"-"`
+
+	// HTTPTrace, if set, receives a dump of every request the agent makes
+	// and of its response: method, URL, headers with Authorization masked,
+	// status and up to 4KB of the response body. It is verbose and meant
+	// for diagnosing rejected uploads; leave it nil in production.
+	HTTPTrace io.Writer `json:"-"`
+
+	// MaxLifetime, if set, makes Run restart itself after this long: it
+	// flushes and stops like a cancelled Run, then starts again from the
+	// saved state. A safety net for long-running agents; zero disables.
+	MaxLifetime time.Duration
+	// OnRestart, if set, is called after each restart due to MaxLifetime.
+	// It must not block.
+	OnRestart func(RestartEvent) `json:"-"`
+
+	// ProbeBeforeRetry, if set, asks the service whether a batch whose
+	// upload timed out was accepted before sending it again, so a slow
+	// response does not ship the frames twice. The probe is a HEAD of
+	// StatusEndpoint with the batch's Idempotency-Key: 2xx means accepted,
+	// anything else, or a failed probe, leads to a normal retry.
+	ProbeBeforeRetry bool
+	// StatusEndpoint overrides the path probed, relative to ServiceURL.
+	// Defaults to /v1/ingest/wal-frames/status.
+	StatusEndpoint string
+
+	// DNSBackoff is how long to wait after a send fails because the
+	// service host does not resolve, in place of the regular backoff, which
+	// would retry a resolver outage in a tight burst. Zero uses the regular
+	// backoff.
+	DNSBackoff time.Duration
+	// OnDNSError, if set, is called for each send that fails to resolve
+	// the service host. It must not block.
+	OnDNSError func(DNSErrorEvent) `json:"-"`
+
+	// OnSendError, if set, is called after each failed batch upload, once
+	// the backoff before the retry has passed, with the attempt number and
+	// the run's retry totals. It must not block.
+	OnSendError func(SendErrorEvent) `json:"-"`
+
+	// SendLatency, if set, records how long each successful batch upload
+	// took, from request to response, for percentile tracking.
+	SendLatency *LatencyRecorder `json:"-"`
+
+	// SelfCheckInterval, if set, makes Run compare the index offset it
+	// accounts for with the reader's real position this often, logging
+	// and calling OnOffsetDrift if they differ. A cheap guard for long
+	// runs; zero disables.
+	SelfCheckInterval time.Duration
+	// OnOffsetDrift, if set, is called for each failed self-check. It
+	// must not block.
+	OnOffsetDrift func(OffsetDriftEvent) `json:"-"`
+
+	// HeartbeatInterval, if set, makes Run post a small heartbeat with
+	// the chain and node IDs and the read position whenever nothing has
+	// been sent for this long, so the service does not mark a node on a
+	// quiet chain as down. Zero disables.
+	HeartbeatInterval time.Duration
+	// HeartbeatEndpoint overrides the path heartbeats are posted to,
+	// relative to ServiceURL. Defaults to /v1/ingest/heartbeat.
+	HeartbeatEndpoint string
+
+	// DisableLock skips the exclusive lock Run takes on StateDir
+	// (status.lock), which makes a second agent on the same directory fail
+	// with ErrAlreadyLocked instead of corrupting status.json.
+	DisableLock bool
+
+	// SendUncompressed decompresses each frame before batching and sends
+	// the raw bytes, for ingest services that do not want to gunzip. The
+	// manifest marks such frames "raw" with their raw_len, and the request
+	// carries X-Frames-Encoding: identity. Costs CPU and bandwidth.
+	SendUncompressed bool
+
+	// AllowInsecure permits sending AuthKey to a plaintext http://
+	// ServiceURL, e.g. for a test service on localhost. Without it Validate
+	// rejects any ServiceURL other than https:// or unix:// when AuthKey is
+	// set, so the key is not leaked over the network.
+	AllowInsecure bool
+
+	// WALNodeIndex, if set, makes Validate derive WALDir as
+	// <NodeHome>/data/log.wal/node-<index> instead of from NodeID, for
+	// layouts that number their WAL subdirectories. An explicit WALDir
+	// still takes precedence.
+	WALNodeIndex *int
+
+	// SanitizeTimestamps normalizes frame timestamps that are zero, in the
+	// future or earlier than the previous frame's (a bad node clock)
+	// before batching: future values are clamped to now, the others to the
+	// previous frame's. Such frames are logged and reported to
+	// OnTimestampSkew either way.
+	SanitizeTimestamps bool
+	// OnTimestampSkew, if set, is called for each frame with skewed
+	// timestamps. It must not block.
+	OnTimestampSkew func(TimestampSkewEvent) `json:"-"`
+
+	// CompressConfigUploads gzips the app and CometBFT config files the
+	// config watcher uploads, marking each part Content-Encoding: gzip. If
+	// the service answers 415, the watcher falls back to plain uploads.
+	CompressConfigUploads bool
+
+	// SkipEmptyFrames skips frames whose index line records no entries
+	// (recs 0), which the memlogger can emit at rotation boundaries. Like
+	// filtered frames they still count as consumed. Leave it off for
+	// legacy indexes that do not record recs.
+	SkipEmptyFrames bool
+}
+
+// FrameTooLargeEvent reports a frame skipped because its compressed size
+// exceeds Config.MaxFrameBytes.
+type FrameTooLargeEvent struct {
+	Frame FrameMeta
+	Limit int
+}
+
+// ReadErrorEvent reports a failure to read the WAL: an undecodable index
+// line, a missing segment or a short frame read. Fatal is set when Run
+// stops because of it; otherwise the frame is skipped and reading goes on.
+// Reaching the end of the written data is not an error.
+type ReadErrorEvent struct {
+	Path  string
+	Err   error
+	Fatal bool
+}
+
+// PartNames are the multipart field names of a frames upload.
+type PartNames struct {
+	Frames   string // compressed frame bytes; default "frames"
+	Manifest string // JSON frame manifest; default "manifest"
+}
+
+func (p PartNames) withDefaults() PartNames {
+	if p.Frames == "" {
+		p.Frames = "frames"
+	}
+	if p.Manifest == "" {
+		p.Manifest = "manifest"
+	}
+	return p
 }
 
 // DefaultConfig returns a Config with default values.
@@ -73,6 +378,14 @@ func DefaultConfig() Config {
 		MaxBatchBytes:  16 << 20, // 16MB
 		StateDir:       defaultStateDir(),
 		AuthKey:        os.Getenv("WALSHIP_AUTH_KEY"),
+
+		StateSaveInterval: time.Second,
+		BackoffInitial:    DefaultBackoffInitial,
+		BackoffMax:        DefaultBackoffMax,
+		FlushTimeout:      5 * time.Second,
+		IdleThreshold:     5 * time.Minute,
+		KeepAlive:         2 * time.Minute,
+		DNSBackoff:        30 * time.Second,
 	}
 }
 
@@ -81,17 +394,69 @@ func defaultStateDir() string {
 	return ""
 }
 
+// redactedSecret replaces secrets in Redacted configs.
+const redactedSecret = "*****"
+
+// Clone returns a copy of c that can be modified without affecting c:
+// slices, maps and pointers are copied too. Callbacks, codecs and
+// recorders are shared.
+func (c *Config) Clone() Config {
+	out := *c
+	if c.ExtraWALDirs != nil {
+		out.ExtraWALDirs = append([]string(nil), c.ExtraWALDirs...)
+	}
+	if c.Labels != nil {
+		out.Labels = make(map[string]string, len(c.Labels))
+		for k, v := range c.Labels {
+			out.Labels[k] = v
+		}
+	}
+	if c.WALNodeIndex != nil {
+		i := *c.WALNodeIndex
+		out.WALNodeIndex = &i
+	}
+	return out
+}
+
+// Redacted returns a clone of c that is safe to log: AuthKey is masked,
+// as are passwords in ServiceURL and ProxyURL.
+func (c *Config) Redacted() Config {
+	out := c.Clone()
+	if out.AuthKey != "" {
+		out.AuthKey = redactedSecret
+	}
+	out.ServiceURL = redactURL(out.ServiceURL)
+	out.ProxyURL = redactURL(out.ProxyURL)
+	return out
+}
+
+// redactURL masks the password in raw, if it has one, as xxxxx.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	return u.Redacted()
+}
+
 // Validate checks the configuration for errors and sets derived defaults.
 func (c *Config) Validate() error {
 	if c.NodeHome == "" {
 		return fmt.Errorf("node-home is required")
 	}
 
+	if c.WALNodeIndex != nil && *c.WALNodeIndex < 0 {
+		return fmt.Errorf("wal node index must not be negative")
+	}
+
 	if c.WALDir == "" {
-		if c.NodeID != "" {
+		switch {
+		case c.WALNodeIndex != nil:
+			c.WALDir = fmt.Sprintf("%s/data/log.wal/node-%d", c.NodeHome, *c.WALNodeIndex)
+		case c.NodeID != "":
 			// fallback derived layout
 			c.WALDir = fmt.Sprintf("%s/data/log.wal/node-%s", c.NodeHome, c.NodeID)
-		} else {
+		default:
 			return fmt.Errorf("wal-dir is required (or node-home)")
 		}
 	}
@@ -109,12 +474,69 @@ func (c *Config) Validate() error {
 		c.ServiceURL = c.ServiceURL[:len(c.ServiceURL)-1]
 	}
 
+	if sock, ok := unixSocket(c.ServiceURL); ok && sock == "" {
+		return fmt.Errorf("service url %q names no socket path", c.ServiceURL)
+	}
+	if err := c.checkServiceScheme(); err != nil {
+		return err
+	}
+
 	if c.PollInterval <= 0 {
 		return fmt.Errorf("poll interval must be positive")
 	}
 	if c.SendInterval <= 0 {
 		return fmt.Errorf("send interval must be positive")
 	}
+	if c.BackoffInitial > 0 && c.BackoffMax > 0 && c.BackoffMax < c.BackoffInitial {
+		return fmt.Errorf("backoff max must not be less than backoff initial")
+	}
+	if c.MaxLifetime < 0 {
+		return fmt.Errorf("max lifetime must not be negative")
+	}
+	if c.DNSBackoff < 0 {
+		return fmt.Errorf("dns backoff must not be negative")
+	}
+	if c.SelfCheckInterval < 0 {
+		return fmt.Errorf("self check interval must not be negative")
+	}
+	if c.HeartbeatInterval < 0 {
+		return fmt.Errorf("heartbeat interval must not be negative")
+	}
+	if c.MaxBatchTimeSpan < 0 {
+		return fmt.Errorf("max batch time span must not be negative")
+	}
+	if c.MaxBufferedBytes < 0 {
+		return fmt.Errorf("max buffered bytes must not be negative")
+	}
+	if c.MaxSendsPerSecond < 0 {
+		return fmt.Errorf("max sends per second must not be negative")
+	}
+	if c.MinDay != "" {
+		if _, err := time.Parse("2006-01-02", c.MinDay); err != nil {
+			return fmt.Errorf("min day must be YYYY-MM-DD: %w", err)
+		}
+	}
+	if c.ProxyURL != "" {
+		if u, err := url.Parse(c.ProxyURL); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("proxy url must be an absolute URL: %q", c.ProxyURL)
+		}
+	}
+	for k, v := range c.Labels {
+		if !validLabel(k, v) {
+			return fmt.Errorf("invalid label %q=%q: keys may use letters, digits and '-', values no control characters", k, v)
+		}
+	}
+	switch c.OnMissingState {
+	case "", MissingStateFail, MissingStateOldest, MissingStateLatest:
+	default:
+		return fmt.Errorf("on missing state must be %q, %q or %q", MissingStateFail, MissingStateOldest, MissingStateLatest)
+	}
+	if c.ManifestEncoder != nil && c.SendUncompressed {
+		return fmt.Errorf("a manifest encoder cannot be combined with send-uncompressed")
+	}
+	if c.ManifestGzipLevel != 0 && (c.ManifestGzipLevel < gzip.BestSpeed || c.ManifestGzipLevel > gzip.BestCompression) {
+		return fmt.Errorf("manifest gzip level must be between %d and %d", gzip.BestSpeed, gzip.BestCompression)
+	}
 
 	return nil
 }
@@ -138,6 +560,14 @@ func (s *configSetter) setString(flag, value string, dst *string) {
 	*dst = value
 }
 
+// setStrings sets a string slice if not empty and flag not changed.
+func (s *configSetter) setStrings(flag string, value []string, dst *[]string) {
+	if len(value) == 0 || s.changed[flag] {
+		return
+	}
+	*dst = value
+}
+
 // setInt sets an int value if positive and flag not changed.
 func (s *configSetter) setInt(flag string, value int, dst *int) {
 	if value <= 0 || s.changed[flag] {
@@ -146,6 +576,16 @@ func (s *configSetter) setInt(flag string, value int, dst *int) {
 	*dst = value
 }
 
+// setIntPtr sets an optional int from a pointer if not nil and flag not
+// changed.
+func (s *configSetter) setIntPtr(flag string, value *int, dst **int) {
+	if value == nil || s.changed[flag] {
+		return
+	}
+	v := *value
+	*dst = &v
+}
+
 // setFloat sets a float64 value if positive and flag not changed.
 func (s *configSetter) setFloat(flag string, value float64, dst *float64) {
 	if value <= 0 || s.changed[flag] {
@@ -192,6 +632,21 @@ func (s *configSetter) setIntFromString(flag, value string, dst *int) error {
 	return nil
 }
 
+// setIntPtrFromString parses a string to an optional int and sets the
+// destination if valid; zero and negative values are kept.
+// Used for environment variables that come as strings.
+func (s *configSetter) setIntPtrFromString(flag, value string, dst **int) error {
+	if value == "" || s.changed[flag] {
+		return nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("parse %s: %w", flag, err)
+	}
+	*dst = &i
+	return nil
+}
+
 // setFloatFromString parses a string to float64 and sets the destination if valid.
 // Used for environment variables that come as strings.
 func (s *configSetter) setFloatFromString(flag, value string, dst *float64) error {
@@ -209,6 +664,52 @@ func (s *configSetter) setFloatFromString(flag, value string, dst *float64) erro
 	return nil
 }
 
+// setStringsFromString splits a comma-separated list and sets the
+// destination if any element is non-empty.
+// Used for environment variables that come as strings.
+func (s *configSetter) setStringsFromString(flag, value string, dst *[]string) {
+	if value == "" || s.changed[flag] {
+		return
+	}
+	var out []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	s.setStrings(flag, out, dst)
+}
+
+// setLabels sets a label map if not empty and flag not changed.
+func (s *configSetter) setLabels(flag string, value map[string]string, dst *map[string]string) {
+	if len(value) == 0 || s.changed[flag] {
+		return
+	}
+	*dst = value
+}
+
+// setLabelsFromString parses comma-separated key=value pairs and sets the
+// destination if any are present.
+// Used for environment variables that come as strings.
+func (s *configSetter) setLabelsFromString(flag, value string, dst *map[string]string) error {
+	if value == "" || s.changed[flag] {
+		return nil
+	}
+	out := map[string]string{}
+	for _, kv := range strings.Split(value, ",") {
+		if kv = strings.TrimSpace(kv); kv == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return fmt.Errorf("parse %s: %q is not key=value", flag, kv)
+		}
+		out[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	s.setLabels(flag, out, dst)
+	return nil
+}
+
 // setBoolFromString parses a string to bool and sets the destination.
 // Accepts "true", "1" as true, anything else as false.
 // Used for environment variables that come as strings.
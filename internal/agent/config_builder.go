@@ -0,0 +1,93 @@
+package agent
+
+import (
+	"io"
+	"time"
+)
+
+// ConfigBuilder assembles a Config for embedders. It starts from
+// DefaultConfig and validates on Build, so unset fields keep their
+// defaults instead of zero values that fail validation.
+type ConfigBuilder struct {
+	cfg Config
+}
+
+// NewConfigBuilder returns a builder seeded with DefaultConfig.
+func NewConfigBuilder() *ConfigBuilder {
+	return &ConfigBuilder{cfg: DefaultConfig()}
+}
+
+// WithNodeHome sets the node home directory.
+func (b *ConfigBuilder) WithNodeHome(dir string) *ConfigBuilder {
+	b.cfg.NodeHome = dir
+	return b
+}
+
+// WithWALDir sets the WAL directory. Left unset, it is derived from the
+// node home and node ID.
+func (b *ConfigBuilder) WithWALDir(dir string) *ConfigBuilder {
+	b.cfg.WALDir = dir
+	return b
+}
+
+// WithStateDir sets where status.json is kept. Defaults to the WAL directory.
+func (b *ConfigBuilder) WithStateDir(dir string) *ConfigBuilder {
+	b.cfg.StateDir = dir
+	return b
+}
+
+// WithNode sets the chain and node IDs, e.g. when LoadNodeInfo is not used.
+func (b *ConfigBuilder) WithNode(chainID, nodeID string) *ConfigBuilder {
+	b.cfg.ChainID = chainID
+	b.cfg.NodeID = nodeID
+	return b
+}
+
+// WithNodeInfoResolver sets how LoadNodeInfo derives the chain and node
+// IDs from the node home.
+func (b *ConfigBuilder) WithNodeInfoResolver(r NodeInfoResolver) *ConfigBuilder {
+	b.cfg.NodeInfoResolver = r
+	return b
+}
+
+// WithHTTPTrace dumps every request and response to w, with the auth key
+// masked. It is verbose; use it only to diagnose rejected uploads.
+func (b *ConfigBuilder) WithHTTPTrace(w io.Writer) *ConfigBuilder {
+	b.cfg.HTTPTrace = w
+	return b
+}
+
+// WithAuth sets the API key sent with every upload.
+func (b *ConfigBuilder) WithAuth(key string) *ConfigBuilder {
+	b.cfg.AuthKey = key
+	return b
+}
+
+// WithServiceURL sets the ingest service base URL.
+func (b *ConfigBuilder) WithServiceURL(url string) *ConfigBuilder {
+	b.cfg.ServiceURL = url
+	return b
+}
+
+// WithIntervals sets the idle poll interval and the send interval.
+func (b *ConfigBuilder) WithIntervals(poll, send time.Duration) *ConfigBuilder {
+	b.cfg.PollInterval = poll
+	b.cfg.SendInterval = send
+	return b
+}
+
+// With applies fn to the config being built, for settings without a
+// dedicated setter.
+func (b *ConfigBuilder) With(fn func(*Config)) *ConfigBuilder {
+	fn(&b.cfg)
+	return b
+}
+
+// Build validates the config, filling in derived defaults, and returns it.
+func (b *ConfigBuilder) Build() (Config, error) {
+	cfg := b.cfg
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
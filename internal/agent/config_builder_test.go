@@ -0,0 +1,61 @@
+package agent
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestConfigBuilder_MatchesManual(t *testing.T) {
+	got, err := NewConfigBuilder().
+		WithNodeHome("/node").
+		WithWALDir("/node/wal").
+		WithAuth("key").
+		WithNode("chain-1", "abc").
+		With(func(c *Config) { c.MaxFrameBytes = 1 << 20 }).
+		Build()
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+
+	want := DefaultConfig()
+	want.NodeHome = "/node"
+	want.WALDir = "/node/wal"
+	want.AuthKey = "key"
+	want.ChainID, want.NodeID = "chain-1", "abc"
+	want.MaxFrameBytes = 1 << 20
+	if err := want.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Build() = %+v\nwant %+v", got, want)
+	}
+	if got.StateDir != "/node/wal" || got.PollInterval <= 0 {
+		t.Errorf("derived defaults not applied: StateDir=%q PollInterval=%v", got.StateDir, got.PollInterval)
+	}
+}
+
+func TestConfigBuilder_DerivesWALDir(t *testing.T) {
+	got, err := NewConfigBuilder().WithNodeHome("/node").Build()
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if want := "/node/data/log.wal/node-default"; got.WALDir != want {
+		t.Errorf("WALDir = %q, want %q", got.WALDir, want)
+	}
+}
+
+func TestConfigBuilder_Invalid(t *testing.T) {
+	tests := map[string]*ConfigBuilder{
+		"no node home": NewConfigBuilder().WithAuth("key"),
+		"zero poll":    NewConfigBuilder().WithNodeHome("/node").WithIntervals(0, time.Second),
+	}
+	for name, b := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := b.Build(); err == nil {
+				t.Error("Build() succeeded, want a validation error")
+			}
+		})
+	}
+}
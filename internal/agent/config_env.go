@@ -15,6 +15,7 @@ func ApplyEnvConfig(cfg *Config, changed map[string]bool) error {
 	s.setString("auth-key", os.Getenv("WALSHIP_AUTH_KEY"), &cfg.AuthKey)
 	s.setString("iface", os.Getenv("WALSHIP_IFACE"), &cfg.Iface)
 	s.setString("state-dir", os.Getenv("WALSHIP_STATE_DIR"), &cfg.StateDir)
+	s.setStringsFromString("extra-wal-dir", os.Getenv("WALSHIP_EXTRA_WAL_DIRS"), &cfg.ExtraWALDirs)
 
 	if err := s.setDuration("poll", os.Getenv("WALSHIP_POLL_INTERVAL"), &cfg.PollInterval); err != nil {
 		return err
@@ -28,6 +29,39 @@ func ApplyEnvConfig(cfg *Config, changed map[string]bool) error {
 	if err := s.setDuration("timeout", os.Getenv("WALSHIP_HTTP_TIMEOUT"), &cfg.HTTPTimeout); err != nil {
 		return err
 	}
+	if err := s.setDuration("state-save-interval", os.Getenv("WALSHIP_STATE_SAVE_INTERVAL"), &cfg.StateSaveInterval); err != nil {
+		return err
+	}
+	if err := s.setDuration("backoff-initial", os.Getenv("WALSHIP_BACKOFF_INITIAL"), &cfg.BackoffInitial); err != nil {
+		return err
+	}
+	if err := s.setDuration("backoff-max", os.Getenv("WALSHIP_BACKOFF_MAX"), &cfg.BackoffMax); err != nil {
+		return err
+	}
+	if err := s.setDuration("flush-timeout", os.Getenv("WALSHIP_FLUSH_TIMEOUT"), &cfg.FlushTimeout); err != nil {
+		return err
+	}
+	if err := s.setDuration("idle-threshold", os.Getenv("WALSHIP_IDLE_THRESHOLD"), &cfg.IdleThreshold); err != nil {
+		return err
+	}
+	if err := s.setDuration("keep-alive", os.Getenv("WALSHIP_KEEP_ALIVE"), &cfg.KeepAlive); err != nil {
+		return err
+	}
+	if err := s.setDuration("max-batch-time-span", os.Getenv("WALSHIP_MAX_BATCH_TIME_SPAN"), &cfg.MaxBatchTimeSpan); err != nil {
+		return err
+	}
+	if err := s.setDuration("max-lifetime", os.Getenv("WALSHIP_MAX_LIFETIME"), &cfg.MaxLifetime); err != nil {
+		return err
+	}
+	if err := s.setDuration("dns-backoff", os.Getenv("WALSHIP_DNS_BACKOFF"), &cfg.DNSBackoff); err != nil {
+		return err
+	}
+	if err := s.setDuration("self-check-interval", os.Getenv("WALSHIP_SELF_CHECK_INTERVAL"), &cfg.SelfCheckInterval); err != nil {
+		return err
+	}
+	if err := s.setDuration("heartbeat-interval", os.Getenv("WALSHIP_HEARTBEAT_INTERVAL"), &cfg.HeartbeatInterval); err != nil {
+		return err
+	}
 
 	if err := s.setFloatFromString("cpu-threshold", os.Getenv("WALSHIP_CPU_THRESHOLD"), &cfg.CPUThreshold); err != nil {
 		return err
@@ -35,6 +69,13 @@ func ApplyEnvConfig(cfg *Config, changed map[string]bool) error {
 	if err := s.setFloatFromString("net-threshold", os.Getenv("WALSHIP_NET_THRESHOLD"), &cfg.NetThreshold); err != nil {
 		return err
 	}
+	if err := s.setFloatFromString("max-sends-per-second", os.Getenv("WALSHIP_MAX_SENDS_PER_SECOND"), &cfg.MaxSendsPerSecond); err != nil {
+		return err
+	}
+
+	if err := s.setLabelsFromString("label", os.Getenv("WALSHIP_LABELS"), &cfg.Labels); err != nil {
+		return err
+	}
 
 	if err := s.setIntFromString("iface-speed", os.Getenv("WALSHIP_IFACE_SPEED_MBPS"), &cfg.IfaceSpeedMbps); err != nil {
 		return err
@@ -42,10 +83,42 @@ func ApplyEnvConfig(cfg *Config, changed map[string]bool) error {
 	if err := s.setIntFromString("max-batch-bytes", os.Getenv("WALSHIP_MAX_BATCH_BYTES"), &cfg.MaxBatchBytes); err != nil {
 		return err
 	}
+	if err := s.setIntFromString("max-frame-bytes", os.Getenv("WALSHIP_MAX_FRAME_BYTES"), &cfg.MaxFrameBytes); err != nil {
+		return err
+	}
+	if err := s.setIntFromString("max-buffered-bytes", os.Getenv("WALSHIP_MAX_BUFFERED_BYTES"), &cfg.MaxBufferedBytes); err != nil {
+		return err
+	}
+	if err := s.setIntFromString("manifest-gzip-level", os.Getenv("WALSHIP_MANIFEST_GZIP_LEVEL"), &cfg.ManifestGzipLevel); err != nil {
+		return err
+	}
+	if err := s.setIntPtrFromString("wal-node-index", os.Getenv("WALSHIP_WAL_NODE_INDEX"), &cfg.WALNodeIndex); err != nil {
+		return err
+	}
 
 	s.setBoolFromString("verify", os.Getenv("WALSHIP_VERIFY"), &cfg.Verify)
 	s.setBoolFromString("meta", os.Getenv("WALSHIP_META"), &cfg.Meta)
 	s.setBoolFromString("once", os.Getenv("WALSHIP_ONCE"), &cfg.Once)
+	s.setBoolFromString("lenient-index", os.Getenv("WALSHIP_LENIENT_INDEX"), &cfg.LenientIndex)
+	s.setBoolFromString("strict-index-fields", os.Getenv("WALSHIP_STRICT_INDEX_FIELDS"), &cfg.StrictIndexFields)
+	s.setBoolFromString("watch-wal", os.Getenv("WALSHIP_WATCH_WAL"), &cfg.WatchWAL)
+	s.setBoolFromString("strict-frame-bounds", os.Getenv("WALSHIP_STRICT_FRAME_BOUNDS"), &cfg.StrictFrameBounds)
+	s.setBoolFromString("watch-chain-files", os.Getenv("WALSHIP_WATCH_CHAIN_FILES"), &cfg.WatchChainFiles)
+	s.setString("chain-files-endpoint", os.Getenv("WALSHIP_CHAIN_FILES_ENDPOINT"), &cfg.ChainFilesEndpoint)
+	s.setString("app-config-path", os.Getenv("WALSHIP_APP_CONFIG_PATH"), &cfg.AppConfigPath)
+	s.setString("comet-config-path", os.Getenv("WALSHIP_COMET_CONFIG_PATH"), &cfg.CometConfigPath)
+	s.setBoolFromString("send-uncompressed", os.Getenv("WALSHIP_SEND_UNCOMPRESSED"), &cfg.SendUncompressed)
+	s.setBoolFromString("allow-insecure", os.Getenv("WALSHIP_ALLOW_INSECURE"), &cfg.AllowInsecure)
+	s.setBoolFromString("sanitize-timestamps", os.Getenv("WALSHIP_SANITIZE_TIMESTAMPS"), &cfg.SanitizeTimestamps)
+	s.setBoolFromString("compress-config-uploads", os.Getenv("WALSHIP_COMPRESS_CONFIG_UPLOADS"), &cfg.CompressConfigUploads)
+	s.setBoolFromString("skip-empty-frames", os.Getenv("WALSHIP_SKIP_EMPTY_FRAMES"), &cfg.SkipEmptyFrames)
+	s.setBoolFromString("probe-before-retry", os.Getenv("WALSHIP_PROBE_BEFORE_RETRY"), &cfg.ProbeBeforeRetry)
+	s.setString("status-endpoint", os.Getenv("WALSHIP_STATUS_ENDPOINT"), &cfg.StatusEndpoint)
+	s.setString("heartbeat-endpoint", os.Getenv("WALSHIP_HEARTBEAT_ENDPOINT"), &cfg.HeartbeatEndpoint)
+	s.setString("min-day", os.Getenv("WALSHIP_MIN_DAY"), &cfg.MinDay)
+	s.setString("user-agent", os.Getenv("WALSHIP_USER_AGENT"), &cfg.UserAgent)
+	s.setString("proxy-url", os.Getenv("WALSHIP_PROXY_URL"), &cfg.ProxyURL)
+	s.setString("on-missing-state", os.Getenv("WALSHIP_ON_MISSING_STATE"), &cfg.OnMissingState)
 
 	return nil
 }
@@ -2,6 +2,7 @@ package agent
 
 import (
 	"os"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -52,6 +53,24 @@ func TestApplyEnvConfig(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "wal node index zero is kept",
+			envVars: map[string]string{
+				"WALSHIP_WAL_NODE_INDEX": "0",
+			},
+			changed:  map[string]bool{},
+			initial:  Config{},
+			expected: Config{WALNodeIndex: new(int)},
+		},
+		{
+			name: "returns error for invalid wal node index",
+			envVars: map[string]string{
+				"WALSHIP_WAL_NODE_INDEX": "first",
+			},
+			changed: map[string]bool{},
+			initial: Config{},
+			wantErr: true,
+		},
 		{
 			name: "returns error for invalid duration",
 			envVars: map[string]string{
@@ -82,6 +101,28 @@ func TestApplyEnvConfig(t *testing.T) {
 			expected: Config{},
 			wantErr:  true,
 		},
+		{
+			name: "parses labels",
+			envVars: map[string]string{
+				"WALSHIP_LABELS": "dc=fra1, moniker=val-1",
+			},
+			changed: map[string]bool{},
+			initial: Config{},
+			expected: Config{
+				Labels: map[string]string{"dc": "fra1", "moniker": "val-1"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "returns error for malformed labels",
+			envVars: map[string]string{
+				"WALSHIP_LABELS": "dc=fra1,moniker",
+			},
+			changed:  map[string]bool{},
+			initial:  Config{},
+			expected: Config{},
+			wantErr:  true,
+		},
 		{
 			name: "handles bool '1' as true",
 			envVars: map[string]string{
@@ -222,6 +263,14 @@ func TestApplyEnvConfig(t *testing.T) {
 				if cfg.Once != tt.expected.Once {
 					t.Errorf("Once = %v, want %v", cfg.Once, tt.expected.Once)
 				}
+
+				// Check map fields
+				if !reflect.DeepEqual(cfg.Labels, tt.expected.Labels) {
+					t.Errorf("Labels = %v, want %v", cfg.Labels, tt.expected.Labels)
+				}
+				if !reflect.DeepEqual(cfg.WALNodeIndex, tt.expected.WALNodeIndex) {
+					t.Errorf("WALNodeIndex = %v, want %v", cfg.WALNodeIndex, tt.expected.WALNodeIndex)
+				}
 			}
 		})
 	}
@@ -0,0 +1,84 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// expandEnv replaces each ${VAR} in s with the value of the environment
+// variable VAR. Undefined variables are left as written, or are an error
+// if strict is set. Only the braced form is expanded, so a bare $ in a
+// value such as an auth key is kept.
+func expandEnv(s string, strict bool) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			break
+		}
+		j := strings.IndexByte(s[i+2:], '}')
+		if j < 0 {
+			break
+		}
+		name := s[i+2 : i+2+j]
+		b.WriteString(s[:i])
+		if v, ok := os.LookupEnv(name); ok && name != "" {
+			b.WriteString(v)
+		} else if strict {
+			return "", fmt.Errorf("undefined environment variable ${%s}", name)
+		} else {
+			b.WriteString(s[i : i+3+j])
+		}
+		s = s[i+3+j:]
+	}
+	b.WriteString(s)
+	return b.String(), nil
+}
+
+// expandFileConfig expands ${VAR} references in every string value of fc:
+// plain fields, list elements and label values.
+func expandFileConfig(fc *fileConfig) error {
+	v := reflect.ValueOf(fc).Elem()
+	t := v.Type()
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		key := strings.Split(t.Field(i).Tag.Get("toml"), ",")[0]
+		switch f.Kind() {
+		case reflect.String:
+			s, err := expandEnv(f.String(), fc.StrictEnv)
+			if err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			f.SetString(s)
+		case reflect.Slice:
+			if f.Type().Elem().Kind() != reflect.String {
+				continue
+			}
+			for j := 0; j < f.Len(); j++ {
+				s, err := expandEnv(f.Index(j).String(), fc.StrictEnv)
+				if err != nil {
+					return fmt.Errorf("%s: %w", key, err)
+				}
+				f.Index(j).SetString(s)
+			}
+		case reflect.Map:
+			if f.Type().Elem().Kind() != reflect.String {
+				continue
+			}
+			iter := f.MapRange()
+			for iter.Next() {
+				s, err := expandEnv(iter.Value().String(), fc.StrictEnv)
+				if err != nil {
+					return fmt.Errorf("%s.%s: %w", key, iter.Key().String(), err)
+				}
+				f.SetMapIndex(iter.Key(), reflect.ValueOf(s))
+			}
+		}
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package agent
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 
@@ -23,13 +24,74 @@ type fileConfig struct {
 	Iface          string  `toml:"iface"`
 	IfaceSpeedMbps int     `toml:"iface_speed_mbps"`
 	MaxBatchBytes  int     `toml:"max_batch_bytes"`
+	MaxFrameBytes  int     `toml:"max_frame_bytes"`
+	ManifestGzip   int     `toml:"manifest_gzip_level"`
 	StateDir       string  `toml:"state_dir"`
 	Verify         *bool   `toml:"verify"`
 	Meta           *bool   `toml:"meta"`
 	Once           *bool   `toml:"once"`
+
+	StateSaveInterval string `toml:"state_save_interval"`
+	BackoffInitial    string `toml:"backoff_initial"`
+	BackoffMax        string `toml:"backoff_max"`
+	FlushTimeout      string `toml:"flush_timeout"`
+	IdleThreshold     string `toml:"idle_threshold"`
+	KeepAlive         string `toml:"keep_alive"`
+
+	ExtraWALDirs      []string `toml:"extra_wal_dirs"`
+	LenientIndex      *bool    `toml:"lenient_index"`
+	StrictIndexFields *bool    `toml:"strict_index_fields"`
+	WatchWAL          *bool    `toml:"watch_wal"`
+
+	StrictFrameBounds  *bool  `toml:"strict_frame_bounds"`
+	WatchChainFiles    *bool  `toml:"watch_chain_files"`
+	ChainFilesEndpoint string `toml:"chain_files_endpoint"`
+
+	AppConfigPath   string `toml:"app_config_path"`
+	CometConfigPath string `toml:"comet_config_path"`
+
+	MinDay    string `toml:"min_day"`
+	UserAgent string `toml:"user_agent"`
+	ProxyURL  string `toml:"proxy_url"`
+
+	MaxSendsPerSecond float64 `toml:"max_sends_per_second"`
+
+	Labels map[string]string `toml:"labels"`
+
+	OnMissingState string `toml:"on_missing_state"`
+
+	MaxBufferedBytes int    `toml:"max_buffered_bytes"`
+	MaxBatchTimeSpan string `toml:"max_batch_time_span"`
+
+	MaxLifetime string `toml:"max_lifetime"`
+	DNSBackoff  string `toml:"dns_backoff"`
+
+	SelfCheckInterval string `toml:"self_check_interval"`
+
+	HeartbeatInterval string `toml:"heartbeat_interval"`
+	HeartbeatEndpoint string `toml:"heartbeat_endpoint"`
+
+	SendUncompressed *bool `toml:"send_uncompressed"`
+	AllowInsecure    *bool `toml:"allow_insecure"`
+
+	WALNodeIndex *int `toml:"wal_node_index"`
+
+	SanitizeTimestamps *bool `toml:"sanitize_timestamps"`
+
+	CompressConfigUploads *bool `toml:"compress_config_uploads"`
+
+	SkipEmptyFrames *bool `toml:"skip_empty_frames"`
+
+	ProbeBeforeRetry *bool  `toml:"probe_before_retry"`
+	StatusEndpoint   string `toml:"status_endpoint"`
+
+	// StrictEnv makes a ${VAR} reference to an undefined variable an
+	// error instead of leaving it as written.
+	StrictEnv bool `toml:"strict_env"`
 }
 
-// loadFileConfig reads and parses a TOML config file.
+// loadFileConfig reads and parses a TOML config file, expanding ${VAR}
+// references to environment variables in its string values.
 func loadFileConfig(path string) (fileConfig, error) {
 	var fc fileConfig
 	b, err := os.ReadFile(path)
@@ -39,6 +101,9 @@ func loadFileConfig(path string) (fileConfig, error) {
 	if err := toml.Unmarshal(b, &fc); err != nil {
 		return fc, err
 	}
+	if err := expandFileConfig(&fc); err != nil {
+		return fc, fmt.Errorf("%s: %w", path, err)
+	}
 	return fc, nil
 }
 
@@ -63,6 +128,7 @@ func applyFileConfig(cfg *Config, fc fileConfig, changed map[string]bool) error
 	s.setString("auth-key", fc.AuthKey, &cfg.AuthKey)
 	s.setString("iface", fc.Iface, &cfg.Iface)
 	s.setString("state-dir", fc.StateDir, &cfg.StateDir)
+	s.setStrings("extra-wal-dir", fc.ExtraWALDirs, &cfg.ExtraWALDirs)
 
 	if err := s.setDuration("poll", fc.PollInterval, &cfg.PollInterval); err != nil {
 		return err
@@ -76,16 +142,75 @@ func applyFileConfig(cfg *Config, fc fileConfig, changed map[string]bool) error
 	if err := s.setDuration("timeout", fc.HTTPTimeout, &cfg.HTTPTimeout); err != nil {
 		return err
 	}
+	if err := s.setDuration("state-save-interval", fc.StateSaveInterval, &cfg.StateSaveInterval); err != nil {
+		return err
+	}
+	if err := s.setDuration("backoff-initial", fc.BackoffInitial, &cfg.BackoffInitial); err != nil {
+		return err
+	}
+	if err := s.setDuration("backoff-max", fc.BackoffMax, &cfg.BackoffMax); err != nil {
+		return err
+	}
+	if err := s.setDuration("flush-timeout", fc.FlushTimeout, &cfg.FlushTimeout); err != nil {
+		return err
+	}
+	if err := s.setDuration("idle-threshold", fc.IdleThreshold, &cfg.IdleThreshold); err != nil {
+		return err
+	}
+	if err := s.setDuration("keep-alive", fc.KeepAlive, &cfg.KeepAlive); err != nil {
+		return err
+	}
+	if err := s.setDuration("max-batch-time-span", fc.MaxBatchTimeSpan, &cfg.MaxBatchTimeSpan); err != nil {
+		return err
+	}
+	if err := s.setDuration("max-lifetime", fc.MaxLifetime, &cfg.MaxLifetime); err != nil {
+		return err
+	}
+	if err := s.setDuration("dns-backoff", fc.DNSBackoff, &cfg.DNSBackoff); err != nil {
+		return err
+	}
+	if err := s.setDuration("self-check-interval", fc.SelfCheckInterval, &cfg.SelfCheckInterval); err != nil {
+		return err
+	}
+	if err := s.setDuration("heartbeat-interval", fc.HeartbeatInterval, &cfg.HeartbeatInterval); err != nil {
+		return err
+	}
 
 	s.setFloat("cpu-threshold", fc.CPUThreshold, &cfg.CPUThreshold)
 	s.setFloat("net-threshold", fc.NetThreshold, &cfg.NetThreshold)
+	s.setFloat("max-sends-per-second", fc.MaxSendsPerSecond, &cfg.MaxSendsPerSecond)
+	s.setLabels("label", fc.Labels, &cfg.Labels)
+	s.setString("on-missing-state", fc.OnMissingState, &cfg.OnMissingState)
+	s.setInt("max-buffered-bytes", fc.MaxBufferedBytes, &cfg.MaxBufferedBytes)
 
 	s.setInt("iface-speed", fc.IfaceSpeedMbps, &cfg.IfaceSpeedMbps)
 	s.setInt("max-batch-bytes", fc.MaxBatchBytes, &cfg.MaxBatchBytes)
+	s.setInt("max-frame-bytes", fc.MaxFrameBytes, &cfg.MaxFrameBytes)
+	s.setInt("manifest-gzip-level", fc.ManifestGzip, &cfg.ManifestGzipLevel)
 
 	s.setBool("verify", fc.Verify, &cfg.Verify)
 	s.setBool("meta", fc.Meta, &cfg.Meta)
 	s.setBool("once", fc.Once, &cfg.Once)
+	s.setBool("lenient-index", fc.LenientIndex, &cfg.LenientIndex)
+	s.setBool("strict-index-fields", fc.StrictIndexFields, &cfg.StrictIndexFields)
+	s.setBool("watch-wal", fc.WatchWAL, &cfg.WatchWAL)
+	s.setBool("strict-frame-bounds", fc.StrictFrameBounds, &cfg.StrictFrameBounds)
+	s.setBool("watch-chain-files", fc.WatchChainFiles, &cfg.WatchChainFiles)
+	s.setString("chain-files-endpoint", fc.ChainFilesEndpoint, &cfg.ChainFilesEndpoint)
+	s.setString("app-config-path", fc.AppConfigPath, &cfg.AppConfigPath)
+	s.setString("comet-config-path", fc.CometConfigPath, &cfg.CometConfigPath)
+	s.setBool("send-uncompressed", fc.SendUncompressed, &cfg.SendUncompressed)
+	s.setBool("allow-insecure", fc.AllowInsecure, &cfg.AllowInsecure)
+	s.setIntPtr("wal-node-index", fc.WALNodeIndex, &cfg.WALNodeIndex)
+	s.setBool("sanitize-timestamps", fc.SanitizeTimestamps, &cfg.SanitizeTimestamps)
+	s.setBool("compress-config-uploads", fc.CompressConfigUploads, &cfg.CompressConfigUploads)
+	s.setBool("skip-empty-frames", fc.SkipEmptyFrames, &cfg.SkipEmptyFrames)
+	s.setBool("probe-before-retry", fc.ProbeBeforeRetry, &cfg.ProbeBeforeRetry)
+	s.setString("status-endpoint", fc.StatusEndpoint, &cfg.StatusEndpoint)
+	s.setString("heartbeat-endpoint", fc.HeartbeatEndpoint, &cfg.HeartbeatEndpoint)
+	s.setString("min-day", fc.MinDay, &cfg.MinDay)
+	s.setString("user-agent", fc.UserAgent, &cfg.UserAgent)
+	s.setString("proxy-url", fc.ProxyURL, &cfg.ProxyURL)
 
 	return nil
 }
@@ -3,6 +3,7 @@ package agent
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -208,6 +209,57 @@ verify = true
 	}
 }
 
+func TestLoadFileConfig_EnvInterpolation(t *testing.T) {
+	t.Setenv("WALSHIP_TEST_HOME", "/home/val")
+	t.Setenv("WALSHIP_TEST_KEY", "s3cret")
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.toml")
+	tomlContent := `
+node_home = "${WALSHIP_TEST_HOME}/.mychain"
+auth_key = "${WALSHIP_TEST_KEY}"
+service_url = "${WALSHIP_TEST_UNDEFINED}/ingest"
+node_id = "pa$$word-$HOME"
+extra_wal_dirs = ["${WALSHIP_TEST_HOME}/wal2"]
+
+[labels]
+home = "${WALSHIP_TEST_HOME}"
+`
+	if err := os.WriteFile(configPath, []byte(tomlContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fc, err := loadFileConfig(configPath)
+	if err != nil {
+		t.Fatalf("loadFileConfig() error = %v", err)
+	}
+	if fc.NodeHome != "/home/val/.mychain" {
+		t.Errorf("NodeHome = %q", fc.NodeHome)
+	}
+	if fc.AuthKey != "s3cret" {
+		t.Errorf("AuthKey = %q", fc.AuthKey)
+	}
+	if fc.ServiceURL != "${WALSHIP_TEST_UNDEFINED}/ingest" {
+		t.Errorf("ServiceURL = %q, want the undefined reference kept", fc.ServiceURL)
+	}
+	if fc.NodeID != "pa$$word-$HOME" {
+		t.Errorf("NodeID = %q, want bare $ left alone", fc.NodeID)
+	}
+	if len(fc.ExtraWALDirs) != 1 || fc.ExtraWALDirs[0] != "/home/val/wal2" {
+		t.Errorf("ExtraWALDirs = %q", fc.ExtraWALDirs)
+	}
+	if fc.Labels["home"] != "/home/val" {
+		t.Errorf("Labels = %v", fc.Labels)
+	}
+
+	// Strict mode rejects the undefined variable
+	if err := os.WriteFile(configPath, []byte("strict_env = true\n"+tomlContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadFileConfig(configPath); err == nil || !strings.Contains(err.Error(), "WALSHIP_TEST_UNDEFINED") {
+		t.Errorf("strict loadFileConfig() error = %v, want undefined variable error", err)
+	}
+}
+
 func TestLoadFileConfig_InvalidFile(t *testing.T) {
 	_, err := loadFileConfig("/nonexistent/path/config.toml")
 	if err == nil {
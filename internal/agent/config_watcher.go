@@ -2,11 +2,14 @@ package agent
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
+	"net/textproto"
 	"os"
 	"path/filepath"
 	"strings"
@@ -22,31 +25,43 @@ const (
 	ErrCodeReadError        = "READ_ERROR"
 )
 
+// Default config file locations, relative to the node home.
+const (
+	defaultAppConfigPath   = "config/app.toml"
+	defaultCometConfigPath = "config/config.toml"
+)
+
 // ConfigWatcher monitors app.toml and config.toml changes via fsnotify.
+// Their locations come from Config.AppConfigPath and CometConfigPath.
 type ConfigWatcher struct {
 	cfg        *Config
 	httpClient *http.Client
 
 	mu       sync.Mutex
-	debounce *time.Timer
+	debounce timer
+	clock    clock
+	// plainOnly is set once the service refused a compressed upload.
+	plainOnly bool
 }
 
 func NewConfigWatcher(cfg *Config) *ConfigWatcher {
 	return &ConfigWatcher{
-		cfg: cfg,
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+		cfg:        cfg,
+		httpClient: newHTTPClient(30*time.Second, cfg),
+		clock:      realClock{},
 	}
 }
 
-// Run watches $NODE_HOME/config and sends updates to {ServiceURL}/config.
+// Run watches the directories of the app and CometBFT config files and
+// sends updates to {ServiceURL}/config.
 func (w *ConfigWatcher) Run(ctx context.Context) {
 	if w.cfg.NodeHome == "" || w.cfg.ServiceURL == "" {
 		return
 	}
-
-	configDir := w.configDir()
+	if err := w.cfg.checkServiceScheme(); err != nil {
+		logger.Error().Err(err).Msg("config watcher: not starting")
+		return
+	}
 
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
@@ -55,11 +70,13 @@ func (w *ConfigWatcher) Run(ctx context.Context) {
 	}
 	defer watcher.Close()
 
-	if err := watcher.Add(configDir); err != nil {
-		logger.Error().Err(err).Str("dir", configDir).Msg("config watcher: failed to watch")
-		w.sendConfigWithRetry(ctx)
-		return
+	paths := map[string]bool{w.appConfigPath(): true, w.cometConfigPath(): true}
+	dirs, failed := newDirWatch(watcher, w.configDirs())
+	for dir, err := range failed {
+		logger.Error().Err(err).Str("dir", dir).Msg("config watcher: failed to watch; retrying")
 	}
+	retry := w.clock.NewTicker(watchRetryInterval)
+	defer retry.Stop()
 
 	w.sendConfigWithRetry(ctx)
 
@@ -68,12 +85,22 @@ func (w *ConfigWatcher) Run(ctx context.Context) {
 		case <-ctx.Done():
 			return
 
+		case <-retry.C():
+			// Resend on reattach; changes while unwatched were missed
+			if back := dirs.retry(); len(back) > 0 {
+				logger.Info().Strs("dirs", back).Msg("config watcher: watching again")
+				w.sendConfigWithRetry(ctx)
+			}
+
 		case event, ok := <-watcher.Events:
 			if !ok {
 				return
 			}
-			filename := filepath.Base(event.Name)
-			if filename != "app.toml" && filename != "config.toml" {
+			if dirs.lost(event) {
+				logger.Warn().Str("dir", event.Name).Msg("config watcher: watched directory removed; waiting for it")
+				continue
+			}
+			if !paths[filepath.Clean(event.Name)] {
 				continue
 			}
 			if event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
@@ -98,35 +125,76 @@ func (w *ConfigWatcher) debounceSend(ctx context.Context, delay time.Duration) {
 		w.debounce.Stop()
 	}
 
-	w.debounce = time.AfterFunc(delay, func() {
+	w.debounce = w.clock.AfterFunc(delay, func() {
 		w.sendConfigWithRetry(ctx)
 	})
 }
 
-func (w *ConfigWatcher) configDir() string       { return filepath.Join(w.cfg.NodeHome, "config") }
-func (w *ConfigWatcher) appConfigPath() string   { return filepath.Join(w.configDir(), "app.toml") }
-func (w *ConfigWatcher) cometConfigPath() string { return filepath.Join(w.configDir(), "config.toml") }
-func (w *ConfigWatcher) configURL() string       { return w.cfg.ServiceURL + configEndpoint }
+func (w *ConfigWatcher) appConfigPath() string {
+	return w.homePath(w.cfg.AppConfigPath, defaultAppConfigPath)
+}
+
+func (w *ConfigWatcher) cometConfigPath() string {
+	return w.homePath(w.cfg.CometConfigPath, defaultCometConfigPath)
+}
+
+func (w *ConfigWatcher) configURL() string { return w.cfg.serviceBase() + configEndpoint }
+
+// homePath resolves rel, or def when rel is empty, against the node home.
+func (w *ConfigWatcher) homePath(rel, def string) string {
+	if rel == "" {
+		rel = def
+	}
+	return filepath.Join(w.cfg.NodeHome, rel)
+}
+
+// configDirs returns the directories holding the watched files, once each.
+func (w *ConfigWatcher) configDirs() []string {
+	app, comet := filepath.Dir(w.appConfigPath()), filepath.Dir(w.cometConfigPath())
+	if app == comet {
+		return []string{app}
+	}
+	return []string{app, comet}
+}
+
+// configSnapshot is the state of the config files at one moment. Retries
+// resend the same snapshot so the service keeps every version.
+type configSnapshot struct {
+	capturedAt       time.Time
+	app, comet       string
+	appErr, cometErr error
+}
+
+func (w *ConfigWatcher) takeSnapshot() configSnapshot {
+	snap := configSnapshot{capturedAt: w.clock.Now().UTC()}
+	snap.app, snap.appErr = w.readFile(w.appConfigPath())
+	snap.comet, snap.cometErr = w.readFile(w.cometConfigPath())
+	return snap
+}
 
 // buildMultipartPayload builds multipart form-data with config files and captured_at timestamp.
 func (w *ConfigWatcher) buildMultipartPayload() (*bytes.Buffer, string) {
+	return w.encodeSnapshot(w.takeSnapshot(), w.compressUploads())
+}
+
+// encodeSnapshot builds the multipart form-data for snap. With compress
+// each file part is gzipped and marked Content-Encoding: gzip.
+func (w *ConfigWatcher) encodeSnapshot(snap configSnapshot, compress bool) (*bytes.Buffer, string) {
 	var buf bytes.Buffer
 	writer := multipart.NewWriter(&buf)
 
-	writer.WriteField("captured_at", time.Now().UTC().Format(time.RFC3339Nano))
+	writer.WriteField("captured_at", snap.capturedAt.Format(time.RFC3339Nano))
 
-	appContent, appErr := w.readFile(w.appConfigPath())
-	if appErr != nil {
-		writer.WriteField("app_error", w.errorToCode(appErr))
-	} else if part, err := writer.CreateFormFile("app_config", "app.toml"); err == nil {
-		part.Write([]byte(appContent))
+	if snap.appErr != nil {
+		writer.WriteField("app_error", w.errorToCode(snap.appErr))
+	} else {
+		writeConfigFile(writer, "app_config", filepath.Base(w.appConfigPath()), snap.app, compress)
 	}
 
-	cometContent, cometErr := w.readFile(w.cometConfigPath())
-	if cometErr != nil {
-		writer.WriteField("comet_error", w.errorToCode(cometErr))
-	} else if part, err := writer.CreateFormFile("comet_config", "config.toml"); err == nil {
-		part.Write([]byte(cometContent))
+	if snap.cometErr != nil {
+		writer.WriteField("comet_error", w.errorToCode(snap.cometErr))
+	} else {
+		writeConfigFile(writer, "comet_config", filepath.Base(w.cometConfigPath()), snap.comet, compress)
 	}
 
 	contentType := writer.FormDataContentType()
@@ -135,10 +203,57 @@ func (w *ConfigWatcher) buildMultipartPayload() (*bytes.Buffer, string) {
 	return &buf, contentType
 }
 
-func (w *ConfigWatcher) sendConfig(ctx context.Context) {
-	buf, contentType := w.buildMultipartPayload()
+// writeConfigFile adds a file part holding content, gzipped if compress
+// is set.
+func writeConfigFile(writer *multipart.Writer, field, name, content string, compress bool) {
+	if !compress {
+		if part, err := writer.CreateFormFile(field, name); err == nil {
+			part.Write([]byte(content))
+		}
+		return
+	}
+	data, err := gzipBytes([]byte(content), gzip.DefaultCompression)
+	if err != nil {
+		return
+	}
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, field, name))
+	h.Set("Content-Type", "application/octet-stream")
+	h.Set("Content-Encoding", "gzip")
+	if part, err := writer.CreatePart(h); err == nil {
+		part.Write(data)
+	}
+}
 
-	if err := w.send(ctx, buf, contentType); err != nil {
+// compressUploads reports whether file parts are gzipped: requested by
+// Config.CompressConfigUploads and not refused by the service.
+func (w *ConfigWatcher) compressUploads() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.cfg.CompressConfigUploads && !w.plainOnly
+}
+
+// sendSnapshot uploads snap. If the service answers a compressed upload
+// with 415 Unsupported Media Type, it is resent uncompressed and later
+// uploads stay uncompressed.
+func (w *ConfigWatcher) sendSnapshot(ctx context.Context, snap configSnapshot) error {
+	compress := w.compressUploads()
+	buf, contentType := w.encodeSnapshot(snap, compress)
+	err := w.send(ctx, buf, contentType)
+	var herr *HTTPStatusError
+	if compress && errors.As(err, &herr) && herr.Status == http.StatusUnsupportedMediaType {
+		logger.Warn().Msg("config watcher: service does not accept compressed uploads; sending uncompressed")
+		w.mu.Lock()
+		w.plainOnly = true
+		w.mu.Unlock()
+		buf, contentType = w.encodeSnapshot(snap, false)
+		err = w.send(ctx, buf, contentType)
+	}
+	return err
+}
+
+func (w *ConfigWatcher) sendConfig(ctx context.Context) {
+	if err := w.sendSnapshot(ctx, w.takeSnapshot()); err != nil {
 		logger.Error().Err(err).Msg("config watcher: send error")
 		return
 	}
@@ -152,13 +267,10 @@ func (w *ConfigWatcher) sendConfigWithRetry(ctx context.Context) {
 	const retryInterval = 5 * time.Second
 	retryCount := 0
 
-	snapshot, contentType := w.buildMultipartPayload()
-	snapshotBytes := snapshot.Bytes()
+	snapshot := w.takeSnapshot()
 
 	for {
-		reader := bytes.NewReader(snapshotBytes)
-
-		if err := w.send(ctx, reader, contentType); err == nil {
+		if err := w.sendSnapshot(ctx, snapshot); err == nil {
 			if retryCount > 0 {
 				logger.Info().Int("retries", retryCount).Msg("config watcher: sent configuration update after retries")
 			} else {
@@ -175,7 +287,7 @@ func (w *ConfigWatcher) sendConfigWithRetry(ctx context.Context) {
 		case <-ctx.Done():
 			logger.Info().Msg("config watcher: stopping retry due to context cancellation")
 			return
-		case <-time.After(retryInterval):
+		case <-w.clock.After(retryInterval):
 			// Continue to next retry
 		}
 	}
@@ -189,7 +301,11 @@ func (w *ConfigWatcher) readFile(path string) (string, error) {
 	return string(data), nil
 }
 
-func (w *ConfigWatcher) errorToCode(err error) string {
+func (w *ConfigWatcher) errorToCode(err error) string { return fileErrorCode(err) }
+
+// fileErrorCode maps a file read error to the code reported in place of
+// the file's contents.
+func fileErrorCode(err error) string {
 	if os.IsNotExist(err) {
 		return ErrCodeFileNotFound
 	}
@@ -203,27 +319,38 @@ func (w *ConfigWatcher) errorToCode(err error) string {
 }
 
 func (w *ConfigWatcher) send(ctx context.Context, body io.Reader, contentType string) error {
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.configURL(), body)
+	return postSnapshot(ctx, w.httpClient, w.cfg, w.configURL(), body, contentType)
+}
+
+// postSnapshot uploads a watcher's multipart snapshot to url with the
+// agent's identity and auth headers.
+func postSnapshot(ctx context.Context, client *http.Client, cfg *Config, url string, body io.Reader, contentType string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, body)
 	if err != nil {
 		return fmt.Errorf("create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", contentType)
-	req.Header.Set("X-Cosmos-Analyzer-Chain-Id", w.cfg.ChainID)
-	req.Header.Set("X-Cosmos-Analyzer-Node-Id", w.cfg.NodeID)
-	if w.cfg.AuthKey != "" {
-		req.Header.Set("Authorization", "Bearer "+w.cfg.AuthKey)
+	req.Header.Set("User-Agent", userAgent(cfg))
+	req.Header.Set("X-Cosmos-Analyzer-Chain-Id", cfg.ChainID)
+	req.Header.Set("X-Cosmos-Analyzer-Node-Id", cfg.NodeID)
+	if cfg.AuthKey != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.AuthKey)
 	}
+	setLabelHeaders(req.Header, cfg.Labels)
 
-	resp, err := w.httpClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		return fmt.Errorf("http request: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if err := checkRedirect(req, resp); err != nil {
+		return err
+	}
 	if resp.StatusCode >= 400 {
 		respBody, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(respBody))
+		return &HTTPStatusError{Status: resp.StatusCode, Body: string(respBody)}
 	}
 
 	return nil
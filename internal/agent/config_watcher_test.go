@@ -1,6 +1,8 @@
 package agent
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"io"
 	"net/http"
@@ -636,3 +638,332 @@ func TestConfigWatcher_SendsCapturedAtTimestamp(t *testing.T) {
 	}
 }
 
+
+func TestConfigWatcher_DebounceFakeClock(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		posts int
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		posts++
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	clk := newFakeClock()
+	w := NewConfigWatcher(&Config{NodeHome: t.TempDir(), ServiceURL: ts.URL})
+	w.clock = clk
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return posts
+	}
+
+	// A burst of changes collapses into one upload after the quiet period
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		w.debounceSend(ctx, 100*time.Millisecond)
+		clk.Advance(50 * time.Millisecond)
+	}
+	if n := count(); n != 0 {
+		t.Fatalf("uploads during burst = %d, want 0", n)
+	}
+	clk.Advance(50 * time.Millisecond)
+	if n := count(); n != 1 {
+		t.Fatalf("uploads after quiet period = %d, want 1", n)
+	}
+	clk.Advance(time.Hour)
+	if n := count(); n != 1 {
+		t.Fatalf("uploads later = %d, want 1", n)
+	}
+}
+
+func TestConfigWatcher_CustomConfigPaths(t *testing.T) {
+	tmpDir := t.TempDir()
+	appDir := filepath.Join(tmpDir, "etc")
+	cometDir := filepath.Join(tmpDir, "cometbft")
+	for _, dir := range []string{appDir, cometDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	appPath := filepath.Join(appDir, "app-v2.toml")
+	cometPath := filepath.Join(cometDir, "node.toml")
+	if err := os.WriteFile(appPath, []byte("app = 1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cometPath, []byte("comet = 1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	type upload struct{ app, comet string }
+	var (
+		mu      sync.Mutex
+		uploads []upload
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(10 << 20); err != nil {
+			t.Errorf("parse multipart form: %v", err)
+		}
+		var u upload
+		if f, _, err := r.FormFile("app_config"); err == nil {
+			data, _ := io.ReadAll(f)
+			u.app = string(data)
+			f.Close()
+		}
+		if f, _, err := r.FormFile("comet_config"); err == nil {
+			data, _ := io.ReadAll(f)
+			u.comet = string(data)
+			f.Close()
+		}
+		mu.Lock()
+		uploads = append(uploads, u)
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	cfg := &Config{
+		NodeHome:        tmpDir,
+		ServiceURL:      ts.URL,
+		AppConfigPath:   "etc/app-v2.toml",
+		CometConfigPath: "cometbft/node.toml",
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go NewConfigWatcher(cfg).Run(ctx)
+
+	last := func() (upload, int) {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(uploads) == 0 {
+			return upload{}, 0
+		}
+		return uploads[len(uploads)-1], len(uploads)
+	}
+	waitFor := func(want upload) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if got, _ := last(); got == want {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		got, n := last()
+		t.Fatalf("last of %d uploads = %+v, want %+v", n, got, want)
+	}
+
+	waitFor(upload{app: "app = 1", comet: "comet = 1"})
+	// Both directories are watched
+	if err := os.WriteFile(cometPath, []byte("comet = 2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(upload{app: "app = 1", comet: "comet = 2"})
+	if err := os.WriteFile(appPath, []byte("app = 2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(upload{app: "app = 2", comet: "comet = 2"})
+}
+
+func TestConfigWatcher_ReattachesRecreatedDir(t *testing.T) {
+	tmpDir := t.TempDir()
+	configDir := filepath.Join(tmpDir, "config")
+	writeConfig := func(app string) {
+		t.Helper()
+		if err := os.MkdirAll(configDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(configDir, "app.toml"), []byte(app), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig("v = 1")
+
+	var (
+		mu   sync.Mutex
+		apps []string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var app string
+		if f, _, err := r.FormFile("app_config"); err == nil {
+			data, _ := io.ReadAll(f)
+			app = string(data)
+			f.Close()
+		}
+		mu.Lock()
+		apps = append(apps, app)
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	clk := newFakeClock()
+	w := NewConfigWatcher(&Config{NodeHome: tmpDir, ServiceURL: ts.URL})
+	w.clock = clk
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx)
+
+	// Keep the fake clock moving until an upload of want arrives
+	waitFor := func(want string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			mu.Lock()
+			got := len(apps) > 0 && apps[len(apps)-1] == want
+			mu.Unlock()
+			if got {
+				return
+			}
+			clk.Advance(watchRetryInterval)
+			time.Sleep(10 * time.Millisecond)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		t.Fatalf("uploads = %q, want a last upload of %q", apps, want)
+	}
+	waitFor("v = 1")
+
+	// The node home is reinitialised: the directory goes away and returns
+	if err := os.RemoveAll(configDir); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	writeConfig("v = 2")
+	waitFor("v = 2")
+
+	// Events from the recreated directory are delivered again
+	if err := os.WriteFile(filepath.Join(configDir, "app.toml"), []byte("v = 3"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("v = 3")
+}
+
+func TestConfigWatcher_RefusesPlaintextAuthKey(t *testing.T) {
+	tmpDir := t.TempDir()
+	var mu sync.Mutex
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	cfg := &Config{
+		NodeHome:   tmpDir,
+		ServiceURL: ts.URL,
+		ChainID:    "test-chain",
+		NodeID:     "test-node",
+		AuthKey:    "secret",
+	}
+
+	done := make(chan struct{})
+	go func() {
+		NewConfigWatcher(cfg).Run(context.Background())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not return for a plaintext service url with an auth key")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if requests != 0 {
+		t.Errorf("requests = %d, want 0", requests)
+	}
+}
+
+func TestConfigWatcher_CompressedUploads(t *testing.T) {
+	tmpDir := t.TempDir()
+	configDir := filepath.Join(tmpDir, "config")
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	appContent := strings.Repeat("minimum-gas-prices = \"0stake\"\n", 200)
+	if err := os.WriteFile(filepath.Join(configDir, "app.toml"), []byte(appContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// config.toml is missing, so its error code is still reported
+
+	type upload struct {
+		encoding, cometError string
+		raw                  []byte
+	}
+	var (
+		mu       sync.Mutex
+		uploads  []upload
+		rejectGz = true
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(10 << 20); err != nil {
+			t.Errorf("parse multipart: %v", err)
+		}
+		var u upload
+		u.cometError = r.FormValue("comet_error")
+		if file, hdr, err := r.FormFile("app_config"); err == nil {
+			u.encoding = hdr.Header.Get("Content-Encoding")
+			u.raw, _ = io.ReadAll(file)
+			file.Close()
+		}
+		mu.Lock()
+		uploads = append(uploads, u)
+		reject := rejectGz && u.encoding == "gzip"
+		mu.Unlock()
+		if reject {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	cfg := &Config{
+		NodeHome:              tmpDir,
+		ServiceURL:            ts.URL,
+		ChainID:               "test-chain",
+		NodeID:                "test-node",
+		CompressConfigUploads: true,
+	}
+	watcher := NewConfigWatcher(cfg)
+
+	// Accepted compressed
+	mu.Lock()
+	rejectGz = false
+	mu.Unlock()
+	watcher.sendConfig(context.Background())
+	if len(uploads) != 1 || uploads[0].encoding != "gzip" {
+		t.Fatalf("uploads = %+v, want one gzip upload", uploads)
+	}
+	if len(uploads[0].raw) >= len(appContent) {
+		t.Errorf("compressed part is %d bytes, not smaller than %d", len(uploads[0].raw), len(appContent))
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(uploads[0].raw))
+	if err != nil {
+		t.Fatalf("app_config part is not gzip: %v", err)
+	}
+	got, _ := io.ReadAll(zr)
+	if string(got) != appContent {
+		t.Errorf("decompressed app_config differs from app.toml")
+	}
+	if uploads[0].cometError != ErrCodeFileNotFound {
+		t.Errorf("comet_error = %q, want %q", uploads[0].cometError, ErrCodeFileNotFound)
+	}
+
+	// Refused with 415: resent plain, and later uploads stay plain
+	mu.Lock()
+	rejectGz = true
+	uploads = nil
+	mu.Unlock()
+	watcher.sendConfig(context.Background())
+	watcher.sendConfig(context.Background())
+	if len(uploads) != 3 || uploads[0].encoding != "gzip" || uploads[1].encoding != "" || uploads[2].encoding != "" {
+		t.Fatalf("uploads after 415 = %+v, want gzip then two plain", uploads)
+	}
+	if string(uploads[1].raw) != appContent || string(uploads[2].raw) != appContent {
+		t.Errorf("plain fallback did not carry app.toml")
+	}
+}
@@ -0,0 +1,47 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"time"
+)
+
+const heartbeatEndpoint = "/v1/ingest/heartbeat"
+
+// heartbeatTimeout bounds one heartbeat post, so a slow service cannot
+// hold up reading for the full HTTPTimeout.
+const heartbeatTimeout = 10 * time.Second
+
+// heartbeat is the body posted while the agent has nothing to ship, so
+// the service can tell a quiet chain from a dead agent.
+type heartbeat struct {
+	ChainID   string    `json:"chain_id"`
+	NodeID    string    `json:"node_id"`
+	IdxFile   string    `json:"idx_file"`
+	IdxOffset int64     `json:"idx_offset"`
+	SentAt    time.Time `json:"sent_at"`
+}
+
+// sendHeartbeat posts the current read position to the heartbeat endpoint.
+func sendHeartbeat(ctx context.Context, client *http.Client, cfg *Config, st state) error {
+	endpoint := cfg.HeartbeatEndpoint
+	if endpoint == "" {
+		endpoint = heartbeatEndpoint
+	}
+	body, err := json.Marshal(heartbeat{
+		ChainID:   cfg.ChainID,
+		NodeID:    cfg.NodeID,
+		IdxFile:   filepath.Base(st.IdxPath),
+		IdxOffset: st.IdxOffset,
+		SentAt:    time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, heartbeatTimeout)
+	defer cancel()
+	return postSnapshot(ctx, client, cfg, cfg.serviceBase()+endpoint, bytes.NewReader(body), "application/json")
+}
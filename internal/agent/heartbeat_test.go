@@ -0,0 +1,112 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRun_HeartbeatWhileIdle(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		beats []heartbeat
+		times []time.Time
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != heartbeatEndpoint {
+			t.Errorf("unexpected request to %s", r.URL.Path)
+			return
+		}
+		var hb heartbeat
+		if err := json.NewDecoder(r.Body).Decode(&hb); err != nil {
+			t.Errorf("decode heartbeat: %v", err)
+		}
+		mu.Lock()
+		beats = append(beats, hb)
+		times = append(times, time.Now())
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	// An index with no frames yet
+	tmpDir := t.TempDir()
+	walDir := filepath.Join(tmpDir, "wal")
+	if err := os.MkdirAll(walDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(walDir, "seg-000001.wal.idx"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	const interval = 40 * time.Millisecond
+	cfg := Config{
+		ServiceURL:        ts.URL,
+		ChainID:           "chain",
+		NodeID:            "node",
+		WALDir:            walDir,
+		StateDir:          filepath.Join(tmpDir, "state"),
+		PollInterval:      2 * time.Millisecond,
+		SendInterval:      time.Millisecond,
+		HardInterval:      time.Millisecond,
+		HeartbeatInterval: interval,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*interval+interval/2)
+	defer cancel()
+	_ = Run(ctx, cfg)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(beats) < 5 || len(beats) > 10 {
+		t.Fatalf("sent %d heartbeats in %v, want about one per %v", len(beats), 10*interval, interval)
+	}
+	for i := 1; i < len(times); i++ {
+		if gap := times[i].Sub(times[i-1]); gap < interval-5*time.Millisecond {
+			t.Errorf("heartbeats %d and %d only %v apart, want at least %v", i-1, i, gap, interval)
+		}
+	}
+	if hb := beats[0]; hb.ChainID != "chain" || hb.NodeID != "node" || hb.IdxFile != "seg-000001.wal.idx" || hb.SentAt.IsZero() {
+		t.Errorf("heartbeat = %+v, want chain, node, index and timestamp", hb)
+	}
+}
+
+func TestRun_NoHeartbeatByDefault(t *testing.T) {
+	var calls int
+	var mu sync.Mutex
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	tmpDir := t.TempDir()
+	walDir := filepath.Join(tmpDir, "wal")
+	if err := os.MkdirAll(walDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(walDir, "seg-000001.wal.idx"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := Config{
+		ServiceURL:   ts.URL,
+		WALDir:       walDir,
+		StateDir:     filepath.Join(tmpDir, "state"),
+		PollInterval: time.Millisecond,
+		SendInterval: time.Millisecond,
+		HardInterval: time.Millisecond,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_ = Run(ctx, cfg)
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != 0 {
+		t.Errorf("made %d requests with heartbeats disabled, want 0", calls)
+	}
+}
@@ -0,0 +1,351 @@
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bft-labs/walship/pkg/backoff"
+)
+
+// batchKey returns a stable idempotency key for a batch: a hash of the
+// chain and node, the day directory of its index (segment numbers restart
+// each day), the first and last frame, and the total payload bytes. A
+// retry of the same batch yields the same key, so the service can drop a
+// resend whose earlier response was lost.
+func batchKey(chainID, nodeID, dayDir string, batch []batchFrame, batchBytes int) string {
+	if len(batch) == 0 {
+		return ""
+	}
+	first, last := batch[0].Meta, batch[len(batch)-1].Meta
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s:%d\x00%s:%d\x00%d", chainID, nodeID, dayDir, first.File, first.Frame, last.File, last.Frame, batchBytes)
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// dnsLogEvery is how many consecutive DNS failures share one log line.
+const dnsLogEvery = 10
+
+// DNSErrorEvent reports a send that failed because Host did not resolve.
+// Failures counts consecutive such sends, this one included.
+type DNSErrorEvent struct {
+	Host     string
+	Failures int
+	Err      error
+}
+
+// handleDNSError counts a failed lookup of the service host, logs the
+// first of every dnsLogEvery in a row, and waits cfg.DNSBackoff (or the
+// regular backoff when unset).
+func handleDNSError(ctx context.Context, cfg Config, ss *sendState, dnsErr *net.DNSError, err error, back *backoff.Backoff) {
+	ss.DNSFailures++
+	if (ss.DNSFailures-1)%dnsLogEvery == 0 {
+		logger.Error().
+			Err(err).
+			Str("host", dnsErr.Name).
+			Int("failures", ss.DNSFailures).
+			Msg("cannot resolve service host")
+	}
+	if cfg.OnDNSError != nil {
+		cfg.OnDNSError(DNSErrorEvent{Host: dnsErr.Name, Failures: ss.DNSFailures, Err: err})
+	}
+	if cfg.DNSBackoff <= 0 {
+		retryWait(ctx, ss, back)
+		return
+	}
+	start := time.Now()
+	t := time.NewTimer(cfg.DNSBackoff)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+	ss.TotalBackoff += time.Since(start)
+}
+
+// probeTTL bounds how long after a timed-out upload ProbeBeforeRetry
+// still probes; a retry later than this uploads without asking.
+const probeTTL = 5 * time.Minute
+
+// isTimeout reports whether err is a client or network timeout, after
+// which the service may still have processed the request.
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// probeBatch asks the status endpoint whether the batch with idempotency
+// key was accepted. A 2xx response means it was, 404 that it was not; any
+// other status is an error.
+func probeBatch(ctx context.Context, cfg Config, httpClient *http.Client, key string) (bool, error) {
+	endpoint := cfg.StatusEndpoint
+	if endpoint == "" {
+		endpoint = statusEndpoint
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, cfg.serviceBase()+endpoint, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Authorization", "Bearer "+cfg.AuthKey)
+	req.Header.Set("User-Agent", userAgent(&cfg))
+	req.Header.Set("X-Cosmos-Analyzer-Chain-Id", cfg.ChainID)
+	req.Header.Set("X-Cosmos-Analyzer-Node-Id", cfg.NodeID)
+	req.Header.Set("Idempotency-Key", key)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode/100 == 2:
+		return true, nil
+	case resp.StatusCode == http.StatusNotFound:
+		return false, nil
+	}
+	return false, &HTTPStatusError{Status: resp.StatusCode}
+}
+
+// newHTTPClient returns the client used for uploads. Redirects are not
+// followed: Go does not replay POST bodies across 301/302/303, so following
+// one turns an upload into a bodiless GET that appears to succeed.
+func newHTTPClient(timeout time.Duration, cfg *Config) *http.Client {
+	var rt http.RoundTripper = newTransport(cfg)
+	if cfg.HTTPTrace != nil {
+		rt = &traceTransport{next: rt, w: cfg.HTTPTrace}
+	}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: rt,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// unixScheme prefixes a ServiceURL that names a Unix domain socket, e.g.
+// unix:///var/run/ingest.sock, for a sidecar ingest proxy.
+const unixScheme = "unix://"
+
+// unixSocket returns the socket path of a unix:// service URL.
+func unixSocket(serviceURL string) (string, bool) {
+	if !strings.HasPrefix(serviceURL, unixScheme) {
+		return "", false
+	}
+	return strings.TrimPrefix(serviceURL, unixScheme), true
+}
+
+// checkServiceScheme rejects sending AuthKey in clear text: with a key set
+// the ServiceURL must be https:// or a local unix:// socket unless
+// AllowInsecure is set.
+func (c *Config) checkServiceScheme() error {
+	if c.AuthKey == "" || c.AllowInsecure {
+		return nil
+	}
+	if _, ok := unixSocket(c.ServiceURL); ok {
+		return nil
+	}
+	u, err := url.Parse(c.ServiceURL)
+	if err != nil {
+		return fmt.Errorf("service url %q: %w", c.ServiceURL, err)
+	}
+	if !strings.EqualFold(u.Scheme, "https") {
+		return fmt.Errorf("service url %q is not https; refusing to send the auth key in clear text (set allow-insecure to override)", c.ServiceURL)
+	}
+	return nil
+}
+
+// serviceBase returns the base URL requests are built on. For a Unix
+// socket the host is a placeholder; the transport dials the socket.
+func (c *Config) serviceBase() string {
+	if _, ok := unixSocket(c.ServiceURL); ok {
+		return "http://unix"
+	}
+	return c.ServiceURL
+}
+
+// newTransport returns a transport that keeps the connection to the service
+// open between sends, so sparse uploads do not pay a TLS handshake each
+// time. cfg.KeepAlive is both the TCP keep-alive period and how long an
+// idle connection is kept; zero keeps the net/http defaults. Proxies come
+// from HTTP_PROXY/HTTPS_PROXY/NO_PROXY unless cfg.ProxyURL is set. A
+// unix:// ServiceURL dials that socket directly.
+func newTransport(cfg *Config) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.ForceAttemptHTTP2 = true
+	t.MaxIdleConnsPerHost = 4
+	if cfg.ProxyURL != "" {
+		if u, err := url.Parse(cfg.ProxyURL); err == nil {
+			t.Proxy = proxyExcept(u, noProxyFromEnv())
+		}
+	}
+	if keepAlive := cfg.KeepAlive; keepAlive > 0 {
+		t.IdleConnTimeout = keepAlive
+		t.DialContext = (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: keepAlive,
+		}).DialContext
+	}
+	if sock, ok := unixSocket(cfg.ServiceURL); ok {
+		// Every request goes to the socket, never through a proxy
+		t.Proxy = nil
+		t.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", sock)
+		}
+	}
+	return t
+}
+
+// noProxyFromEnv returns the NO_PROXY (or no_proxy) entries.
+func noProxyFromEnv() []string {
+	v := os.Getenv("NO_PROXY")
+	if v == "" {
+		v = os.Getenv("no_proxy")
+	}
+	var entries []string
+	for _, e := range strings.Split(v, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			entries = append(entries, strings.ToLower(e))
+		}
+	}
+	return entries
+}
+
+// proxyExcept sends every request through proxy unless its host matches
+// noProxy: "*" matches everything, a CIDR block any IP host inside it, an
+// IP the same address, and other entries the host itself or, as a domain,
+// any host under it. Ports on entries are ignored.
+func proxyExcept(proxy *url.URL, noProxy []string) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		host := strings.ToLower(req.URL.Hostname())
+		ip := net.ParseIP(host)
+		for _, e := range noProxy {
+			if _, block, err := net.ParseCIDR(e); err == nil {
+				if ip != nil && block.Contains(ip) {
+					return nil, nil
+				}
+				continue
+			}
+			if h, _, err := net.SplitHostPort(e); err == nil {
+				e = h
+			}
+			if eip := net.ParseIP(strings.Trim(e, "[]")); eip != nil {
+				if ip != nil && eip.Equal(ip) {
+					return nil, nil
+				}
+				continue
+			}
+			e = strings.TrimPrefix(e, ".")
+			if e == "*" || host == e || strings.HasSuffix(host, "."+e) {
+				return nil, nil
+			}
+		}
+		return proxy, nil
+	}
+}
+
+// labelHeaderPrefix prefixes the header carrying each Config.Labels entry.
+const labelHeaderPrefix = "X-Walship-Label-"
+
+// setLabelHeaders adds one header per label. Header names are
+// case-insensitive, so the service sees keys in canonical form.
+func setLabelHeaders(h http.Header, labels map[string]string) {
+	for k, v := range labels {
+		h.Set(labelHeaderPrefix+k, v)
+	}
+}
+
+// validLabel reports whether a label can be sent as a header: the key is
+// non-empty letters, digits and '-', and the value has no control
+// characters.
+func validLabel(k, v string) bool {
+	if k == "" {
+		return false
+	}
+	for _, c := range k {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	for _, c := range v {
+		if c < ' ' || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// Errors matched (via errors.Is) by the errors trySend returns for specific
+// service responses.
+var (
+	// ErrUnauthorized: the service rejected the auth key (401 or 403).
+	// Retrying cannot succeed, so Run stops.
+	ErrUnauthorized = errors.New("service rejected the auth key")
+	// ErrPayloadTooLarge: the service rejected the batch as too large (413).
+	ErrPayloadTooLarge = errors.New("service rejected the batch as too large")
+	// ErrRateLimited: the service asked the agent to slow down (429).
+	ErrRateLimited = errors.New("rate limited by service")
+)
+
+// HTTPStatusError reports a non-2xx response from the service. It matches
+// ErrUnauthorized, ErrPayloadTooLarge or ErrRateLimited by status.
+type HTTPStatusError struct {
+	Status int
+	Body   string
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("server returned status %d: %s", e.Status, e.Body)
+}
+
+func (e *HTTPStatusError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.Status == http.StatusUnauthorized || e.Status == http.StatusForbidden
+	case ErrPayloadTooLarge:
+		return e.Status == http.StatusRequestEntityTooLarge
+	case ErrRateLimited:
+		return e.Status == http.StatusTooManyRequests
+	}
+	return false
+}
+
+// redirectError reports that the service answered an upload with a redirect,
+// which means the body was not ingested.
+type redirectError struct {
+	Status   int
+	URL      string
+	Location string
+}
+
+func (e *redirectError) Error() string {
+	return fmt.Sprintf("service redirected %s to %q (status %d); the upload was not ingested, update service_url to the new location", e.URL, e.Location, e.Status)
+}
+
+// checkRedirect returns a redirectError if resp is a 3xx or if the client
+// followed a redirect away from the original request URL.
+func checkRedirect(req *http.Request, resp *http.Response) error {
+	if resp.StatusCode/100 == 3 {
+		return &redirectError{
+			Status:   resp.StatusCode,
+			URL:      req.URL.String(),
+			Location: resp.Header.Get("Location"),
+		}
+	}
+	if resp.Request != nil && resp.Request.URL.String() != req.URL.String() {
+		return &redirectError{
+			Status:   resp.StatusCode,
+			URL:      req.URL.String(),
+			Location: resp.Request.URL.String(),
+		}
+	}
+	return nil
+}
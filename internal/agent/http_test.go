@@ -0,0 +1,619 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// newRedirectServer returns a server whose ingest paths 301 to /moved, which
+// answers 200 to anything (mimicking a target that discards the body).
+func newRedirectServer(t *testing.T) (*httptest.Server, *int) {
+	t.Helper()
+	var movedHits int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		movedHits++
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/moved", http.StatusMovedPermanently)
+	})
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return ts, &movedHits
+}
+
+func TestTrySend_Redirect(t *testing.T) {
+	ts, movedHits := newRedirectServer(t)
+
+	clients := map[string]*http.Client{
+		"agent client":   newHTTPClient(time.Second, &Config{}),
+		"default client": http.DefaultClient, // follows the redirect as a GET
+	}
+	for name, client := range clients {
+		t.Run(name, func(t *testing.T) {
+			cfg := Config{ServiceURL: ts.URL}
+			batch := []batchFrame{{Meta: FrameMeta{File: "f", Frame: 1}, Compressed: []byte("x"), IdxLineLen: 10}}
+			batchBytes := 1
+			st := state{}
+			var ss sendState
+			back := newBackoff(time.Millisecond, time.Millisecond)
+
+			err := trySend(context.Background(), cfg, client, &batch, &batchBytes, &st, &ss, "000.idx", nil, false, back)
+
+			var re *redirectError
+			if !errors.As(err, &re) {
+				t.Fatalf("trySend() error = %v, want redirectError", err)
+			}
+			if !strings.Contains(re.Location, "/moved") {
+				t.Errorf("Location = %q, want redirect target", re.Location)
+			}
+			if !strings.Contains(err.Error(), "update service_url") {
+				t.Errorf("error %q should suggest updating service_url", err)
+			}
+			if len(batch) != 1 || st.IdxOffset != 0 {
+				t.Error("redirected send must not commit the batch")
+			}
+		})
+	}
+
+	if *movedHits != 1 {
+		t.Errorf("redirect target hits = %d, want 1 (only from the default client)", *movedHits)
+	}
+}
+
+func TestConfigWatcher_Redirect(t *testing.T) {
+	ts, movedHits := newRedirectServer(t)
+
+	watcher := NewConfigWatcher(&Config{ServiceURL: ts.URL})
+	err := watcher.send(context.Background(), bytes.NewReader(nil), "text/plain")
+
+	var re *redirectError
+	if !errors.As(err, &re) {
+		t.Fatalf("send() error = %v, want redirectError", err)
+	}
+	if re.Status != http.StatusMovedPermanently {
+		t.Errorf("Status = %d, want 301", re.Status)
+	}
+	if *movedHits != 0 {
+		t.Errorf("redirect should not be followed, target hits = %d", *movedHits)
+	}
+}
+
+func TestTrySend_IdempotencyKey(t *testing.T) {
+	var keys, batchIDs []string
+	fail := true
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		batchIDs = append(batchIDs, r.Header.Get("X-Batch-Id"))
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	cfg := Config{ServiceURL: ts.URL, StateDir: t.TempDir(), ChainID: "chain", NodeID: "node"}
+	st := state{}
+	var ss sendState
+	back := newBackoff(time.Millisecond, time.Millisecond)
+	newBatch := func(frames ...uint64) ([]batchFrame, int) {
+		var b []batchFrame
+		for _, f := range frames {
+			b = append(b, batchFrame{Meta: FrameMeta{File: "seg-000001.wal.gz", Frame: f}, Compressed: []byte("data"), IdxLineLen: 1})
+		}
+		return b, 4 * len(frames)
+	}
+
+	// First attempt fails; the retry of the same batch must reuse the key.
+	batch, batchBytes := newBatch(1, 2)
+	if err := trySend(context.Background(), cfg, http.DefaultClient, &batch, &batchBytes, &st, &ss, "000.idx", nil, false, back); err == nil {
+		t.Fatal("expected first attempt to fail")
+	}
+	fail = false
+	if err := trySend(context.Background(), cfg, http.DefaultClient, &batch, &batchBytes, &st, &ss, "000.idx", nil, false, back); err != nil {
+		t.Fatalf("retry error = %v", err)
+	}
+
+	// A different batch gets a different key.
+	batch, batchBytes = newBatch(3, 4)
+	if err := trySend(context.Background(), cfg, http.DefaultClient, &batch, &batchBytes, &st, &ss, "000.idx", nil, false, back); err != nil {
+		t.Fatalf("second batch error = %v", err)
+	}
+
+	if len(keys) != 3 {
+		t.Fatalf("got %d requests, want 3", len(keys))
+	}
+	if keys[0] == "" || keys[0] != keys[1] {
+		t.Errorf("retry keys = %q, %q; want equal and non-empty", keys[0], keys[1])
+	}
+	if keys[2] == keys[0] {
+		t.Errorf("distinct batches share key %q", keys[2])
+	}
+	for i := range keys {
+		if batchIDs[i] != keys[i] {
+			t.Errorf("request %d: X-Batch-Id = %q, want %q", i, batchIDs[i], keys[i])
+		}
+	}
+
+	// The node identity is part of the key.
+	a, _ := newBatch(1, 2)
+	if batchKey("chain", "node", "/wal/2026-01-01", a, 8) == batchKey("chain", "other", "/wal/2026-01-01", a, 8) {
+		t.Error("key does not depend on node id")
+	}
+	// Segment numbers restart each day, so the day is too.
+	if batchKey("chain", "node", "/wal/2026-01-01", a, 8) == batchKey("chain", "node", "/wal/2026-01-02", a, 8) {
+		t.Error("key does not depend on the day")
+	}
+}
+
+func TestHTTPClient_ReusesConnection(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	client := newHTTPClient(time.Second, &Config{KeepAlive: time.Minute})
+	tr := client.Transport.(*http.Transport)
+	var dials atomic.Int32
+	dial := tr.DialContext
+	tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dials.Add(1)
+		return dial(ctx, network, addr)
+	}
+
+	cfg := Config{ServiceURL: ts.URL}
+	st := state{}
+	var ss sendState
+	back := newBackoff(time.Millisecond, time.Millisecond)
+	for i := 0; i < 3; i++ {
+		batch := []batchFrame{{Meta: FrameMeta{File: "f", Frame: uint64(i + 1)}, Compressed: []byte("x"), IdxLineLen: 10}}
+		batchBytes := 1
+		if err := trySend(context.Background(), cfg, client, &batch, &batchBytes, &st, &ss, "000.idx", nil, false, back); err != nil {
+			t.Fatalf("send %d: %v", i, err)
+		}
+	}
+	if n := dials.Load(); n != 1 {
+		t.Errorf("dialed %d connections for 3 sends, want 1", n)
+	}
+}
+
+func TestHTTPClient_ProxyURL(t *testing.T) {
+	var direct, proxied atomic.Int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		direct.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer target.Close()
+	// A forward proxy receives the absolute target URL; answering itself is
+	// enough to show the request was routed through it.
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Host != strings.TrimPrefix(target.URL, "http://") {
+			t.Errorf("proxy got request for %q", r.URL)
+		}
+		proxied.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+
+	tests := []struct {
+		name    string
+		noProxy string
+		via     *atomic.Int32
+	}{
+		{"through proxy", "", &proxied},
+		{"NO_PROXY host", "127.0.0.1", &direct},
+		{"NO_PROXY host with port", "example.com, 127.0.0.1:9999", &direct},
+		{"NO_PROXY other domain", ".example.com", &proxied},
+		{"NO_PROXY wildcard", "*", &direct},
+		{"NO_PROXY CIDR", "10.0.0.0/8, 127.0.0.0/8", &direct},
+		{"NO_PROXY other CIDR", "10.0.0.0/8", &proxied},
+		{"NO_PROXY IPv6 CIDR", "::1/128", &proxied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("NO_PROXY", tt.noProxy)
+			direct.Store(0)
+			proxied.Store(0)
+
+			client := newHTTPClient(time.Second, &Config{ProxyURL: proxy.URL})
+			resp, err := client.Get(target.URL)
+			if err != nil {
+				t.Fatalf("GET error = %v", err)
+			}
+			resp.Body.Close()
+			if tt.via.Load() != 1 || direct.Load()+proxied.Load() != 1 {
+				t.Errorf("direct = %d, proxied = %d", direct.Load(), proxied.Load())
+			}
+		})
+	}
+}
+
+func TestTrySend_StatusErrors(t *testing.T) {
+	tests := []struct {
+		status int
+		want   error
+	}{
+		{http.StatusUnauthorized, ErrUnauthorized},
+		{http.StatusForbidden, ErrUnauthorized},
+		{http.StatusRequestEntityTooLarge, ErrPayloadTooLarge},
+		{http.StatusTooManyRequests, ErrRateLimited},
+		{http.StatusInternalServerError, nil},
+	}
+	sentinels := []error{ErrUnauthorized, ErrPayloadTooLarge, ErrRateLimited}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				io.WriteString(w, "nope")
+			}))
+			defer ts.Close()
+
+			cfg := Config{ServiceURL: ts.URL}
+			batch := []batchFrame{{Meta: FrameMeta{File: "f", Frame: 1}, Compressed: []byte("x"), IdxLineLen: 10}}
+			batchBytes := 1
+			st := state{}
+			var ss sendState
+			err := trySend(context.Background(), cfg, http.DefaultClient, &batch, &batchBytes, &st, &ss, "000.idx", nil, false, newBackoff(time.Millisecond, time.Millisecond))
+			if err == nil {
+				t.Fatal("trySend() succeeded, want error")
+			}
+			for _, s := range sentinels {
+				if got := errors.Is(err, s); got != (s == tt.want) {
+					t.Errorf("errors.Is(%v, %v) = %v", err, s, got)
+				}
+			}
+			if tt.status == http.StatusTooManyRequests {
+				return // carries Retry-After instead of a status
+			}
+			var se *HTTPStatusError
+			if !errors.As(err, &se) || se.Status != tt.status || se.Body != "nope" {
+				t.Errorf("error = %#v, want HTTPStatusError{%d, nope}", err, tt.status)
+			}
+		})
+	}
+}
+
+func TestRun_StopsOnUnauthorized(t *testing.T) {
+	var sends atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == walFramesEndpoint {
+			sends.Add(1)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	tmpDir := t.TempDir()
+	walDir := filepath.Join(tmpDir, "wal")
+	writeTestSegment(t, walDir, 1, "f1\n", "f2\n")
+	cfg := Config{
+		ServiceURL:   ts.URL,
+		WALDir:       walDir,
+		StateDir:     filepath.Join(tmpDir, "state"),
+		PollInterval: time.Millisecond,
+		SendInterval: time.Millisecond,
+		HardInterval: time.Millisecond,
+	}
+	done := make(chan error, 1)
+	go func() { done <- Run(context.Background(), cfg) }()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrUnauthorized) {
+			t.Fatalf("Run() error = %v, want ErrUnauthorized", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run kept retrying after 401")
+	}
+	if n := sends.Load(); n != 1 {
+		t.Errorf("sends = %d, want 1", n)
+	}
+}
+
+func TestLabelHeaders(t *testing.T) {
+	var got []http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Clone())
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	cfg := Config{ServiceURL: ts.URL, Labels: map[string]string{"datacenter": "fra1", "moniker": "val 1"}}
+	batch := []batchFrame{{Meta: FrameMeta{File: "f", Frame: 1}, Compressed: []byte("x"), IdxLineLen: 10}}
+	batchBytes := 1
+	st := state{}
+	var ss sendState
+	if err := trySend(context.Background(), cfg, http.DefaultClient, &batch, &batchBytes, &st, &ss, "000.idx", nil, false, newBackoff(time.Millisecond, time.Millisecond)); err != nil {
+		t.Fatalf("trySend() error = %v", err)
+	}
+	if err := postSnapshot(context.Background(), http.DefaultClient, &cfg, ts.URL+configEndpoint, strings.NewReader("{}"), "application/json"); err != nil {
+		t.Fatalf("postSnapshot() error = %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("requests = %d, want 2", len(got))
+	}
+	for i, h := range got {
+		if v := h.Get("X-Walship-Label-Datacenter"); v != "fra1" {
+			t.Errorf("request %d: datacenter label = %q, want fra1", i, v)
+		}
+		if v := h.Get("X-Walship-Label-Moniker"); v != "val 1" {
+			t.Errorf("request %d: moniker label = %q, want %q", i, v, "val 1")
+		}
+	}
+}
+
+func TestRun_CancelInterruptsBackoff(t *testing.T) {
+	var hits atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == walFramesEndpoint {
+			hits.Add(1)
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	tmpDir := t.TempDir()
+	walDir := filepath.Join(tmpDir, "wal")
+	writeTestSegment(t, walDir, 1, "a1\n")
+
+	cfg := Config{
+		ServiceURL:   ts.URL,
+		WALDir:       walDir,
+		StateDir:     filepath.Join(tmpDir, "state"),
+		PollInterval: time.Millisecond,
+		SendInterval: time.Millisecond,
+		HardInterval: time.Millisecond,
+		// Full jitter draws from [0, 1h]; any draw would outlast the test
+		BackoffInitial: time.Hour,
+		BackoffMax:     time.Hour,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Run(ctx, cfg) }()
+	deadline := time.Now().Add(5 * time.Second)
+	for hits.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	// Let the failed send settle into its backoff
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Run() error = %v, want context.Canceled", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not return promptly after cancel during backoff")
+	}
+}
+
+func TestHTTPTrace(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, `{"error":"manifest rejected"}`)
+	}))
+	defer ts.Close()
+
+	var trace bytes.Buffer
+	cfg := Config{ServiceURL: ts.URL, AuthKey: "secret-key", HTTPTrace: &trace}
+	client := newHTTPClient(time.Second, &cfg)
+	batch := []batchFrame{{Meta: FrameMeta{File: "f", Frame: 1}, Compressed: []byte("x"), IdxLineLen: 10}}
+	batchBytes := 1
+	st := state{}
+	var ss sendState
+	err := trySend(context.Background(), cfg, client, &batch, &batchBytes, &st, &ss, "000.idx", nil, false, newBackoff(time.Millisecond, time.Millisecond))
+
+	// The caller still sees the full response body
+	var se *HTTPStatusError
+	if !errors.As(err, &se) || !strings.Contains(se.Body, "manifest rejected") {
+		t.Fatalf("trySend() error = %v, want status error with body", err)
+	}
+	dump := trace.String()
+	for _, want := range []string{
+		"> POST " + ts.URL + walFramesEndpoint,
+		"> Authorization: *****",
+		"> X-Batch-Id: ",
+		"< 400 Bad Request",
+		`{"error":"manifest rejected"}`,
+	} {
+		if !strings.Contains(dump, want) {
+			t.Errorf("trace missing %q:\n%s", want, dump)
+		}
+	}
+	if strings.Contains(dump, "secret-key") {
+		t.Errorf("trace leaks the auth key:\n%s", dump)
+	}
+}
+
+func TestUnixSocketServiceURL(t *testing.T) {
+	// Socket paths are length-limited, so keep this one short
+	dir, err := os.MkdirTemp("", "ws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "ingest.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+
+	var paths []string
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		w.WriteHeader(http.StatusOK)
+	}))
+	ts.Listener = ln
+	ts.Start()
+	defer ts.Close()
+
+	cfg := Config{
+		NodeHome:     "/tmp/root",
+		WALDir:       "/tmp/wal",
+		PollInterval: time.Second,
+		SendInterval: time.Second,
+		ServiceURL:   "unix://" + sock,
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	client := newHTTPClient(time.Second, &cfg)
+	batch := []batchFrame{{Meta: FrameMeta{File: "f", Frame: 1}, Compressed: []byte("x"), IdxLineLen: 10}}
+	batchBytes := 1
+	st := state{}
+	var ss sendState
+	if err := trySend(context.Background(), cfg, client, &batch, &batchBytes, &st, &ss, "000.idx", nil, false, newBackoff(time.Millisecond, time.Millisecond)); err != nil {
+		t.Fatalf("trySend() error = %v", err)
+	}
+	w := NewConfigWatcher(&cfg)
+	if err := postSnapshot(context.Background(), w.httpClient, &cfg, w.configURL(), strings.NewReader("{}"), "application/json"); err != nil {
+		t.Fatalf("postSnapshot() error = %v", err)
+	}
+	if len(paths) != 2 || paths[0] != walFramesEndpoint || paths[1] != configEndpoint {
+		t.Errorf("paths over socket = %v, want [%s %s]", paths, walFramesEndpoint, configEndpoint)
+	}
+
+	cfg.ServiceURL = "unix:///"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() accepted a unix URL without a socket path")
+	}
+}
+
+func TestTrySend_ProbeBeforeRetry(t *testing.T) {
+	tests := []struct {
+		name        string
+		probe       bool
+		accept      bool // whether the timed-out upload was ingested
+		wantUploads int
+		wantProbes  int
+	}{
+		{"accepted", true, true, 1, 1},
+		{"not accepted", true, false, 2, 1},
+		{"disabled", false, true, 2, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu       sync.Mutex
+				uploads  int
+				probes   int
+				accepted = map[string]bool{}
+			)
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				key := r.Header.Get("Idempotency-Key")
+				switch {
+				case r.Method == http.MethodHead && r.URL.Path == statusEndpoint:
+					probes++
+					if !accepted[key] {
+						w.WriteHeader(http.StatusNotFound)
+					}
+				case r.URL.Path == walFramesEndpoint:
+					io.Copy(io.Discard, r.Body)
+					uploads++
+					if uploads == 1 {
+						// Process, then answer too late for the client
+						accepted[key] = tt.accept
+						mu.Unlock()
+						time.Sleep(300 * time.Millisecond)
+						mu.Lock()
+					}
+				}
+			}))
+			defer ts.Close()
+
+			cfg := Config{ServiceURL: ts.URL, ChainID: "chain", NodeID: "node", ProbeBeforeRetry: tt.probe}
+			client := &http.Client{Timeout: 100 * time.Millisecond}
+			batch := []batchFrame{{Meta: FrameMeta{File: "seg-000001.wal.gz", Frame: 1}, Compressed: []byte("data"), IdxLineLen: 10}}
+			batchBytes := 4
+			st := state{}
+			var ss sendState
+			back := newBackoff(time.Millisecond, time.Millisecond)
+			if err := trySend(context.Background(), cfg, client, &batch, &batchBytes, &st, &ss, "seg-000001.wal.idx", nil, true, back); err == nil {
+				t.Fatal("first trySend() error = nil, want timeout")
+			}
+			if err := trySend(context.Background(), cfg, client, &batch, &batchBytes, &st, &ss, "seg-000001.wal.idx", nil, true, back); err != nil {
+				t.Fatalf("retry trySend() error = %v", err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if uploads != tt.wantUploads || probes != tt.wantProbes {
+				t.Errorf("uploads = %d, probes = %d; want %d and %d", uploads, probes, tt.wantUploads, tt.wantProbes)
+			}
+			if len(batch) != 0 || st.IdxOffset != 10 || st.LastFrame != 1 {
+				t.Errorf("batch %d frames, state %+v; want the batch committed", len(batch), st)
+			}
+		})
+	}
+}
+
+func TestTrySend_DNSErrorBackoff(t *testing.T) {
+	var logs bytes.Buffer
+	saved := logger
+	logger = zerolog.New(&logs)
+	defer func() { logger = saved }()
+
+	// Fail every dial the way an unresolvable host does
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return nil, &net.OpError{Op: "dial", Net: network, Err: &net.DNSError{Err: "no such host", Name: "ingest.invalid", IsNotFound: true}}
+		},
+	}}
+	var events []DNSErrorEvent
+	cfg := Config{
+		ServiceURL: "http://ingest.invalid",
+		DNSBackoff: 20 * time.Millisecond,
+		OnDNSError: func(ev DNSErrorEvent) { events = append(events, ev) },
+	}
+	batch := []batchFrame{{Meta: FrameMeta{File: "seg-000001.wal.gz", Frame: 1}, Compressed: []byte("data"), IdxLineLen: 10}}
+	batchBytes := 4
+	st := state{}
+	var ss sendState
+	back := newBackoff(time.Millisecond, time.Millisecond)
+
+	const attempts = dnsLogEvery + 2
+	start := time.Now()
+	for i := 0; i < attempts; i++ {
+		err := trySend(context.Background(), cfg, client, &batch, &batchBytes, &st, &ss, "seg-000001.wal.idx", nil, true, back)
+		var dnsErr *net.DNSError
+		if !errors.As(err, &dnsErr) {
+			t.Fatalf("trySend() error = %v, want a DNS error", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < attempts*cfg.DNSBackoff {
+		t.Errorf("%d DNS failures took %v, want at least %v of DNSBackoff", attempts, elapsed, attempts*cfg.DNSBackoff)
+	}
+	if n := strings.Count(logs.String(), "cannot resolve service host"); n != 2 {
+		t.Errorf("logged %d DNS errors for %d failures, want 2:\n%s", n, attempts, logs.String())
+	}
+	if strings.Contains(logs.String(), `"message":"send batch"`) {
+		t.Error("DNS failures also logged as generic send errors")
+	}
+	if len(events) != attempts || events[attempts-1].Failures != attempts || events[0].Host != "ingest.invalid" {
+		t.Errorf("events = %+v, want %d with increasing Failures", events, attempts)
+	}
+	if len(batch) != 1 {
+		t.Errorf("batch has %d frames, want it kept for retry", len(batch))
+	}
+}
@@ -0,0 +1,67 @@
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+)
+
+// traceBodyLimit caps how much of a response body is written to the trace.
+const traceBodyLimit = 4 << 10
+
+// traceTransport writes every request line and headers, and the response
+// status, headers and the start of its body, to w. Authorization values
+// are masked. Request bodies are not dumped; for uploads they are the
+// compressed frames.
+type traceTransport struct {
+	next http.RoundTripper
+	w    io.Writer
+}
+
+func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "> %s %s\n", req.Method, req.URL)
+	writeTraceHeaders(&buf, "> ", req.Header)
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		fmt.Fprintf(&buf, "< error: %v\n\n", err)
+		_, _ = t.w.Write(buf.Bytes())
+		return nil, err
+	}
+	fmt.Fprintf(&buf, "< %s\n", resp.Status)
+	writeTraceHeaders(&buf, "< ", resp.Header)
+
+	// Read only a prefix and hand the caller the body unchanged
+	prefix, _ := io.ReadAll(io.LimitReader(resp.Body, traceBodyLimit))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(prefix), resp.Body), resp.Body}
+	buf.Write(prefix)
+	if len(prefix) == traceBodyLimit {
+		buf.WriteString("\n[truncated]")
+	}
+	buf.WriteString("\n\n")
+	// One Write per exchange keeps concurrent dumps from interleaving
+	_, _ = t.w.Write(buf.Bytes())
+	return resp, nil
+}
+
+func writeTraceHeaders(buf *bytes.Buffer, prefix string, h http.Header) {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range h[k] {
+			if k == "Authorization" {
+				v = "*****"
+			}
+			fmt.Fprintf(buf, "%s%s: %s\n", prefix, k, v)
+		}
+	}
+}
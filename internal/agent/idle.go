@@ -0,0 +1,43 @@
+package agent
+
+import "time"
+
+// IdleEvent reports that the agent is caught up: every poll since Since
+// has hit the end of the newest index without finding new frames.
+type IdleEvent struct {
+	Since time.Time
+}
+
+// idleTracker decides when a run of EOF polls has lasted long enough to
+// report. It fires once per elapsed threshold for as long as the agent
+// stays idle, so a long-idle chain produces a periodic heartbeat.
+type idleTracker struct {
+	threshold time.Duration
+	since     time.Time
+	fired     time.Time
+}
+
+func newIdleTracker(threshold time.Duration) *idleTracker {
+	return &idleTracker{threshold: threshold}
+}
+
+// Poll records an EOF poll at now and reports whether an IdleEvent is due.
+func (t *idleTracker) Poll(now time.Time) (IdleEvent, bool) {
+	if t.threshold <= 0 {
+		return IdleEvent{}, false
+	}
+	if t.since.IsZero() {
+		t.since, t.fired = now, now
+		return IdleEvent{}, false
+	}
+	if now.Sub(t.fired) < t.threshold {
+		return IdleEvent{}, false
+	}
+	t.fired = now
+	return IdleEvent{Since: t.since}, true
+}
+
+// Active resets the tracker after a frame was read.
+func (t *idleTracker) Active() {
+	t.since, t.fired = time.Time{}, time.Time{}
+}
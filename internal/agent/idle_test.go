@@ -0,0 +1,108 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIdleTracker(t *testing.T) {
+	tr := newIdleTracker(time.Minute)
+	t0 := time.Unix(1000, 0)
+
+	if _, ok := tr.Poll(t0); ok {
+		t.Fatal("first EOF poll should not fire")
+	}
+	if _, ok := tr.Poll(t0.Add(30 * time.Second)); ok {
+		t.Fatal("fired before threshold")
+	}
+	ev, ok := tr.Poll(t0.Add(time.Minute))
+	if !ok || !ev.Since.Equal(t0) {
+		t.Fatalf("Poll at threshold = %v, %v; want event since %v", ev, ok, t0)
+	}
+	if _, ok := tr.Poll(t0.Add(90 * time.Second)); ok {
+		t.Fatal("fired again before another threshold elapsed")
+	}
+	if ev, ok := tr.Poll(t0.Add(2 * time.Minute)); !ok || !ev.Since.Equal(t0) {
+		t.Fatalf("expected periodic event since %v, got %v, %v", t0, ev, ok)
+	}
+
+	tr.Active()
+	t1 := t0.Add(3 * time.Minute)
+	if _, ok := tr.Poll(t1); ok {
+		t.Fatal("fired immediately after activity")
+	}
+	if ev, ok := tr.Poll(t1.Add(time.Minute)); !ok || !ev.Since.Equal(t1) {
+		t.Fatalf("after activity: got %v, %v; want event since %v", ev, ok, t1)
+	}
+}
+
+func TestIdleTracker_Disabled(t *testing.T) {
+	tr := newIdleTracker(0)
+	now := time.Now()
+	tr.Poll(now)
+	if _, ok := tr.Poll(now.Add(time.Hour)); ok {
+		t.Fatal("zero threshold should never fire")
+	}
+}
+
+func TestRun_OnIdle(t *testing.T) {
+	tmpDir := t.TempDir()
+	walDir := filepath.Join(tmpDir, "wal")
+	if err := os.MkdirAll(walDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// An empty index: every poll hits EOF.
+	if err := os.WriteFile(filepath.Join(walDir, "seg-000001.wal.idx"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mu     sync.Mutex
+		events []IdleEvent
+	)
+	threshold := 50 * time.Millisecond
+	cfg := Config{
+		ServiceURL:    "http://localhost:9999",
+		WALDir:        walDir,
+		StateDir:      filepath.Join(tmpDir, "state"),
+		PollInterval:  5 * time.Millisecond,
+		SendInterval:  time.Second,
+		IdleThreshold: threshold,
+		OnIdle: func(ev IdleEvent) {
+			mu.Lock()
+			events = append(events, ev)
+			mu.Unlock()
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- Run(ctx, cfg) }()
+
+	time.Sleep(threshold / 2)
+	mu.Lock()
+	early := len(events)
+	mu.Unlock()
+	if early != 0 {
+		t.Fatalf("OnIdle fired %d times before threshold", early)
+	}
+
+	time.Sleep(3 * threshold)
+	cancel()
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) == 0 {
+		t.Fatal("OnIdle never fired")
+	}
+	since := events[0].Since
+	if since.Before(start) || since.Sub(start) > threshold {
+		t.Errorf("Since = %v, want shortly after start %v", since, start)
+	}
+}
@@ -2,6 +2,7 @@ package agent
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -23,19 +24,76 @@ func openIdx(idxPath string) (*os.File, *bufio.Reader, error) {
 // openGz opens the given gzip file path (not a gzip.Reader; we range-read compressed bytes).
 func openGz(path string) (*os.File, error) { return os.Open(path) }
 
+// ErrMalformedIndexLine is matched (via errors.Is) by the error returned
+// when an index line does not decode to a usable FrameMeta, e.g. because it
+// was written by an older memlogger with a different field set.
+var ErrMalformedIndexLine = errors.New("malformed index line")
+
+type malformedLineError struct {
+	Path   string
+	Line   string
+	Reason error
+}
+
+func (e *malformedLineError) Error() string {
+	return fmt.Sprintf("%s: %v %q: %v", e.Path, ErrMalformedIndexLine, e.Line, e.Reason)
+}
+
+func (e *malformedLineError) Is(target error) bool { return target == ErrMalformedIndexLine }
+
+func (e *malformedLineError) Unwrap() error { return e.Reason }
+
 // nextFrame reads next complete JSON line and returns FrameMeta and raw line bytes.
-func nextFrame(r *bufio.Reader) (FrameMeta, []byte, error) {
+// A trailing line without a newline is still being written: it is returned
+// with io.EOF so the caller can rewind and re-read it once complete.
+// Unless lenient, lines without a file and length are rejected with
+// ErrMalformedIndexLine rather than yielding zero offsets, and so are lines
+// with unknown fields when strictFields is set.
+// CRLF line endings are accepted; the raw line keeps them, so offsets
+// advance by the bytes actually on disk.
+func nextFrame(r *bufio.Reader, path string, lenient, strictFields bool) (FrameMeta, []byte, error) {
 	line, err := r.ReadBytes('\n')
 	if err != nil {
-		return FrameMeta{}, nil, err
+		return FrameMeta{}, line, err
 	}
+	text := bytes.TrimSuffix(bytes.TrimSuffix(line, []byte{'\n'}), []byte{'\r'})
 	var fm FrameMeta
-	if err := json.Unmarshal(line, &fm); err != nil {
-		return FrameMeta{}, line, fmt.Errorf("bad index line: %w", err)
+	if lenient {
+		if err := json.Unmarshal(text, &fm); err != nil {
+			return FrameMeta{}, line, fmt.Errorf("bad index line: %w", err)
+		}
+		return fm, line, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(text))
+	if strictFields {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(&fm); err != nil {
+		return FrameMeta{}, line, &malformedLineError{Path: path, Line: string(bytes.TrimSpace(line)), Reason: err}
+	}
+	if fm.File == "" || fm.Len == 0 {
+		return FrameMeta{}, line, &malformedLineError{Path: path, Line: string(bytes.TrimSpace(line)), Reason: errors.New("missing file or zero len")}
 	}
 	return fm, line, nil
 }
 
+// skipFrame reports whether Run passes over fm without shipping it: an
+// empty frame under SkipEmptyFrames, one FrameFilter rejects, or one larger
+// than MaxFrameBytes. Peek, ShipRange and VerifyWAL skip the same frames.
+func skipFrame(cfg Config, fm FrameMeta) bool {
+	if cfg.SkipEmptyFrames && fm.Recs == 0 {
+		return true
+	}
+	if cfg.FrameFilter != nil && !cfg.FrameFilter(fm) {
+		return true
+	}
+	return frameTooLarge(cfg, fm)
+}
+
+func frameTooLarge(cfg Config, fm FrameMeta) bool {
+	return cfg.MaxFrameBytes > 0 && fm.Len > uint64(cfg.MaxFrameBytes)
+}
+
 // preadSection reads [off, off+len) bytes from file.
 func preadSection(f *os.File, off int64, length int64) ([]byte, error) {
 	if f == nil {
@@ -103,9 +161,10 @@ func latestIndex(dir string) (string, error) {
 }
 
 // oldestIndex mirrors latestIndex but picks the earliest day and the
-// lexicographically smallest index within that day. Falls back to dir
+// lexicographically smallest index within that day. Day directories before
+// minDay (YYYY-MM-DD; empty for none) are ignored. Falls back to dir
 // directly if no day dirs are present.
-func oldestIndex(dir string) (string, error) {
+func oldestIndex(dir, minDay string) (string, error) {
 	ents, err := os.ReadDir(dir)
 	if err != nil {
 		return "", fmt.Errorf("%w\n\nPlease verify:\n  - The --wal-dir flag points to the correct directory\n  - The directory exists\n  - You have permission to read the directory", err)
@@ -120,12 +179,15 @@ func oldestIndex(dir string) (string, error) {
 		name := e.Name()
 		if len(name) == len("2006-01-02") && strings.Count(name, "-") == 2 {
 			hasDay = true
-			if name < earliestDay {
+			if name >= minDay && name < earliestDay {
 				earliestDay = name
 			}
 		}
 	}
 	if hasDay {
+		if earliestDay == "~" {
+			return "", fmt.Errorf("no day directories on or after %s in %s", minDay, dir)
+		}
 		dayDir := filepath.Join(dir, earliestDay)
 		dayEnts, err := os.ReadDir(dayDir)
 		if err != nil {
@@ -159,10 +221,12 @@ func oldestIndex(dir string) (string, error) {
 }
 
 // nextIndexAfter returns the next index path after the given current index.
-// It looks for the next segment within the same day; if not present, advances
-// to the next day directory and selects the first segment there. If nothing
-// newer exists yet, returns ("", false, nil).
-func nextIndexAfter(curIdxPath string) (string, bool, error) {
+// It looks for the lowest-numbered segment after the current one within the
+// same day, so a missing segment number does not stall the reader; if there
+// is none, it advances to the next day directory and selects its
+// lowest-numbered segment, skipping days before minDay (YYYY-MM-DD; empty
+// for none). If nothing newer exists yet, returns ("", false, nil).
+func nextIndexAfter(curIdxPath, minDay string) (string, bool, error) {
 	dayDir := filepath.Dir(curIdxPath)
 	base := filepath.Base(curIdxPath)
 	// Extract current segment number
@@ -171,9 +235,8 @@ func nextIndexAfter(curIdxPath string) (string, bool, error) {
 		return "", false, fmt.Errorf("unrecognized index name: %s", base)
 	}
 	// Candidate in same day
-	cand := filepath.Join(dayDir, fmt.Sprintf("seg-%06d.wal.idx", cur+1))
-	if _, err := os.Stat(cand); err == nil {
-		return cand, true, nil
+	if next, ok, err := lowestSegmentAfter(dayDir, cur); err != nil || ok {
+		return next, ok, err
 	}
 	// Advance to next day directory
 	parent := filepath.Dir(dayDir)
@@ -189,7 +252,7 @@ func nextIndexAfter(curIdxPath string) (string, bool, error) {
 		}
 		name := e.Name()
 		if len(name) == len("2006-01-02") && strings.Count(name, "-") == 2 {
-			if name > curDay && (nextDay == "" || name < nextDay) {
+			if name > curDay && name >= minDay && (nextDay == "" || name < nextDay) {
 				nextDay = name
 			}
 		}
@@ -197,11 +260,40 @@ func nextIndexAfter(curIdxPath string) (string, bool, error) {
 	if nextDay == "" {
 		return "", false, nil
 	}
-	nd := filepath.Join(parent, nextDay)
-	first := filepath.Join(nd, "seg-000001.wal.idx")
-	if _, err := os.Stat(first); err == nil {
-		return first, true, nil
+	// No segment yet in the new day reports ("", false, nil)
+	return lowestSegmentAfter(filepath.Join(parent, nextDay), 0)
+}
+
+// lowestSegmentAfter returns the seg-NNNNNN.wal.idx in dayDir with the
+// smallest number greater than after. The common case, after+1, is probed
+// directly before falling back to a directory scan.
+func lowestSegmentAfter(dayDir string, after int) (string, bool, error) {
+	cand := filepath.Join(dayDir, fmt.Sprintf("seg-%06d.wal.idx", after+1))
+	if _, err := os.Stat(cand); err == nil {
+		return cand, true, nil
+	}
+	ents, err := os.ReadDir(dayDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	best := 0
+	for _, e := range ents {
+		var n int
+		if _, err := fmt.Sscanf(e.Name(), "seg-%06d.wal.idx", &n); err != nil {
+			continue
+		}
+		if e.Name() != fmt.Sprintf("seg-%06d.wal.idx", n) {
+			continue
+		}
+		if n > after && (best == 0 || n < best) {
+			best = n
+		}
+	}
+	if best == 0 {
+		return "", false, nil
 	}
-	// No first segment yet in the new day
-	return "", false, nil
+	return filepath.Join(dayDir, fmt.Sprintf("seg-%06d.wal.idx", best)), true, nil
 }
@@ -0,0 +1,337 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNextFrame_MalformedLine(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+	}{
+		{"legacy field set", `{"segment":"seg-000001.wal.gz","offset":0,"length":42}` + "\n"},
+		{"zero len", `{"file":"seg-000001.wal.gz","frame":1,"off":0,"len":0}` + "\n"},
+		{"not json", "garbage\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bufio.NewReader(strings.NewReader(tt.line))
+			_, _, err := nextFrame(r, "/wal/seg-000001.wal.idx", false, false)
+			if !errors.Is(err, ErrMalformedIndexLine) {
+				t.Fatalf("nextFrame() error = %v, want ErrMalformedIndexLine", err)
+			}
+			var me *malformedLineError
+			if !errors.As(err, &me) || me.Path != "/wal/seg-000001.wal.idx" || me.Line != strings.TrimSpace(tt.line) {
+				t.Errorf("error %v should carry the path and the offending line", err)
+			}
+		})
+	}
+}
+
+func TestNextFrame_UnknownFields(t *testing.T) {
+	line := `{"file":"seg-000001.wal.gz","frame":1,"off":0,"len":10,"added_later":1}` + "\n"
+
+	// Accepted by default so newer nodes keep shipping
+	fm, _, err := nextFrame(bufio.NewReader(strings.NewReader(line)), "x.idx", false, false)
+	if err != nil {
+		t.Fatalf("nextFrame() error = %v", err)
+	}
+	if fm.File != "seg-000001.wal.gz" || fm.Len != 10 {
+		t.Errorf("got %+v, want parsed frame", fm)
+	}
+
+	_, _, err = nextFrame(bufio.NewReader(strings.NewReader(line)), "x.idx", false, true)
+	if !errors.Is(err, ErrMalformedIndexLine) {
+		t.Errorf("strict nextFrame() error = %v, want ErrMalformedIndexLine", err)
+	}
+}
+
+func TestNextFrame_Lenient(t *testing.T) {
+	line := `{"file":"seg-000001.wal.gz","frame":1,"off":0,"len":0,"extra":true}` + "\n"
+	fm, raw, err := nextFrame(bufio.NewReader(strings.NewReader(line)), "x.idx", true, false)
+	if err != nil {
+		t.Fatalf("lenient nextFrame() error = %v", err)
+	}
+	if fm.File != "seg-000001.wal.gz" || len(raw) != len(line) {
+		t.Errorf("got %+v (%d bytes), want parsed frame", fm, len(raw))
+	}
+}
+
+func TestNextFrame_CRLF(t *testing.T) {
+	lines := `{"file":"seg-000001.wal.gz","frame":1,"off":0,"len":10}` + "\r\n" +
+		`{"file":"seg-000001.wal.gz","frame":2,"off":10,"len":12}` + "\r\n"
+	for _, lenient := range []bool{false, true} {
+		r := bufio.NewReader(strings.NewReader(lines))
+		var total int
+		for want := uint64(1); want <= 2; want++ {
+			fm, raw, err := nextFrame(r, "x.idx", lenient, false)
+			if err != nil {
+				t.Fatalf("lenient=%v: nextFrame() error = %v", lenient, err)
+			}
+			if fm.Frame != want || fm.File != "seg-000001.wal.gz" {
+				t.Errorf("lenient=%v: got %+v, want frame %d", lenient, fm, want)
+			}
+			total += len(raw)
+		}
+		// Offsets must cover the \r too
+		if total != len(lines) {
+			t.Errorf("lenient=%v: raw lines total %d bytes, want %d", lenient, total, len(lines))
+		}
+	}
+}
+
+func TestRun_CRLFIndex(t *testing.T) {
+	rec := &frameRecorder{}
+	ts := httptest.NewServer(rec)
+	defer ts.Close()
+
+	tmpDir := t.TempDir()
+	walDir := filepath.Join(tmpDir, "wal")
+	writeTestSegment(t, walDir, 1, "a1\n", "a2\n", "a3\n")
+	idxPath := filepath.Join(walDir, "seg-000001.wal.idx")
+	idx, err := os.ReadFile(idxPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crlf := bytes.ReplaceAll(idx, []byte("\n"), []byte("\r\n"))
+	if err := os.WriteFile(idxPath, crlf, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := Config{
+		ServiceURL:   ts.URL,
+		WALDir:       walDir,
+		StateDir:     filepath.Join(tmpDir, "state"),
+		PollInterval: time.Millisecond,
+		SendInterval: time.Millisecond,
+		HardInterval: time.Millisecond,
+		Once:         true,
+	}
+	if err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if n := rec.count(); n != 3 {
+		t.Errorf("shipped %d frames, want 3", n)
+	}
+	st, err := loadState(cfg.StateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.IdxOffset != int64(len(crlf)) {
+		t.Errorf("IdxOffset = %d, want %d", st.IdxOffset, len(crlf))
+	}
+}
+
+func TestRun_MalformedIndexLine(t *testing.T) {
+	tmpDir := t.TempDir()
+	walDir := filepath.Join(tmpDir, "wal")
+	if err := os.MkdirAll(walDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	idx := `{"segment":"seg-000001.wal.gz","offset":10,"length":20}` + "\n"
+	if err := os.WriteFile(filepath.Join(walDir, "seg-000001.wal.idx"), []byte(idx), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := Config{
+		ServiceURL:   "http://localhost:9999",
+		WALDir:       walDir,
+		StateDir:     filepath.Join(tmpDir, "state"),
+		PollInterval: time.Millisecond,
+		SendInterval: time.Second,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := Run(ctx, cfg); !errors.Is(err, ErrMalformedIndexLine) {
+		t.Fatalf("Run() error = %v, want ErrMalformedIndexLine", err)
+	}
+}
+
+func TestRun_PartialIndexLine(t *testing.T) {
+	rec := &frameRecorder{}
+	ts := httptest.NewServer(rec)
+	defer ts.Close()
+
+	tmpDir := t.TempDir()
+	walDir := filepath.Join(tmpDir, "wal")
+	writeTestSegment(t, walDir, 1, "f1\n", "f2\n")
+	idxPath := filepath.Join(walDir, "seg-000001.wal.idx")
+	full, err := os.ReadFile(idxPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The memlogger is mid-way through the second line.
+	cut := bytes.IndexByte(full, '\n') + 1 + 10
+	if err := os.WriteFile(idxPath, full[:cut], 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := Config{
+		ServiceURL:   ts.URL,
+		WALDir:       walDir,
+		StateDir:     filepath.Join(tmpDir, "state"),
+		PollInterval: time.Millisecond,
+		SendInterval: time.Millisecond,
+		HardInterval: time.Millisecond,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Run(ctx, cfg) }()
+
+	waitFor := func(n int) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for rec.count() < n && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	waitFor(1)
+	time.Sleep(20 * time.Millisecond) // several polls over the partial line
+
+	f, err := os.OpenFile(idxPath, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(full[cut:]); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	waitFor(2)
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run() error = %v, want context.Canceled", err)
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.frames) != 2 || rec.frames[0].Frame != 1 || rec.frames[1].Frame != 2 {
+		t.Fatalf("shipped %+v, want frames 1 and 2 once each", rec.frames)
+	}
+	st, err := loadState(cfg.StateDir)
+	if err != nil {
+		t.Fatalf("load state: %v", err)
+	}
+	if st.IdxOffset != int64(len(full)) {
+		t.Errorf("IdxOffset = %d, want %d", st.IdxOffset, len(full))
+	}
+}
+
+func TestNextIndexAfter_Gaps(t *testing.T) {
+	walDir := t.TempDir()
+	day1 := filepath.Join(walDir, "2026-01-01")
+	day2 := filepath.Join(walDir, "2026-01-02")
+	for _, seg := range []int{1, 2, 5, 12} {
+		writeTestSegment(t, day1, seg, "x\n")
+	}
+	writeTestSegment(t, day2, 3, "y\n") // day starts past seg 1
+	// Not segment indexes
+	for _, name := range []string{"seg-000003.wal.idx.tmp", "seg-x.wal.idx"} {
+		if err := os.WriteFile(filepath.Join(day1, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		cur, want string
+	}{
+		{filepath.Join(day1, "seg-000001.wal.idx"), filepath.Join(day1, "seg-000002.wal.idx")},
+		{filepath.Join(day1, "seg-000002.wal.idx"), filepath.Join(day1, "seg-000005.wal.idx")},
+		{filepath.Join(day1, "seg-000005.wal.idx"), filepath.Join(day1, "seg-000012.wal.idx")},
+		{filepath.Join(day1, "seg-000012.wal.idx"), filepath.Join(day2, "seg-000003.wal.idx")},
+		{filepath.Join(day2, "seg-000003.wal.idx"), ""},
+	}
+	for _, tt := range tests {
+		got, ok, err := nextIndexAfter(tt.cur, "")
+		if err != nil {
+			t.Fatalf("nextIndexAfter(%s) error = %v", tt.cur, err)
+		}
+		if got != tt.want || ok != (tt.want != "") {
+			t.Errorf("nextIndexAfter(%s) = %q, %v; want %q", filepath.Base(tt.cur), got, ok, tt.want)
+		}
+	}
+}
+
+func TestRun_SkipsSegmentGap(t *testing.T) {
+	rec := &frameRecorder{}
+	ts := httptest.NewServer(rec)
+	defer ts.Close()
+
+	walDir := filepath.Join(t.TempDir(), "wal")
+	day := filepath.Join(walDir, "2026-01-01")
+	writeTestSegment(t, day, 1, "f1\n")
+	writeTestSegment(t, day, 3, "f3\n") // seg 2 was never flushed
+
+	cfg := Config{
+		ServiceURL:   ts.URL,
+		WALDir:       walDir,
+		StateDir:     filepath.Join(t.TempDir(), "state"),
+		PollInterval: time.Millisecond,
+		SendInterval: time.Millisecond,
+		HardInterval: time.Millisecond,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Run(ctx, cfg) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for rec.count() < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.frames) != 2 || rec.frames[1].File != "seg-000003.wal.gz" {
+		t.Errorf("shipped %+v, want seg 1 then seg 3", rec.frames)
+	}
+}
+
+func TestMinDay_SkipsStaleDays(t *testing.T) {
+	walDir := t.TempDir()
+	// Left behind by external pruning
+	for _, day := range []string{"2025-12-30", "2025-12-31"} {
+		if err := os.MkdirAll(filepath.Join(walDir, day), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTestSegment(t, filepath.Join(walDir, "2026-01-01"), 1, "a\n")
+	writeTestSegment(t, filepath.Join(walDir, "2026-01-03"), 1, "b\n")
+
+	if _, err := oldestIndex(walDir, ""); err == nil {
+		t.Fatal("oldestIndex without MinDay should trip over the empty day")
+	}
+	got, err := oldestIndex(walDir, "2026-01-01")
+	if err != nil {
+		t.Fatalf("oldestIndex() error = %v", err)
+	}
+	if want := filepath.Join(walDir, "2026-01-01", "seg-000001.wal.idx"); got != want {
+		t.Errorf("oldestIndex() = %s, want %s", got, want)
+	}
+
+	// Advancing from a day before MinDay jumps to MinDay or later
+	cur := filepath.Join(walDir, "2025-12-30", "seg-000001.wal.idx")
+	got, ok, err := nextIndexAfter(cur, "2026-01-02")
+	if err != nil || !ok {
+		t.Fatalf("nextIndexAfter() = %q, %v, %v", got, ok, err)
+	}
+	if want := filepath.Join(walDir, "2026-01-03", "seg-000001.wal.idx"); got != want {
+		t.Errorf("nextIndexAfter() = %s, want %s", got, want)
+	}
+
+	if _, err := oldestIndex(walDir, "2026-02-01"); err == nil {
+		t.Error("oldestIndex with every day before MinDay should fail")
+	}
+}
@@ -0,0 +1,139 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// newestIndexTTL is how long a LagMonitor reuses its newest-index lookup
+// before scanning the last WAL directory again.
+const newestIndexTTL = 2 * time.Second
+
+// Lag is how far the committed read position trails the newest WAL index.
+type Lag struct {
+	// Bytes of index not yet committed: the rest of the current index plus
+	// every newer index in full.
+	Bytes int64
+	// Segments is the number of index segments newer than the current one.
+	Segments int
+}
+
+// LagMonitor computes Lag from the state persisted in StateDir. It is safe
+// to call from another goroutine while Run is active; the result trails the
+// agent by at most Config.StateSaveInterval.
+type LagMonitor struct {
+	walDirs  []string
+	stateDir string
+	ttl      time.Duration
+	clock    clock
+
+	mu        sync.Mutex
+	newest    string
+	newestDir int // position of newest's directory in walDirs
+	scannedAt time.Time
+}
+
+// NewLagMonitor returns a LagMonitor for cfg's WAL directories (WALDir
+// followed by ExtraWALDirs) and StateDir.
+func NewLagMonitor(cfg Config) *LagMonitor {
+	return &LagMonitor{walDirs: cfg.walDirs(), stateDir: cfg.StateDir, ttl: newestIndexTTL, clock: realClock{}}
+}
+
+// Lag returns the current lag behind the newest index.
+func (m *LagMonitor) Lag() (Lag, error) {
+	st, err := loadState(m.stateDir)
+	if err != nil {
+		return Lag{}, fmt.Errorf("load state: %w", err)
+	}
+	if st.IdxPath == "" {
+		return Lag{}, fmt.Errorf("no read position recorded in %s", stateFile(m.stateDir))
+	}
+	newest, last, err := m.newestIndex()
+	if err != nil {
+		return Lag{}, err
+	}
+	if st.DirIndex >= last {
+		return indexLag(st.IdxPath, st.IdxOffset, newest)
+	}
+	// Still in an earlier directory: the rest of it counts, then every
+	// later directory in full
+	lag, err := indexLag(st.IdxPath, st.IdxOffset, "")
+	if err != nil {
+		return Lag{}, err
+	}
+	for i := st.DirIndex + 1; i <= last; i++ {
+		first, dirIdx, err := firstIndexFrom(m.walDirs, i, "")
+		if err != nil {
+			break
+		}
+		end := ""
+		if dirIdx == last {
+			end = newest
+		}
+		more, err := indexLag(first, 0, end)
+		if err != nil {
+			return Lag{}, err
+		}
+		lag.Bytes += more.Bytes
+		lag.Segments += more.Segments + 1
+		i = dirIdx
+	}
+	return lag, nil
+}
+
+// newestIndex returns the newest index in the last WAL directory that has
+// one, and that directory's position in walDirs.
+func (m *LagMonitor) newestIndex() (string, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.newest != "" && m.clock.Now().Sub(m.scannedAt) < m.ttl {
+		return m.newest, m.newestDir, nil
+	}
+	var firstErr error
+	for i := len(m.walDirs) - 1; i >= 0; i-- {
+		newest, err := latestIndex(m.walDirs[i])
+		if err != nil {
+			// A later directory may not have been written to yet
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		m.newest, m.newestDir, m.scannedAt = newest, i, m.clock.Now()
+		return newest, i, nil
+	}
+	return "", 0, firstErr
+}
+
+// indexLag walks the segments from cur to newest, or to the last one in
+// cur's WAL directory when newest is "", summing index bytes not yet
+// consumed past off.
+func indexLag(cur string, off int64, newest string) (Lag, error) {
+	fi, err := os.Stat(cur)
+	if err != nil {
+		return Lag{}, err
+	}
+	lag := Lag{Bytes: fi.Size() - off}
+	if lag.Bytes < 0 {
+		lag.Bytes = 0
+	}
+	for p := cur; p != newest; {
+		next, ok, err := nextIndexAfter(p, "")
+		if err != nil {
+			return Lag{}, err
+		}
+		if !ok {
+			break
+		}
+		fi, err := os.Stat(next)
+		if err != nil {
+			return Lag{}, err
+		}
+		lag.Bytes += fi.Size()
+		lag.Segments++
+		p = next
+	}
+	return lag, nil
+}
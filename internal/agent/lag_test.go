@@ -0,0 +1,165 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLagMonitor_BehindAcrossSegmentsAndDays(t *testing.T) {
+	walDir := t.TempDir()
+	day1 := filepath.Join(walDir, "2026-01-01")
+	day2 := filepath.Join(walDir, "2026-01-02")
+	writeTestSegment(t, day1, 1, "a\n", "b\n")
+	writeTestSegment(t, day1, 2, "c\n")
+	writeTestSegment(t, day2, 1, "d\n", "e\n")
+
+	size := func(p string) int64 {
+		fi, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fi.Size()
+	}
+	seg1 := filepath.Join(day1, "seg-000001.wal.idx")
+	seg2 := filepath.Join(day1, "seg-000002.wal.idx")
+	seg3 := filepath.Join(day2, "seg-000001.wal.idx")
+
+	// The reader has consumed the first line of the first segment only.
+	stateDir := t.TempDir()
+	off := size(seg1) / 2
+	if err := saveState(stateDir, state{IdxPath: seg1, IdxOffset: off}); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewLagMonitor(Config{WALDir: walDir, StateDir: stateDir})
+	lag, err := m.Lag()
+	if err != nil {
+		t.Fatalf("Lag: %v", err)
+	}
+	want := Lag{Bytes: size(seg1) - off + size(seg2) + size(seg3), Segments: 2}
+	if lag != want {
+		t.Fatalf("Lag = %+v, want %+v", lag, want)
+	}
+
+	// Caught up on the newest segment.
+	if err := saveState(stateDir, state{IdxPath: seg3, IdxOffset: size(seg3)}); err != nil {
+		t.Fatal(err)
+	}
+	lag, err = m.Lag()
+	if err != nil {
+		t.Fatalf("Lag: %v", err)
+	}
+	if lag != (Lag{}) {
+		t.Fatalf("Lag when caught up = %+v, want zero", lag)
+	}
+}
+
+func TestLagMonitor_ExtraWALDirs(t *testing.T) {
+	dirA, dirB := t.TempDir(), t.TempDir()
+	writeTestSegment(t, dirA, 1, "a\n", "b\n")
+	writeTestSegment(t, dirA, 2, "c\n")
+	writeTestSegment(t, dirB, 1, "d\n", "e\n")
+	writeTestSegment(t, dirB, 2, "f\n")
+
+	size := func(p string) int64 {
+		fi, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fi.Size()
+	}
+	a1 := filepath.Join(dirA, "seg-000001.wal.idx")
+	a2 := filepath.Join(dirA, "seg-000002.wal.idx")
+	b1 := filepath.Join(dirB, "seg-000001.wal.idx")
+	b2 := filepath.Join(dirB, "seg-000002.wal.idx")
+
+	stateDir := t.TempDir()
+	m := NewLagMonitor(Config{WALDir: dirA, ExtraWALDirs: []string{dirB}, StateDir: stateDir})
+	m.ttl = 0
+	tests := []struct {
+		name string
+		st   state
+		want Lag
+	}{
+		{"first dir", state{IdxPath: a1, IdxOffset: size(a1) / 2}, Lag{Bytes: size(a1) - size(a1)/2 + size(a2) + size(b1) + size(b2), Segments: 3}},
+		{"end of first dir", state{IdxPath: a2, IdxOffset: size(a2)}, Lag{Bytes: size(b1) + size(b2), Segments: 2}},
+		{"last dir", state{IdxPath: b1, IdxOffset: size(b1) / 2, DirIndex: 1}, Lag{Bytes: size(b1) - size(b1)/2 + size(b2), Segments: 1}},
+		{"caught up", state{IdxPath: b2, IdxOffset: size(b2), DirIndex: 1}, Lag{}},
+	}
+	for _, tt := range tests {
+		if err := saveState(stateDir, tt.st); err != nil {
+			t.Fatal(err)
+		}
+		lag, err := m.Lag()
+		if err != nil {
+			t.Fatalf("%s: Lag: %v", tt.name, err)
+		}
+		if lag != tt.want {
+			t.Errorf("%s: Lag = %+v, want %+v", tt.name, lag, tt.want)
+		}
+	}
+}
+
+func TestLagMonitor_LastDirEmpty(t *testing.T) {
+	dirA, dirB := t.TempDir(), t.TempDir()
+	writeTestSegment(t, dirA, 1, "a\n")
+	writeTestSegment(t, dirA, 2, "b\n")
+	seg1 := filepath.Join(dirA, "seg-000001.wal.idx")
+	var total int64
+	for _, p := range []string{seg1, filepath.Join(dirA, "seg-000002.wal.idx")} {
+		fi, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += fi.Size()
+	}
+
+	// dirB has not been written to yet; the newest index is still in dirA
+	stateDir := t.TempDir()
+	if err := saveState(stateDir, state{IdxPath: seg1, IdxOffset: 0}); err != nil {
+		t.Fatal(err)
+	}
+	m := NewLagMonitor(Config{WALDir: dirA, ExtraWALDirs: []string{dirB}, StateDir: stateDir})
+	lag, err := m.Lag()
+	if err != nil {
+		t.Fatalf("Lag: %v", err)
+	}
+	if want := (Lag{Bytes: total, Segments: 1}); lag != want {
+		t.Errorf("Lag = %+v, want %+v", lag, want)
+	}
+}
+
+func TestLagMonitor_CachesNewestIndex(t *testing.T) {
+	walDir := t.TempDir()
+	writeTestSegment(t, walDir, 1, "a\n")
+	seg1 := filepath.Join(walDir, "seg-000001.wal.idx")
+	stateDir := t.TempDir()
+	if err := saveState(stateDir, state{IdxPath: seg1}); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewLagMonitor(Config{WALDir: walDir, StateDir: stateDir})
+	m.ttl = time.Hour
+	if lag, err := m.Lag(); err != nil || lag.Segments != 0 {
+		t.Fatalf("Lag = %+v, %v; want 0 segments", lag, err)
+	}
+
+	// A new segment is not seen until the cached lookup expires.
+	writeTestSegment(t, walDir, 2, "b\n")
+	if lag, err := m.Lag(); err != nil || lag.Segments != 0 {
+		t.Fatalf("Lag with cached newest = %+v, %v; want 0 segments", lag, err)
+	}
+	m.ttl = 0
+	if lag, err := m.Lag(); err != nil || lag.Segments != 1 {
+		t.Fatalf("Lag after rescan = %+v, %v; want 1 segment", lag, err)
+	}
+}
+
+func TestLagMonitor_NoState(t *testing.T) {
+	m := NewLagMonitor(Config{WALDir: t.TempDir(), StateDir: t.TempDir()})
+	if _, err := m.Lag(); err == nil {
+		t.Fatal("expected error without persisted state")
+	}
+}
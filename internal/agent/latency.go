@@ -0,0 +1,84 @@
+package agent
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultLatencyWindow is how many sends a LatencyRecorder keeps when
+// created with a window of zero.
+const DefaultLatencyWindow = 1024
+
+// SendLatency summarizes the duration of recent successful sends.
+type SendLatency struct {
+	Count int // sends in the window
+	P50   time.Duration
+	P95   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+}
+
+// LatencyRecorder keeps the durations of the last N successful sends in a
+// fixed-size ring. Set one on Config.SendLatency and poll Latency from
+// another goroutine while Run is active, e.g. for SLO tracking.
+type LatencyRecorder struct {
+	mu   sync.Mutex
+	ring []time.Duration
+	next int
+	full bool
+}
+
+// NewLatencyRecorder returns a recorder over the last window sends, or
+// DefaultLatencyWindow if window is not positive.
+func NewLatencyRecorder(window int) *LatencyRecorder {
+	if window <= 0 {
+		window = DefaultLatencyWindow
+	}
+	return &LatencyRecorder{ring: make([]time.Duration, window)}
+}
+
+// Record adds one send duration, evicting the oldest once the window is full.
+func (r *LatencyRecorder) Record(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ring[r.next] = d
+	r.next++
+	if r.next == len(r.ring) {
+		r.next, r.full = 0, true
+	}
+}
+
+// Latency returns percentiles over the sends in the window; all zero if
+// nothing was recorded yet.
+func (r *LatencyRecorder) Latency() SendLatency {
+	r.mu.Lock()
+	n := r.next
+	if r.full {
+		n = len(r.ring)
+	}
+	sorted := make([]time.Duration, n)
+	copy(sorted, r.ring[:n])
+	r.mu.Unlock()
+
+	if n == 0 {
+		return SendLatency{}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return SendLatency{
+		Count: n,
+		P50:   percentile(sorted, 50),
+		P95:   percentile(sorted, 95),
+		P99:   percentile(sorted, 99),
+		Max:   sorted[n-1],
+	}
+}
+
+// percentile returns the nearest-rank p-th percentile of sorted.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
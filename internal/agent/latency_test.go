@@ -0,0 +1,67 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLatencyRecorder_Percentiles(t *testing.T) {
+	r := NewLatencyRecorder(100)
+	if got := r.Latency(); got != (SendLatency{}) {
+		t.Errorf("empty Latency() = %+v, want zero", got)
+	}
+	// 1ms..100ms in a scrambled order
+	for i := 0; i < 100; i++ {
+		r.Record(time.Duration((i*37)%100+1) * time.Millisecond)
+	}
+	want := SendLatency{Count: 100, P50: 50 * time.Millisecond, P95: 95 * time.Millisecond, P99: 99 * time.Millisecond, Max: 100 * time.Millisecond}
+	if got := r.Latency(); got != want {
+		t.Errorf("Latency() = %+v, want %+v", got, want)
+	}
+}
+
+func TestLatencyRecorder_WindowEvictsOldest(t *testing.T) {
+	r := NewLatencyRecorder(4)
+	for _, ms := range []int{500, 400, 1, 2, 3, 4} {
+		r.Record(time.Duration(ms) * time.Millisecond)
+	}
+	got := r.Latency()
+	if got.Count != 4 || got.Max != 4*time.Millisecond || got.P50 != 2*time.Millisecond {
+		t.Errorf("Latency() = %+v, want the last 4 sends only", got)
+	}
+	if d := NewLatencyRecorder(0); len(d.ring) != DefaultLatencyWindow {
+		t.Errorf("window 0 kept %d sends, want %d", len(d.ring), DefaultLatencyWindow)
+	}
+}
+
+func TestTrySend_RecordsLatency(t *testing.T) {
+	fail := true
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer ts.Close()
+
+	rec := NewLatencyRecorder(8)
+	cfg := Config{ServiceURL: ts.URL, SendLatency: rec}
+	batch := []batchFrame{{Meta: FrameMeta{File: "seg-000001.wal.gz", Frame: 1}, Compressed: []byte("data"), IdxLineLen: 10}}
+	batchBytes := 4
+	st := state{}
+	var ss sendState
+	back := newBackoff(time.Millisecond, time.Millisecond)
+	_ = trySend(context.Background(), cfg, http.DefaultClient, &batch, &batchBytes, &st, &ss, "seg-000001.wal.idx", nil, true, back)
+	fail = false
+	if err := trySend(context.Background(), cfg, http.DefaultClient, &batch, &batchBytes, &st, &ss, "seg-000001.wal.idx", nil, true, back); err != nil {
+		t.Fatalf("trySend() error = %v", err)
+	}
+	// Only the successful send is recorded
+	if got := rec.Latency(); got.Count != 1 || got.Max < 20*time.Millisecond {
+		t.Errorf("Latency() = %+v, want one send of at least 20ms", got)
+	}
+}
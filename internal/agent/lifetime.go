@@ -0,0 +1,36 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// RestartEvent reports that Run restarted itself after Config.MaxLifetime.
+// Restarts counts the restarts so far, this one included.
+type RestartEvent struct {
+	Uptime   time.Duration
+	Restarts int
+}
+
+// runWithLifetime runs the agent in rounds of at most cfg.MaxLifetime.
+// Each round ends like a cancelled Run, flushing the pending batch and
+// saving state, and the next round resumes from that state with fresh
+// watchers and connections.
+func runWithLifetime(ctx context.Context, cfg Config) error {
+	for restarts := 1; ; restarts++ {
+		start := time.Now()
+		lctx, cancel := context.WithTimeout(ctx, cfg.MaxLifetime)
+		err := run(lctx, cfg, false, realClock{})
+		expired := errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil
+		cancel()
+		if !expired {
+			return err
+		}
+		uptime := time.Since(start)
+		logger.Info().Dur("uptime", uptime).Int("restarts", restarts).Msg("max lifetime reached; restarting")
+		if cfg.OnRestart != nil {
+			cfg.OnRestart(RestartEvent{Uptime: uptime, Restarts: restarts})
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRun_MaxLifetimeRestartsAndKeepsState(t *testing.T) {
+	rec := &frameRecorder{}
+	ts := httptest.NewServer(rec)
+	defer ts.Close()
+
+	tmpDir := t.TempDir()
+	walDir := filepath.Join(tmpDir, "wal")
+	writeTestSegment(t, walDir, 1, "a1\n", "a2\n")
+
+	var (
+		mu     sync.Mutex
+		events []RestartEvent
+	)
+	restarted := make(chan struct{}, 16)
+	cfg := Config{
+		ServiceURL:   ts.URL,
+		WALDir:       walDir,
+		StateDir:     filepath.Join(tmpDir, "state"),
+		PollInterval: 5 * time.Millisecond,
+		SendInterval: time.Millisecond,
+		HardInterval: time.Millisecond,
+		FlushTimeout: time.Second,
+		MaxLifetime:  50 * time.Millisecond,
+		OnRestart: func(ev RestartEvent) {
+			mu.Lock()
+			events = append(events, ev)
+			mu.Unlock()
+			restarted <- struct{}{}
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Run(ctx, cfg) }()
+
+	// New data after a restart is picked up from the saved position
+	select {
+	case <-restarted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no restart after MaxLifetime")
+	}
+	writeTestSegment(t, walDir, 2, "b1\n")
+	<-restarted
+	deadline := time.Now().Add(5 * time.Second)
+	for rec.count() < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Run() error = %v, want context.Canceled", err)
+	}
+
+	// Each frame shipped exactly once across restarts
+	rec.mu.Lock()
+	got := rec.frames
+	rec.mu.Unlock()
+	if len(got) != 3 {
+		t.Fatalf("shipped %d frames, want 3: %+v", len(got), got)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for i, ev := range events {
+		if ev.Restarts != i+1 || ev.Uptime < cfg.MaxLifetime {
+			t.Errorf("event %d = %+v, want Restarts %d and Uptime >= %v", i, ev, i+1, cfg.MaxLifetime)
+		}
+	}
+}
+
+func TestRun_MaxLifetimeOnceExits(t *testing.T) {
+	ts := httptest.NewServer(&frameRecorder{})
+	defer ts.Close()
+
+	tmpDir := t.TempDir()
+	walDir := filepath.Join(tmpDir, "wal")
+	writeTestSegment(t, walDir, 1, "a1\n")
+
+	cfg := Config{
+		ServiceURL:   ts.URL,
+		WALDir:       walDir,
+		StateDir:     filepath.Join(tmpDir, "state"),
+		PollInterval: time.Millisecond,
+		SendInterval: time.Millisecond,
+		HardInterval: time.Millisecond,
+		MaxLifetime:  time.Hour,
+		Once:         true,
+	}
+	if err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+}
@@ -0,0 +1,36 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrAlreadyLocked is matched by the error Run returns when another
+// instance holds the lock on the same StateDir.
+var ErrAlreadyLocked = errors.New("state directory is in use by another walship instance")
+
+func lockFile(dir string) string {
+	return filepath.Join(dir, "status.lock")
+}
+
+// lockStateDir takes an exclusive advisory lock on dir, so two agents
+// cannot share one status.json. The returned func releases it. The lock
+// file itself is left in place; removing it would race with a waiting
+// instance.
+func lockStateDir(dir string) (func(), error) {
+	path := lockFile(dir)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open state lock: %w", err)
+	}
+	if err := tryLock(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%w: %s: %v", ErrAlreadyLocked, path, err)
+	}
+	return func() {
+		unlock(f)
+		f.Close()
+	}, nil
+}
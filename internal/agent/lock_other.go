@@ -0,0 +1,11 @@
+//go:build !unix
+
+package agent
+
+import "os"
+
+// Advisory locking is only implemented with flock; elsewhere the state
+// directory is not locked.
+func tryLock(f *os.File) error { return nil }
+
+func unlock(f *os.File) {}
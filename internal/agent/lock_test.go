@@ -0,0 +1,55 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRun_StateDirLock(t *testing.T) {
+	rec := &frameRecorder{}
+	ts := httptest.NewServer(rec)
+	defer ts.Close()
+
+	tmpDir := t.TempDir()
+	walDir := filepath.Join(tmpDir, "wal")
+	writeTestSegment(t, walDir, 1, "a1\n")
+	cfg := Config{
+		ServiceURL:   ts.URL,
+		WALDir:       walDir,
+		StateDir:     filepath.Join(tmpDir, "state"),
+		PollInterval: time.Millisecond,
+		SendInterval: time.Millisecond,
+		HardInterval: time.Millisecond,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Run(ctx, cfg) }()
+	deadline := time.Now().Add(5 * time.Second)
+	for rec.count() < 1 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	second := cfg
+	second.Once = true
+	if err := Run(context.Background(), second); !errors.Is(err, ErrAlreadyLocked) {
+		t.Fatalf("second Run() error = %v, want ErrAlreadyLocked", err)
+	}
+	// Read-only tooling can opt out
+	second.DisableLock = true
+	if err := Run(context.Background(), second); err != nil {
+		t.Fatalf("second Run() with DisableLock error = %v", err)
+	}
+
+	cancel()
+	<-done
+	// The lock is released when Run returns
+	second.DisableLock = false
+	if err := Run(context.Background(), second); err != nil {
+		t.Fatalf("Run() after the first stopped error = %v", err)
+	}
+}
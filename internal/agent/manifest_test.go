@@ -0,0 +1,210 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var manifestFixture = []FrameMeta{
+	{File: "seg-000001.wal.gz", Frame: 1, Off: 0, Len: 23, Recs: 1, FirstTS: 1700000000000000000, LastTS: 1700000000000000001, CRC32: 0xdeadbeef},
+	{File: "seg-000001.wal.gz", Frame: 2, Off: 23, Len: 70000, Recs: 300, FirstTS: -5, LastTS: 0, CRC32: 0},
+	{File: "", Frame: math.MaxUint64, Off: 1 << 32, Len: 255, Recs: math.MaxUint32, FirstTS: math.MinInt64, LastTS: math.MaxInt64, CRC32: math.MaxUint32},
+}
+
+func TestJSONManifestEncoder_RoundTrip(t *testing.T) {
+	data, ct, err := JSONManifestEncoder{}.Encode(manifestFixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct != "application/json" {
+		t.Errorf("content type = %q", ct)
+	}
+	var got []FrameMeta
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, manifestFixture) {
+		t.Errorf("round trip = %+v, want %+v", got, manifestFixture)
+	}
+}
+
+func TestCBORManifestEncoder_RoundTrip(t *testing.T) {
+	data, ct, err := CBORManifestEncoder{}.Encode(manifestFixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct != "application/cbor" {
+		t.Errorf("content type = %q", ct)
+	}
+	got, err := decodeCBORManifest(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, manifestFixture) {
+		t.Errorf("round trip = %+v, want %+v", got, manifestFixture)
+	}
+
+	// Empty manifests are an empty array
+	data, _, _ = CBORManifestEncoder{}.Encode(nil)
+	if len(data) != 1 || data[0] != 0x80 {
+		t.Errorf("empty manifest = %x, want 80", data)
+	}
+}
+
+func TestTrySend_ManifestEncoder(t *testing.T) {
+	var (
+		partType string
+		raw      []byte
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		for {
+			p, err := mr.NextPart()
+			if err != nil {
+				break
+			}
+			if p.FormName() == "manifest" {
+				partType = p.Header.Get("Content-Type")
+				raw, _ = io.ReadAll(p)
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	batch := []batchFrame{{Meta: manifestFixture[0], Compressed: []byte("x"), IdxLineLen: 10}}
+	batchBytes := 1
+	st := state{}
+	var ss sendState
+	cfg := Config{ServiceURL: ts.URL, ManifestEncoder: CBORManifestEncoder{}}
+	if err := trySend(context.Background(), cfg, http.DefaultClient, &batch, &batchBytes, &st, &ss, "seg-000001.wal.idx", nil, false, newBackoff(time.Millisecond, time.Millisecond)); err != nil {
+		t.Fatalf("trySend() error = %v", err)
+	}
+	if partType != "application/cbor" {
+		t.Errorf("manifest Content-Type = %q, want application/cbor", partType)
+	}
+	got, err := decodeCBORManifest(raw)
+	if err != nil || len(got) != 1 || got[0] != manifestFixture[0] {
+		t.Errorf("manifest = %+v (err %v)", got, err)
+	}
+}
+
+// decodeCBORManifest decodes the subset of CBOR CBORManifestEncoder writes.
+func decodeCBORManifest(b []byte) ([]FrameMeta, error) {
+	d := &cborDecoder{b: b}
+	mt, n, err := d.head()
+	if err != nil || mt != cborMajorArray {
+		return nil, fmt.Errorf("want array: %v", err)
+	}
+	out := make([]FrameMeta, n)
+	for i := range out {
+		mt, fields, err := d.head()
+		if err != nil || mt != cborMajorMap {
+			return nil, fmt.Errorf("frame %d: want map: %v", i, err)
+		}
+		fm := &out[i]
+		for j := uint64(0); j < fields; j++ {
+			key, err := d.text()
+			if err != nil {
+				return nil, err
+			}
+			if key == "file" {
+				if fm.File, err = d.text(); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			mt, v, err := d.head()
+			if err != nil {
+				return nil, err
+			}
+			n := int64(v)
+			if mt == cborMajorNeg {
+				n = -1 - int64(v)
+			}
+			switch key {
+			case "frame":
+				fm.Frame = v
+			case "off":
+				fm.Off = v
+			case "len":
+				fm.Len = v
+			case "recs":
+				fm.Recs = uint32(v)
+			case "first_ts":
+				fm.FirstTS = n
+			case "last_ts":
+				fm.LastTS = n
+			case "crc32":
+				fm.CRC32 = uint32(v)
+			default:
+				return nil, fmt.Errorf("unknown key %q", key)
+			}
+		}
+	}
+	if d.off != len(b) {
+		return nil, fmt.Errorf("%d trailing bytes", len(b)-d.off)
+	}
+	return out, nil
+}
+
+type cborDecoder struct {
+	b   []byte
+	off int
+}
+
+func (d *cborDecoder) take(n int) ([]byte, error) {
+	if d.off+n > len(d.b) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	p := d.b[d.off : d.off+n]
+	d.off += n
+	return p, nil
+}
+
+func (d *cborDecoder) head() (byte, uint64, error) {
+	p, err := d.take(1)
+	if err != nil {
+		return 0, 0, err
+	}
+	mt, info := p[0]&0xe0, p[0]&0x1f
+	if info < 24 {
+		return mt, uint64(info), nil
+	}
+	size := map[byte]int{24: 1, 25: 2, 26: 4, 27: 8}[info]
+	if size == 0 {
+		return 0, 0, fmt.Errorf("unsupported additional info %d", info)
+	}
+	p, err = d.take(size)
+	if err != nil {
+		return 0, 0, err
+	}
+	var v uint64
+	for _, c := range p {
+		v = v<<8 | uint64(c)
+	}
+	return mt, v, nil
+}
+
+func (d *cborDecoder) text() (string, error) {
+	mt, n, err := d.head()
+	if err != nil {
+		return "", err
+	}
+	if mt != cborMajorText {
+		return "", fmt.Errorf("want text, got major type %d", mt>>5)
+	}
+	p, err := d.take(int(n))
+	return string(p), err
+}
@@ -0,0 +1,66 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// AgentKey identifies an agent within a multi-agent process as chainID/nodeID.
+func AgentKey(cfg Config) string {
+	return cfg.ChainID + "/" + cfg.NodeID
+}
+
+// RunMulti runs one independent agent per config and blocks until all of
+// them have returned. A failing agent does not stop the others; its error is
+// reported, prefixed with its AgentKey, in the joined result, and so is a
+// panic in one of its callbacks. Each config must be validated beforehand
+// and map to a distinct key and state dir.
+func RunMulti(ctx context.Context, cfgs []Config) error {
+	seenKeys := make(map[string]bool, len(cfgs))
+	seenState := make(map[string]string, len(cfgs))
+	for _, cfg := range cfgs {
+		key := AgentKey(cfg)
+		if seenKeys[key] {
+			return fmt.Errorf("duplicate agent %s", key)
+		}
+		seenKeys[key] = true
+		if other, ok := seenState[cfg.StateDir]; ok {
+			return fmt.Errorf("agents %s and %s share state dir %s", other, key, cfg.StateDir)
+		}
+		seenState[cfg.StateDir] = key
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for _, cfg := range cfgs {
+		wg.Add(1)
+		go func(cfg Config) {
+			defer wg.Done()
+			key := AgentKey(cfg)
+			// A panicking callback takes down only its own agent
+			defer func() {
+				if r := recover(); r != nil {
+					logger.Error().Str("agent", key).Interface("panic", r).Msg("agent panicked")
+					mu.Lock()
+					errs = append(errs, fmt.Errorf("%s: panic: %v", key, r))
+					mu.Unlock()
+				}
+			}()
+			err := Run(ctx, cfg)
+			if err == nil || (ctx.Err() != nil && errors.Is(err, ctx.Err())) {
+				return
+			}
+			logger.Error().Err(err).Str("agent", key).Msg("agent stopped")
+			mu.Lock()
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+			mu.Unlock()
+		}(cfg)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
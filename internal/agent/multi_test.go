@@ -0,0 +1,134 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunMulti_IndependentProgress(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		byNode = map[string]int{}
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		byNode[r.Header.Get("X-Cosmos-Analyzer-Node-Id")]++
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	tmp := t.TempDir()
+	newCfg := func(node string) Config {
+		walDir := filepath.Join(tmp, node, "wal")
+		return Config{
+			ChainID:      "chain",
+			NodeID:       node,
+			WALDir:       walDir,
+			StateDir:     filepath.Join(tmp, node, "state"),
+			ServiceURL:   ts.URL,
+			PollInterval: time.Millisecond,
+			SendInterval: time.Millisecond,
+			HardInterval: time.Millisecond,
+			Once:         true,
+		}
+	}
+
+	a, b := newCfg("node-a"), newCfg("node-b")
+	writeTestSegment(t, a.WALDir, 1, "a1\n", "a2\n")
+	writeTestSegment(t, b.WALDir, 1, "b1\n")
+
+	// node-c has no WAL directory and fails on startup
+	broken := newCfg("node-c")
+
+	err := RunMulti(context.Background(), []Config{a, b, broken})
+	if err == nil || !strings.Contains(err.Error(), "chain/node-c") {
+		t.Fatalf("RunMulti() error = %v, want failure for chain/node-c", err)
+	}
+
+	for _, cfg := range []Config{a, b} {
+		st, err := loadState(cfg.StateDir)
+		if err != nil {
+			t.Fatalf("%s: load state: %v", AgentKey(cfg), err)
+		}
+		if st.LastFrame == 0 {
+			t.Errorf("%s: no progress recorded", AgentKey(cfg))
+		}
+	}
+	if byNode["node-a"] == 0 || byNode["node-b"] == 0 {
+		t.Errorf("sends by node = %v, want both nodes shipping", byNode)
+	}
+}
+
+func TestRunMulti_PanickingAgent(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		byNode = map[string]int{}
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		byNode[r.Header.Get("X-Cosmos-Analyzer-Node-Id")]++
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	tmp := t.TempDir()
+	newCfg := func(node string) Config {
+		walDir := filepath.Join(tmp, node, "wal")
+		return Config{
+			ChainID:      "chain",
+			NodeID:       node,
+			WALDir:       walDir,
+			StateDir:     filepath.Join(tmp, node, "state"),
+			ServiceURL:   ts.URL,
+			PollInterval: time.Millisecond,
+			SendInterval: time.Millisecond,
+			HardInterval: time.Millisecond,
+			Once:         true,
+		}
+	}
+
+	good, bad := newCfg("node-a"), newCfg("node-b")
+	writeTestSegment(t, good.WALDir, 1, "a1\n", "a2\n")
+	writeTestSegment(t, bad.WALDir, 1, "b1\n")
+	bad.FrameFilter = func(FrameMeta) bool { panic("filter exploded") }
+
+	err := RunMulti(context.Background(), []Config{good, bad})
+	if err == nil || !strings.Contains(err.Error(), "chain/node-b: panic: filter exploded") {
+		t.Fatalf("RunMulti() error = %v, want panic reported for chain/node-b", err)
+	}
+	if strings.Contains(err.Error(), "chain/node-a") {
+		t.Errorf("RunMulti() error = %v, want node-a to succeed", err)
+	}
+
+	st, err := loadState(good.StateDir)
+	if err != nil {
+		t.Fatalf("load state: %v", err)
+	}
+	if st.LastFrame != 2 {
+		t.Errorf("node-a LastFrame = %d, want 2", st.LastFrame)
+	}
+	if byNode["node-a"] == 0 || byNode["node-b"] != 0 {
+		t.Errorf("sends by node = %v, want only node-a shipping", byNode)
+	}
+}
+
+func TestRunMulti_DuplicateAgents(t *testing.T) {
+	cfg := Config{ChainID: "chain", NodeID: "n", StateDir: t.TempDir()}
+	if err := RunMulti(context.Background(), []Config{cfg, cfg}); err == nil {
+		t.Error("RunMulti() expected error for duplicate agents")
+	}
+
+	other := cfg
+	other.NodeID = "m"
+	if err := RunMulti(context.Background(), []Config{cfg, other}); err == nil {
+		t.Error("RunMulti() expected error for shared state dir")
+	}
+}
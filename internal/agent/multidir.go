@@ -0,0 +1,27 @@
+package agent
+
+// walDirs returns the WAL directories Run ships from, in order: WALDir
+// first, then ExtraWALDirs.
+func (c Config) walDirs() []string {
+	dirs := make([]string, 0, 1+len(c.ExtraWALDirs))
+	dirs = append(dirs, c.WALDir)
+	return append(dirs, c.ExtraWALDirs...)
+}
+
+// firstIndexFrom returns the oldest index in the first of dirs[from:] that
+// has one, ignoring days before minDay, together with that directory's
+// position in dirs. If none has an index, the error for dirs[from] is
+// returned.
+func firstIndexFrom(dirs []string, from int, minDay string) (string, int, error) {
+	var firstErr error
+	for i := from; i < len(dirs); i++ {
+		p, err := oldestIndex(dirs[i], minDay)
+		if err == nil {
+			return p, i, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return "", 0, firstErr
+}
@@ -0,0 +1,161 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// frameRecorder is an ingest server that records every shipped frame's
+// file and frame number in arrival order.
+type frameRecorder struct {
+	mu     sync.Mutex
+	frames []FrameMeta
+}
+
+func (fr *frameRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != walFramesEndpoint {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+		if part.FormName() != "manifest" {
+			continue
+		}
+		data, _ := io.ReadAll(part)
+		var manifest []FrameMeta
+		_ = json.Unmarshal(data, &manifest)
+		fr.mu.Lock()
+		fr.frames = append(fr.frames, manifest...)
+		fr.mu.Unlock()
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (fr *frameRecorder) count() int {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	return len(fr.frames)
+}
+
+func TestRun_ExtraWALDirsInOrder(t *testing.T) {
+	rec := &frameRecorder{}
+	ts := httptest.NewServer(rec)
+	defer ts.Close()
+
+	tmp := t.TempDir()
+	cold := filepath.Join(tmp, "cold")
+	hot := filepath.Join(tmp, "hot")
+	writeTestSegment(t, cold, 1, "c1\n", "c2\n")
+	writeTestSegment(t, cold, 2, "c3\n")
+	writeTestSegment(t, hot, 1, "h1\n", "h2\n")
+
+	cfg := Config{
+		ServiceURL:   ts.URL,
+		WALDir:       cold,
+		ExtraWALDirs: []string{hot},
+		StateDir:     filepath.Join(tmp, "state"),
+		PollInterval: time.Millisecond,
+		SendInterval: time.Millisecond,
+		HardInterval: time.Millisecond,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Run(ctx, cfg) }()
+	deadline := time.Now().Add(5 * time.Second)
+	for rec.count() < 5 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	rec.mu.Lock()
+	got := rec.frames
+	rec.mu.Unlock()
+	want := []struct {
+		file  string
+		frame uint64
+	}{
+		{"seg-000001.wal.gz", 1}, {"seg-000001.wal.gz", 2},
+		{"seg-000002.wal.gz", 1},
+		{"seg-000001.wal.gz", 1}, {"seg-000001.wal.gz", 2},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("shipped %d frames, want %d: %+v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		if got[i].File != w.file || got[i].Frame != w.frame {
+			t.Errorf("frame %d = %s#%d, want %s#%d", i, got[i].File, got[i].Frame, w.file, w.frame)
+		}
+	}
+
+	st, err := loadState(cfg.StateDir)
+	if err != nil {
+		t.Fatalf("load state: %v", err)
+	}
+	if st.DirIndex != 1 || filepath.Dir(st.IdxPath) != hot {
+		t.Fatalf("state = dir %d %s, want dir 1 in %s", st.DirIndex, st.IdxPath, hot)
+	}
+}
+
+func TestRun_ExtraWALDirsResume(t *testing.T) {
+	rec := &frameRecorder{}
+	ts := httptest.NewServer(rec)
+	defer ts.Close()
+
+	tmp := t.TempDir()
+	cold := filepath.Join(tmp, "cold")
+	hot := filepath.Join(tmp, "hot")
+	writeTestSegment(t, cold, 1, "c1\n")
+	metas := writeTestSegment(t, hot, 1, "h1\n", "h2\n")
+
+	// A previous run finished the cold tier and the first hot frame.
+	stateDir := filepath.Join(tmp, "state")
+	hotIdx := filepath.Join(hot, "seg-000001.wal.idx")
+	first, _ := json.Marshal(metas[0])
+	if err := saveState(stateDir, state{IdxPath: hotIdx, IdxOffset: int64(len(first) + 1), DirIndex: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := Config{
+		ServiceURL:   ts.URL,
+		WALDir:       cold,
+		ExtraWALDirs: []string{hot},
+		StateDir:     stateDir,
+		PollInterval: time.Millisecond,
+		SendInterval: time.Millisecond,
+		HardInterval: time.Millisecond,
+		Once:         true,
+	}
+	if err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.frames) != 1 || rec.frames[0].Frame != 2 {
+		t.Fatalf("shipped %+v, want only the second hot frame", rec.frames)
+	}
+	st, err := loadState(stateDir)
+	if err != nil {
+		t.Fatalf("load state: %v", err)
+	}
+	if st.DirIndex != 1 {
+		t.Errorf("DirIndex = %d, want 1", st.DirIndex)
+	}
+}
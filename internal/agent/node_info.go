@@ -17,33 +17,59 @@ const (
 	DefaultNodeKeyName     = "node_key.json"
 )
 
-// LoadNodeInfo loads ChainID and NodeID from files if they are not already set in the config.
-// It respects the NodeHome directory in the config.
+// NodeInfoResolver derives the chain and node IDs from a node home
+// directory. Set Config.NodeInfoResolver for chains whose genesis or node
+// key format differs from CometBFT's.
+type NodeInfoResolver interface {
+	Resolve(nodeHome string) (chainID, nodeID string, err error)
+}
+
+// TendermintResolver reads the chain ID from config/genesis.json and derives
+// the node ID from the ed25519 key in config/node_key.json. It is what
+// LoadNodeInfo uses when Config.NodeInfoResolver is nil.
+type TendermintResolver struct{}
+
+// Resolve implements NodeInfoResolver.
+func (TendermintResolver) Resolve(nodeHome string) (string, string, error) {
+	chainID, err := readChainID(nodeHome)
+	if err != nil {
+		return "", "", fmt.Errorf("read chain id: %w", err)
+	}
+	nodeID, err := readNodeID(nodeHome)
+	if err != nil {
+		return "", "", fmt.Errorf("read node id: %w", err)
+	}
+	return chainID, nodeID, nil
+}
+
+// LoadNodeInfo fills ChainID and NodeID from the files under NodeHome if
+// they are not already set in the config, using cfg.NodeInfoResolver or,
+// when that is nil, TendermintResolver.
 func LoadNodeInfo(cfg *Config) error {
-	// Read ChainID from genesis.json if not set
-	if cfg.ChainID == "" {
-		if cfg.NodeHome != "" {
-			chainID, err := readChainID(cfg.NodeHome)
-			if err != nil {
-				return fmt.Errorf("read chain id: %w", err)
-			}
-			cfg.ChainID = chainID
-		} else {
+	needChain := cfg.ChainID == ""
+	needNode := cfg.NodeID == "" || cfg.NodeID == "default"
+	if !needChain && !needNode {
+		return nil
+	}
+	if cfg.NodeHome == "" {
+		if needChain {
 			return fmt.Errorf("chain-id is required (or node-home)")
 		}
+		return fmt.Errorf("node-id is required (or node-home)")
 	}
-
-	// Read NodeID from node_key.json if not set (or default)
-	if cfg.NodeID == "" || cfg.NodeID == "default" {
-		if cfg.NodeHome != "" {
-			nodeID, err := readNodeID(cfg.NodeHome)
-			if err != nil {
-				return fmt.Errorf("read node id: %w", err)
-			}
-			cfg.NodeID = nodeID
-		} else {
-			return fmt.Errorf("node-id is required (or node-home)")
-		}
+	var r NodeInfoResolver = TendermintResolver{}
+	if cfg.NodeInfoResolver != nil {
+		r = cfg.NodeInfoResolver
+	}
+	chainID, nodeID, err := r.Resolve(cfg.NodeHome)
+	if err != nil {
+		return fmt.Errorf("resolve node info: %w", err)
+	}
+	if needChain {
+		cfg.ChainID = chainID
+	}
+	if needNode {
+		cfg.NodeID = nodeID
 	}
 	return nil
 }
@@ -72,20 +98,10 @@ func readNodeID(nodeHome string) (string, error) {
 		return "", err
 	}
 
-	// Decode base64 private key
-	privKeyBytes, err := base64.StdEncoding.DecodeString(nk.PrivKey.Value)
+	privKey, err := decodePrivKey(nk.PrivKey.Value)
 	if err != nil {
-		return "", fmt.Errorf("decode priv key: %w", err)
-	}
-
-	// Ed25519 private key is 64 bytes. Public key is the last 32 bytes.
-	// Or we can regenerate it from the seed (first 32 bytes).
-	// CometBFT uses standard Ed25519.
-	if len(privKeyBytes) != ed25519.PrivateKeySize {
-		return "", fmt.Errorf("invalid priv key length: %d", len(privKeyBytes))
+		return "", err
 	}
-
-	privKey := ed25519.PrivateKey(privKeyBytes)
 	pubKey := privKey.Public().(ed25519.PublicKey)
 
 	// Address is the first 20 bytes of SHA256(PubKey)
@@ -95,6 +111,36 @@ func readNodeID(nodeHome string) (string, error) {
 	return hex.EncodeToString(address), nil
 }
 
+// decodePrivKey decodes an ed25519 private key written as base64 (as
+// CometBFT does) or, by some tooling, as hex. Both the 64-byte key and its
+// 32-byte seed are accepted.
+func decodePrivKey(value string) (ed25519.PrivateKey, error) {
+	b, err := base64.StdEncoding.DecodeString(value)
+	// A hex key is also valid base64, so fall back on a bad length too
+	if err != nil || !validPrivKeyLen(len(b)) {
+		if h, herr := hex.DecodeString(value); herr == nil {
+			b, err = h, nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decode priv key: neither base64 nor hex: %w", err)
+	}
+
+	// CometBFT uses standard Ed25519: the public key is the last 32 bytes
+	// of the private key, or is derived from the seed.
+	switch len(b) {
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(b), nil
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(b), nil
+	}
+	return nil, fmt.Errorf("invalid priv key length: %d bytes, want %d or %d", len(b), ed25519.SeedSize, ed25519.PrivateKeySize)
+}
+
+func validPrivKeyLen(n int) bool {
+	return n == ed25519.PrivateKeySize || n == ed25519.SeedSize
+}
+
 // rootify returns the absolute path if path is absolute,
 // otherwise it joins nodeHome and path.
 func rootify(path, nodeHome string) string {
@@ -6,8 +6,10 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -150,3 +152,133 @@ func TestLoadNodeInfo(t *testing.T) {
 		})
 	}
 }
+
+type fakeResolver struct {
+	calls   int
+	chainID string
+	nodeID  string
+	err     error
+}
+
+func (r *fakeResolver) Resolve(nodeHome string) (string, string, error) {
+	r.calls++
+	return r.chainID, r.nodeID, r.err
+}
+
+func TestLoadNodeInfo_Resolver(t *testing.T) {
+	r := &fakeResolver{chainID: "fork-1", nodeID: "fork-node"}
+	cfg := Config{NodeHome: t.TempDir(), NodeID: "default", NodeInfoResolver: r}
+	if err := LoadNodeInfo(&cfg); err != nil {
+		t.Fatalf("LoadNodeInfo() error = %v", err)
+	}
+	if cfg.ChainID != "fork-1" || cfg.NodeID != "fork-node" {
+		t.Errorf("got %s/%s, want fork-1/fork-node", cfg.ChainID, cfg.NodeID)
+	}
+
+	// Explicit IDs win, and nothing is resolved when both are set
+	r.calls = 0
+	cfg = Config{NodeHome: t.TempDir(), ChainID: "manual", NodeInfoResolver: r}
+	if err := LoadNodeInfo(&cfg); err != nil {
+		t.Fatalf("LoadNodeInfo() error = %v", err)
+	}
+	if cfg.ChainID != "manual" || cfg.NodeID != "fork-node" {
+		t.Errorf("got %s/%s, want manual/fork-node", cfg.ChainID, cfg.NodeID)
+	}
+	cfg = Config{NodeHome: t.TempDir(), ChainID: "a", NodeID: "b", NodeInfoResolver: r}
+	if err := LoadNodeInfo(&cfg); err != nil || r.calls != 1 {
+		t.Errorf("LoadNodeInfo() error = %v, resolver calls = %d, want 1", err, r.calls)
+	}
+
+	r.err = errors.New("unsupported key type")
+	cfg = Config{NodeHome: t.TempDir(), NodeInfoResolver: r}
+	if err := LoadNodeInfo(&cfg); !errors.Is(err, r.err) {
+		t.Errorf("LoadNodeInfo() error = %v, want resolver error", err)
+	}
+}
+
+func TestTendermintResolver(t *testing.T) {
+	home := t.TempDir()
+	configDir := filepath.Join(home, "config")
+	if err := os.Mkdir(configDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(configDir, "genesis.json"), []byte(`{"chain_id":"test-chain-1"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	pubKey, privKey, _ := ed25519.GenerateKey(nil)
+	sha := sha256.Sum256(pubKey)
+	nodeKeyJSON := `{"priv_key":{"type":"tendermint/PrivKeyEd25519","value":"` + base64.StdEncoding.EncodeToString(privKey) + `"}}`
+	if err := os.WriteFile(filepath.Join(configDir, "node_key.json"), []byte(nodeKeyJSON), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	chainID, nodeID, err := TendermintResolver{}.Resolve(home)
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if chainID != "test-chain-1" || nodeID != hex.EncodeToString(sha[:20]) {
+		t.Errorf("Resolve() = %s/%s, want test-chain-1/%x", chainID, nodeID, sha[:20])
+	}
+
+	// Same result through LoadNodeInfo with the resolver set explicitly
+	cfg := Config{NodeHome: home, NodeInfoResolver: TendermintResolver{}}
+	if err := LoadNodeInfo(&cfg); err != nil || cfg.ChainID != chainID || cfg.NodeID != nodeID {
+		t.Errorf("LoadNodeInfo() = %s/%s, %v", cfg.ChainID, cfg.NodeID, err)
+	}
+}
+
+func TestDecodePrivKey(t *testing.T) {
+	_, privKey, _ := ed25519.GenerateKey(nil)
+	want := privKey.Public().(ed25519.PublicKey)
+
+	for name, value := range map[string]string{
+		"base64":      base64.StdEncoding.EncodeToString(privKey),
+		"hex":         hex.EncodeToString(privKey),
+		"hex upper":   strings.ToUpper(hex.EncodeToString(privKey)),
+		"base64 seed": base64.StdEncoding.EncodeToString(privKey.Seed()),
+		"hex seed":    hex.EncodeToString(privKey.Seed()),
+	} {
+		got, err := decodePrivKey(value)
+		if err != nil {
+			t.Errorf("%s: decodePrivKey() error = %v", name, err)
+			continue
+		}
+		if !want.Equal(got.Public()) {
+			t.Errorf("%s: decoded a different key", name)
+		}
+	}
+
+	for name, value := range map[string]string{
+		"neither":    "not-base64-or-hex!",
+		"bad length": hex.EncodeToString([]byte("short-key")),
+	} {
+		if _, err := decodePrivKey(value); err == nil {
+			t.Errorf("%s: decodePrivKey() error = nil", name)
+		}
+	}
+}
+
+func TestReadNodeID_HexKeyMatchesBase64(t *testing.T) {
+	_, privKey, _ := ed25519.GenerateKey(nil)
+	nodeIDFor := func(value string) string {
+		t.Helper()
+		home := t.TempDir()
+		if err := os.Mkdir(filepath.Join(home, "config"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		nodeKeyJSON := `{"priv_key":{"type":"tendermint/PrivKeyEd25519","value":"` + value + `"}}`
+		if err := os.WriteFile(filepath.Join(home, "config", "node_key.json"), []byte(nodeKeyJSON), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		id, err := readNodeID(home)
+		if err != nil {
+			t.Fatalf("readNodeID() error = %v", err)
+		}
+		return id
+	}
+
+	b64 := nodeIDFor(base64.StdEncoding.EncodeToString(privKey))
+	if hexID := nodeIDFor(hex.EncodeToString(privKey)); hexID != b64 {
+		t.Errorf("hex key node ID = %s, want %s", hexID, b64)
+	}
+}
@@ -0,0 +1,90 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	minPaceDelay = time.Second
+	maxPaceDelay = time.Minute
+)
+
+// rateLimitedError is returned by trySend when the service answers 429.
+// RetryAfter carries the parsed Retry-After header (zero when absent).
+type rateLimitedError struct {
+	RetryAfter time.Duration
+	Body       string
+}
+
+func (e *rateLimitedError) Is(target error) bool { return target == ErrRateLimited }
+
+func (e *rateLimitedError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("rate limited by service (retry after %s)", e.RetryAfter)
+	}
+	return "rate limited by service"
+}
+
+// pacer slows the read loop while the service signals overload. Unlike
+// backoff, which only delays retries of the current batch, the pacer's
+// delay is applied before reading each new frame.
+type pacer struct {
+	min   time.Duration
+	max   time.Duration
+	delay time.Duration
+}
+
+func newPacer(min, max time.Duration) *pacer { return &pacer{min: min, max: max} }
+
+// Observe updates the suggested delay from the outcome of a send. A 429
+// sets the delay to Retry-After when present, otherwise doubles it; a
+// successful send clears it. Other errors leave it unchanged.
+func (p *pacer) Observe(err error) {
+	if err == nil {
+		p.delay = 0
+		return
+	}
+	var rl *rateLimitedError
+	if !errors.As(err, &rl) {
+		return
+	}
+	switch {
+	case rl.RetryAfter > 0:
+		p.delay = rl.RetryAfter
+	case p.delay <= 0:
+		p.delay = p.min
+	default:
+		p.delay *= 2
+	}
+	if p.delay > p.max {
+		p.delay = p.max
+	}
+}
+
+// SuggestDelay returns how long the read loop should wait before reading
+// the next frame. Zero means read at full speed.
+func (p *pacer) SuggestDelay() time.Duration { return p.delay }
+
+// parseRetryAfter parses a Retry-After header given either as delay-seconds
+// or as an HTTP date. Unparseable or past values yield zero.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs <= 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
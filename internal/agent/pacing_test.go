@@ -0,0 +1,216 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPacer_SustainedRateLimit(t *testing.T) {
+	p := newPacer(time.Second, 8*time.Second)
+
+	if d := p.SuggestDelay(); d != 0 {
+		t.Fatalf("initial delay = %v, want 0", d)
+	}
+
+	// Repeated 429s without Retry-After grow the delay up to the cap
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 8 * time.Second}
+	for i, w := range want {
+		p.Observe(&rateLimitedError{})
+		if d := p.SuggestDelay(); d != w {
+			t.Errorf("after %d 429s delay = %v, want %v", i+1, d, w)
+		}
+	}
+
+	// Unrelated errors leave the delay alone
+	p.Observe(errors.New("connection refused"))
+	if d := p.SuggestDelay(); d != 8*time.Second {
+		t.Errorf("delay after non-429 error = %v, want 8s", d)
+	}
+
+	// Success clears pacing
+	p.Observe(nil)
+	if d := p.SuggestDelay(); d != 0 {
+		t.Errorf("delay after success = %v, want 0", d)
+	}
+}
+
+func TestPacer_RetryAfter(t *testing.T) {
+	p := newPacer(time.Second, 30*time.Second)
+
+	p.Observe(&rateLimitedError{RetryAfter: 5 * time.Second})
+	if d := p.SuggestDelay(); d != 5*time.Second {
+		t.Errorf("delay = %v, want 5s", d)
+	}
+
+	// Retry-After beyond the cap is clamped
+	p.Observe(&rateLimitedError{RetryAfter: time.Hour})
+	if d := p.SuggestDelay(); d != 30*time.Second {
+		t.Errorf("delay = %v, want 30s", d)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"", 0},
+		{"3", 3 * time.Second},
+		{"0", 0},
+		{"-1", 0},
+		{"garbage", 0},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.in); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+
+	future := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(future); got <= 0 || got > time.Minute {
+		t.Errorf("parseRetryAfter(%q) = %v, want within (0, 1m]", future, got)
+	}
+}
+
+func TestTrySend_RateLimited(t *testing.T) {
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Retry-After", "2")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer ts.Close()
+
+	cfg := Config{ServiceURL: ts.URL}
+	batch := []batchFrame{{Meta: FrameMeta{File: "f", Frame: 1}, Compressed: []byte("x"), IdxLineLen: 10}}
+	batchBytes := 1
+	st := state{}
+	var ss sendState
+	back := newBackoff(time.Millisecond, time.Millisecond)
+	pace := newPacer(time.Second, time.Minute)
+
+	// Sustained 429s keep the batch and keep the read loop paced
+	for i := 0; i < 3; i++ {
+		err := trySend(context.Background(), cfg, http.DefaultClient, &batch, &batchBytes, &st, &ss, "000.idx", nil, false, back)
+		var rl *rateLimitedError
+		if !errors.As(err, &rl) {
+			t.Fatalf("trySend() error = %v, want rateLimitedError", err)
+		}
+		pace.Observe(err)
+		if d := pace.SuggestDelay(); d != 2*time.Second {
+			t.Errorf("suggested delay = %v, want 2s", d)
+		}
+	}
+
+	if requests != 3 {
+		t.Errorf("requests = %d, want 3", requests)
+	}
+	if len(batch) != 1 {
+		t.Error("batch should be retained while rate limited")
+	}
+	if st.IdxOffset != 0 {
+		t.Error("state should not advance while rate limited")
+	}
+}
+
+func TestRun_PacesSustainedRateLimit(t *testing.T) {
+	tests := []struct {
+		name       string
+		retryAfter string
+		want       []time.Duration // spacing between successive attempts
+	}{
+		{"retry-after", "3", []time.Duration{3 * time.Second, 3 * time.Second, 3 * time.Second}},
+		{"no retry-after", "", []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != walFramesEndpoint {
+					w.WriteHeader(http.StatusOK)
+					return
+				}
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(http.StatusTooManyRequests)
+			}))
+			defer ts.Close()
+
+			tmpDir := t.TempDir()
+			walDir := filepath.Join(tmpDir, "wal")
+			writeTestSegment(t, walDir, 1, "f1\n")
+
+			clk := newFakeClock()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go advancePendingWaits(ctx, clk)
+
+			var (
+				attempts []time.Time
+				backoff  time.Duration
+			)
+			cfg := Config{
+				ServiceURL:     ts.URL,
+				WALDir:         walDir,
+				StateDir:       filepath.Join(tmpDir, "state"),
+				PollInterval:   time.Millisecond,
+				SendInterval:   time.Hour,
+				HardInterval:   time.Hour,
+				BackoffInitial: 10 * time.Millisecond,
+				BackoffMax:     10 * time.Millisecond,
+				OnSendError: func(ev SendErrorEvent) {
+					if !errors.Is(ev.Err, ErrRateLimited) {
+						t.Errorf("send error = %v, want ErrRateLimited", ev.Err)
+					}
+					attempts = append(attempts, clk.Now())
+					backoff = ev.TotalBackoff
+					if len(attempts) == len(tt.want)+1 {
+						cancel()
+					}
+				},
+			}
+			// Drain keeps retrying the one pending frame
+			if err := run(ctx, cfg, true, clk); !errors.Is(err, context.Canceled) {
+				t.Fatalf("run() error = %v, want context.Canceled", err)
+			}
+
+			var got []time.Duration
+			for i := 1; i < len(attempts); i++ {
+				got = append(got, attempts[i].Sub(attempts[i-1]))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("attempt spacing = %v, want %v", got, tt.want)
+			}
+			if backoff != 0 {
+				t.Errorf("TotalBackoff = %v, want 0 (the pacer alone waits)", backoff)
+			}
+		})
+	}
+}
+
+// advancePendingWaits moves clk to each wait as soon as one is registered,
+// so code paced by clk runs without sleeping.
+func advancePendingWaits(ctx context.Context, clk *fakeClock) {
+	for ctx.Err() == nil {
+		clk.mu.Lock()
+		var next time.Duration
+		for _, w := range clk.waiters {
+			if d := w.at.Sub(clk.now); !w.stopped && (next == 0 || d < next) {
+				next = d
+			}
+		}
+		clk.mu.Unlock()
+		if next > 0 {
+			clk.Advance(next)
+			continue
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,87 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+)
+
+// Peek returns up to n frames that Run would ship next for cfg, starting at
+// the position saved in cfg.StateDir (or the oldest index if there is none).
+// It only reads: the saved state is not advanced and nothing is sent, so it
+// may be called while an agent is running, though that agent may have moved
+// past its last saved position.
+func Peek(ctx context.Context, cfg Config, n int) ([]FrameMeta, error) {
+	dirs := cfg.walDirs()
+	st, err := loadState(cfg.StateDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if st.IdxPath == "" {
+		p, i, err := firstIndexFrom(dirs, 0, cfg.MinDay)
+		if err != nil {
+			return nil, err
+		}
+		st = state{IdxPath: p, DirIndex: i}
+	}
+
+	var frames []FrameMeta
+	path, off, dirIdx := st.IdxPath, st.IdxOffset, st.DirIndex
+	for len(frames) < n {
+		if err := ctx.Err(); err != nil {
+			return frames, err
+		}
+		more, err := peekIndex(path, off, n-len(frames), cfg)
+		frames = append(frames, more...)
+		if err != nil {
+			return frames, err
+		}
+		if len(frames) >= n {
+			break
+		}
+		// Follow rotation the same way Run does
+		next, ok, _ := nextIndexAfter(path, cfg.MinDay)
+		if !ok && dirIdx+1 < len(dirs) {
+			if p, i, err := firstIndexFrom(dirs, dirIdx+1, cfg.MinDay); err == nil {
+				next, ok, dirIdx = p, true, i
+			}
+		}
+		if !ok {
+			break
+		}
+		path, off = next, 0
+	}
+	return frames, nil
+}
+
+// peekIndex reads up to n complete frames from path starting at off,
+// skipping those Run would skip.
+func peekIndex(path string, off int64, n int, cfg Config) ([]FrameMeta, error) {
+	f, r, err := openIdx(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if off > 0 {
+		if _, err := f.Seek(off, io.SeekStart); err != nil {
+			return nil, err
+		}
+		r.Reset(f)
+	}
+	var frames []FrameMeta
+	for len(frames) < n {
+		fm, _, err := nextFrame(r, path, cfg.LenientIndex, cfg.StrictIndexFields)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return frames, err
+		}
+		if skipFrame(cfg, fm) {
+			continue
+		}
+		frames = append(frames, fm)
+	}
+	return frames, nil
+}
@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPeek(t *testing.T) {
+	walDir := filepath.Join(t.TempDir(), "wal")
+	day := filepath.Join(walDir, "2026-01-01")
+	seg1 := writeTestSegment(t, day, 1, "a\n", "b\n")
+	seg2 := writeTestSegment(t, day, 2, "c\n")
+	cfg := Config{WALDir: walDir, StateDir: filepath.Join(t.TempDir(), "state")}
+
+	// No saved state: start at the oldest index and follow rotation
+	got, err := Peek(context.Background(), cfg, 5)
+	if err != nil {
+		t.Fatalf("Peek() error = %v", err)
+	}
+	if want := append(append([]FrameMeta{}, seg1...), seg2...); !reflect.DeepEqual(got, want) {
+		t.Errorf("Peek() = %+v, want %+v", got, want)
+	}
+
+	got, err = Peek(context.Background(), cfg, 2)
+	if err != nil {
+		t.Fatalf("Peek() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, seg1) {
+		t.Errorf("Peek(2) = %+v, want %+v", got, seg1)
+	}
+
+	// From a saved position, without moving it
+	idx1 := filepath.Join(day, "seg-000001.wal.idx")
+	raw, err := os.ReadFile(idx1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := state{IdxPath: idx1, IdxOffset: int64(bytes.IndexByte(raw, '\n') + 1)}
+	if err := saveState(cfg.StateDir, st); err != nil {
+		t.Fatal(err)
+	}
+	got, err = Peek(context.Background(), cfg, 2)
+	if err != nil {
+		t.Fatalf("Peek() error = %v", err)
+	}
+	if want := []FrameMeta{seg1[1], seg2[0]}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Peek() from offset = %+v, want %+v", got, want)
+	}
+	if after, _ := loadState(cfg.StateDir); after != st {
+		t.Errorf("state changed to %+v", after)
+	}
+}
+
+func TestPeek_SkipEmptyFrames(t *testing.T) {
+	walDir := filepath.Join(t.TempDir(), "wal")
+	// Payloads without a newline index as recs 0
+	seg := writeTestSegment(t, walDir, 1, "", "b\n", "", "", "e\n", "")
+	cfg := Config{WALDir: walDir, StateDir: filepath.Join(t.TempDir(), "state"), SkipEmptyFrames: true}
+
+	got, err := Peek(context.Background(), cfg, 5)
+	if err != nil {
+		t.Fatalf("Peek() error = %v", err)
+	}
+	if want := []FrameMeta{seg[1], seg[4]}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Peek() = %+v, want %+v", got, want)
+	}
+
+	// n counts the frames returned, not the empty ones passed over
+	got, err = Peek(context.Background(), cfg, 1)
+	if err != nil {
+		t.Fatalf("Peek() error = %v", err)
+	}
+	if want := []FrameMeta{seg[1]}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Peek(1) = %+v, want %+v", got, want)
+	}
+}
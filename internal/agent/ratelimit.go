@@ -0,0 +1,47 @@
+package agent
+
+import (
+	"math"
+	"time"
+)
+
+// sendLimiter is a token bucket capping how often Run sends, independent
+// of the load-based resource gating. The bucket holds one token, so sends
+// are spaced at least 1/rate apart.
+type sendLimiter struct {
+	rate   float64 // tokens per second
+	tokens float64
+	last   time.Time
+}
+
+// newSendLimiter returns a limiter for perSecond sends, or nil (no limit)
+// when perSecond is not positive.
+func newSendLimiter(perSecond float64) *sendLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &sendLimiter{rate: perSecond, tokens: 1}
+}
+
+func (l *sendLimiter) refill(now time.Time) {
+	if !l.last.IsZero() {
+		l.tokens = math.Min(1, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+}
+
+// Delay returns how long until a token is available at now.
+func (l *sendLimiter) Delay(now time.Time) time.Duration {
+	l.refill(now)
+	if l.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+// Take consumes a token at now. The balance may go negative when a send
+// is forced through by the hard interval; later sends then wait longer.
+func (l *sendLimiter) Take(now time.Time) {
+	l.refill(now)
+	l.tokens--
+}
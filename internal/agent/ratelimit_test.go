@@ -0,0 +1,102 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSendLimiter(t *testing.T) {
+	if newSendLimiter(0) != nil {
+		t.Fatal("zero rate should mean no limiter")
+	}
+	l := newSendLimiter(4) // one token per 250ms
+	now := time.Unix(0, 0)
+	if d := l.Delay(now); d != 0 {
+		t.Fatalf("first Delay = %v, want 0", d)
+	}
+	l.Take(now)
+	if d := l.Delay(now.Add(100 * time.Millisecond)); d != 150*time.Millisecond {
+		t.Errorf("Delay after 100ms = %v, want 150ms", d)
+	}
+	if d := l.Delay(now.Add(time.Second)); d != 0 {
+		t.Errorf("Delay after 1s = %v, want 0", d)
+	}
+	// Idle time does not bank more than one send
+	l.Take(now.Add(10 * time.Second))
+	if d := l.Delay(now.Add(10 * time.Second)); d != 250*time.Millisecond {
+		t.Errorf("Delay after idle = %v, want 250ms", d)
+	}
+}
+
+func TestRun_MaxSendsPerSecond(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		times []time.Time
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == walFramesEndpoint {
+			mu.Lock()
+			times = append(times, time.Now())
+			mu.Unlock()
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	tmpDir := t.TempDir()
+	walDir := filepath.Join(tmpDir, "wal")
+	// One frame per segment, so every segment ends in a send at EOF
+	for seg := 1; seg <= 12; seg++ {
+		writeTestSegment(t, walDir, seg, fmt.Sprintf("f%d\n", seg))
+	}
+
+	const rate = 20.0
+	cfg := Config{
+		ServiceURL:        ts.URL,
+		WALDir:            walDir,
+		StateDir:          filepath.Join(tmpDir, "state"),
+		PollInterval:      time.Millisecond,
+		SendInterval:      time.Millisecond,
+		HardInterval:      time.Hour,
+		MaxSendsPerSecond: rate,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Run(ctx, cfg) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		n := len(times)
+		mu.Unlock()
+		if n >= 12 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(times) < 12 {
+		t.Fatalf("sends = %d, want 12", len(times))
+	}
+	// 12 sends with one token up front need at least 11 intervals
+	window := times[len(times)-1].Sub(times[0])
+	if min := time.Duration(11 / rate * float64(time.Second)); window < min {
+		t.Errorf("12 sends took %v, want at least %v at %v/s", window, min, rate)
+	}
+	for i := 1; i < len(times); i++ {
+		// Allow for scheduling jitter on the server side
+		if gap := times[i].Sub(times[i-1]); gap < 40*time.Millisecond {
+			t.Errorf("send %d followed the previous by %v, want about %v", i+1, gap, time.Duration(float64(time.Second)/rate))
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/bft-labs/walship/pkg/backoff"
+)
+
+// SendErrorEvent reports a failed batch upload. Attempt numbers the
+// failures of the current batch, starting at 1, and resets once a batch is
+// accepted. TotalRetries and TotalBackoff accumulate over the run,
+// including the wait that followed this failure, so a steady climb tells
+// constant flapping apart from one slow send.
+type SendErrorEvent struct {
+	Frames       int
+	Attempt      int
+	Err          error
+	TotalRetries int
+	TotalBackoff time.Duration
+}
+
+// sendState is the upload bookkeeping of one run. Unlike state it is never
+// persisted.
+type sendState struct {
+	// PendingKey is the idempotency key of the last batch whose upload
+	// timed out, and PendingAt when that happened, for
+	// Config.ProbeBeforeRetry.
+	PendingKey string
+	PendingAt  time.Time
+	// DNSFailures counts consecutive sends that failed to resolve the
+	// service host.
+	DNSFailures int
+	// SendAttempt counts failed uploads of the current batch, and
+	// TotalRetries and TotalBackoff all failed uploads and the time spent
+	// waiting after them, for Config.OnSendError.
+	SendAttempt  int
+	TotalRetries int
+	TotalBackoff time.Duration
+}
+
+// sendFailed counts a failed upload of a batch of frames and reports it to
+// cfg.OnSendError. Sends aborted because ctx was cancelled are not counted.
+func sendFailed(ctx context.Context, cfg Config, ss *sendState, frames int, err error) {
+	if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+		return
+	}
+	ss.SendAttempt++
+	ss.TotalRetries++
+	if cfg.OnSendError != nil {
+		cfg.OnSendError(SendErrorEvent{
+			Frames:       frames,
+			Attempt:      ss.SendAttempt,
+			Err:          err,
+			TotalRetries: ss.TotalRetries,
+			TotalBackoff: ss.TotalBackoff,
+		})
+	}
+}
+
+// retryWait waits the next backoff step before a failed batch is retried
+// and adds the time waited to ss.TotalBackoff.
+func retryWait(ctx context.Context, ss *sendState, back *backoff.Backoff) {
+	start := time.Now()
+	_ = back.Sleep(ctx)
+	ss.TotalBackoff += time.Since(start)
+}
@@ -0,0 +1,91 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bft-labs/walship/pkg/backoff"
+)
+
+func TestTrySend_RetryAccounting(t *testing.T) {
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Fail three times, accept, then fail once more
+		if n := calls.Add(1); n <= 3 || n == 5 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	var events []SendErrorEvent
+	cfg := Config{
+		ServiceURL:  ts.URL,
+		OnSendError: func(ev SendErrorEvent) { events = append(events, ev) },
+	}
+	st := state{}
+	var ss sendState
+	back := backoff.New(2*time.Millisecond, 2*time.Millisecond)
+	send := func() {
+		batch := []batchFrame{{Meta: FrameMeta{File: "seg-000001.wal.gz", Frame: 1, Len: 1}, Compressed: []byte("x"), IdxLineLen: 10}}
+		batchBytes := 1
+		for i := 0; i < 4; i++ {
+			if trySend(context.Background(), cfg, http.DefaultClient, &batch, &batchBytes, &st, &ss, "seg-000001.wal.idx", nil, false, back) == nil {
+				return
+			}
+		}
+	}
+	send()
+
+	if len(events) != 3 {
+		t.Fatalf("events = %d, want 3", len(events))
+	}
+	for i, ev := range events {
+		if ev.Attempt != i+1 || ev.TotalRetries != i+1 || ev.Frames != 1 || ev.Err == nil {
+			t.Errorf("event %d = %+v, want attempt and total %d", i, ev, i+1)
+		}
+		if ev.TotalBackoff < time.Duration(i+1)*2*time.Millisecond {
+			t.Errorf("event %d TotalBackoff = %v, want at least %v", i, ev.TotalBackoff, time.Duration(i+1)*2*time.Millisecond)
+		}
+	}
+	if ss.SendAttempt != 0 {
+		t.Errorf("SendAttempt after success = %d, want 0", ss.SendAttempt)
+	}
+
+	// A new batch starts its attempts over; the totals carry on
+	send()
+	last := events[len(events)-1]
+	if len(events) != 4 || last.Attempt != 1 || last.TotalRetries != 4 {
+		t.Errorf("after second batch: %d events, last %+v; want 4, attempt 1, total 4", len(events), last)
+	}
+	if last.TotalBackoff < events[2].TotalBackoff {
+		t.Errorf("TotalBackoff went down: %v < %v", last.TotalBackoff, events[2].TotalBackoff)
+	}
+}
+
+func TestTrySend_CancelledSendNotCounted(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	called := false
+	cfg := Config{ServiceURL: ts.URL, OnSendError: func(SendErrorEvent) { called = true }}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	batch := []batchFrame{{Meta: FrameMeta{File: "seg-000001.wal.gz", Frame: 1, Len: 1}, Compressed: []byte("x"), IdxLineLen: 10}}
+	batchBytes := 1
+	st := state{}
+	var ss sendState
+	if err := trySend(ctx, cfg, http.DefaultClient, &batch, &batchBytes, &st, &ss, "seg-000001.wal.idx", nil, false, newBackoff(time.Millisecond, time.Millisecond)); err == nil {
+		t.Fatal("trySend() on a cancelled context succeeded")
+	}
+	if called || ss.TotalRetries != 0 {
+		t.Errorf("cancelled send counted: called=%v retries=%d", called, ss.TotalRetries)
+	}
+}
@@ -0,0 +1,34 @@
+package agent
+
+// SegmentInfo describes one WAL segment on disk. Day is empty for segments
+// directly under the WAL directory rather than in a day directory.
+type SegmentInfo struct {
+	Day     string
+	Number  int
+	GzPath  string
+	IdxPath string // empty if the segment has no index yet
+	GzSize  int64
+	IdxSize int64
+}
+
+// ListSegments returns the segments under walDir in shipping order:
+// top-level segments first, then each day directory in date order, each by
+// segment number. Segments without a .wal.gz file are skipped.
+func ListSegments(walDir string) ([]SegmentInfo, error) {
+	segs, err := orderedSegments(walDir, "")
+	if err != nil {
+		return nil, err
+	}
+	out := make([]SegmentInfo, 0, len(segs))
+	for _, s := range segs {
+		out = append(out, SegmentInfo{
+			Day:     s.day,
+			Number:  s.num,
+			GzPath:  s.gzPath,
+			IdxPath: s.idxPath,
+			GzSize:  s.gzSize,
+			IdxSize: s.idxSize,
+		})
+	}
+	return out, nil
+}
@@ -0,0 +1,44 @@
+package agent
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestListSegments_MultiDay(t *testing.T) {
+	walDir := t.TempDir()
+	createSegment(t, walDir, "seg-000001", 5, 1)
+	createSegment(t, filepath.Join(walDir, "2024-01-02"), "seg-000010", 30, 3)
+	createSegment(t, filepath.Join(walDir, "2024-01-01"), "seg-000002", 20, 2)
+	createSegment(t, filepath.Join(walDir, "2024-01-01"), "seg-000001", 10, 1)
+
+	segs, err := ListSegments(walDir)
+	if err != nil {
+		t.Fatalf("ListSegments: %v", err)
+	}
+	want := []SegmentInfo{
+		{Day: "", Number: 1, GzSize: 5, IdxSize: 1},
+		{Day: "2024-01-01", Number: 1, GzSize: 10, IdxSize: 1},
+		{Day: "2024-01-01", Number: 2, GzSize: 20, IdxSize: 2},
+		{Day: "2024-01-02", Number: 10, GzSize: 30, IdxSize: 3},
+	}
+	if len(segs) != len(want) {
+		t.Fatalf("got %d segments, want %d: %+v", len(segs), len(want), segs)
+	}
+	for i, w := range want {
+		got := segs[i]
+		if got.Day != w.Day || got.Number != w.Number || got.GzSize != w.GzSize || got.IdxSize != w.IdxSize {
+			t.Errorf("segment %d = %+v, want %+v", i, got, w)
+		}
+		wantGz := filepath.Join(walDir, w.Day, filepath.Base(got.GzPath))
+		if got.GzPath != wantGz || filepath.Ext(got.IdxPath) != ".idx" {
+			t.Errorf("segment %d paths = %q, %q", i, got.GzPath, got.IdxPath)
+		}
+	}
+}
+
+func TestListSegments_MissingDir(t *testing.T) {
+	if _, err := ListSegments(filepath.Join(t.TempDir(), "nope")); err == nil {
+		t.Fatal("expected error for missing wal dir")
+	}
+}
@@ -0,0 +1,36 @@
+package agent
+
+import (
+	"bufio"
+	"io"
+	"os"
+)
+
+// OffsetDriftEvent reports that the index offset Run accounts for, the
+// committed IdxOffset plus the lines of the pending batch, no longer
+// matches where the index reader actually is. Drift means a restart would
+// resume at the wrong line, so it points at a bug in offset accounting.
+type OffsetDriftEvent struct {
+	IdxPath string
+	Tracked int64
+	Actual  int64
+}
+
+// readerOffset returns the position in idx of the next byte r will return:
+// the file offset less what r has buffered but not yet handed out.
+func readerOffset(idx *os.File, r *bufio.Reader) (int64, error) {
+	pos, err := idx.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	return pos - int64(r.Buffered()), nil
+}
+
+// trackedOffset is the index offset st and the pending batch account for.
+func trackedOffset(st state, batch []batchFrame) int64 {
+	off := st.IdxOffset
+	for _, bf := range batch {
+		off += int64(bf.IdxLineLen)
+	}
+	return off
+}
@@ -0,0 +1,105 @@
+package agent
+
+import (
+	"context"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReaderOffset_DetectsDrift(t *testing.T) {
+	dir := t.TempDir()
+	writeTestSegment(t, dir, 1, "a\n", "b\n", "c\n")
+	idxPath := filepath.Join(dir, "seg-000001.wal.idx")
+	idx, r, err := openIdx(idxPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+
+	var (
+		st    state
+		batch []batchFrame
+	)
+	// The first line is committed, the second is pending in the batch
+	_, line, err := nextFrame(r, idxPath, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.IdxOffset += int64(len(line))
+	_, line, err = nextFrame(r, idxPath, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch = append(batch, batchFrame{IdxLineLen: len(line)})
+	actual, err := readerOffset(idx, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tracked := trackedOffset(st, batch); tracked != actual {
+		t.Fatalf("tracked %d, reader at %d; want equal", tracked, actual)
+	}
+
+	// A buggy path reads the third line without accounting for it
+	if _, _, err := nextFrame(r, idxPath, false, false); err != nil {
+		t.Fatal(err)
+	}
+	actual, err = readerOffset(idx, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tracked := trackedOffset(st, batch); tracked == actual {
+		t.Fatalf("tracked %d matches reader after an unaccounted line", tracked)
+	}
+}
+
+func TestRun_SelfCheckNoDriftWhileBatching(t *testing.T) {
+	rec := &frameRecorder{}
+	ts := httptest.NewServer(rec)
+	defer ts.Close()
+
+	tmpDir := t.TempDir()
+	walDir := filepath.Join(tmpDir, "wal")
+	writeTestSegment(t, walDir, 1, "a1\n", "a2\n", "a3\n", "a4\n")
+	writeTestSegment(t, walDir, 2, "b1\n", "b2\n")
+
+	var (
+		mu     sync.Mutex
+		drifts []OffsetDriftEvent
+	)
+	cfg := Config{
+		ServiceURL:        ts.URL,
+		WALDir:            walDir,
+		StateDir:          filepath.Join(tmpDir, "state"),
+		PollInterval:      time.Millisecond,
+		SendInterval:      20 * time.Millisecond,
+		HardInterval:      20 * time.Millisecond,
+		SelfCheckInterval: time.Nanosecond,
+		FrameFilter:       func(fm FrameMeta) bool { return fm.Frame != 2 },
+		OnOffsetDrift: func(ev OffsetDriftEvent) {
+			mu.Lock()
+			drifts = append(drifts, ev)
+			mu.Unlock()
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Run(ctx, cfg) }()
+	deadline := time.Now().Add(5 * time.Second)
+	for rec.count() < 4 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if n := rec.count(); n != 4 {
+		t.Fatalf("shipped %d frames, want 4", n)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(drifts) != 0 {
+		t.Errorf("self-check reported drift on a healthy run: %+v", drifts)
+	}
+}
@@ -0,0 +1,150 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// shipAttempts is how many times ShipRange tries each batch before giving up.
+const shipAttempts = 3
+
+// ShipRange ships every frame of segments from..to (inclusive) of one day
+// directory under cfg.WALDir once, e.g. to re-ingest a known-bad window. It
+// keeps its position in memory only, so the state in cfg.StateDir is left
+// untouched. Segment numbers missing inside the range are skipped, but
+// both ends must exist. It returns the number of frames shipped.
+func ShipRange(ctx context.Context, cfg Config, day string, from, to int) (int, error) {
+	return shipRange(ctx, cfg, day, from, to, realClock{})
+}
+
+// shipRange is ShipRange with the clock that times its waits after a 429.
+func shipRange(ctx context.Context, cfg Config, day string, from, to int, clk clock) (int, error) {
+	if from <= 0 || to < from {
+		return 0, fmt.Errorf("invalid segment range %d..%d", from, to)
+	}
+	dayDir := filepath.Join(cfg.WALDir, day)
+	for _, n := range []int{from, to} {
+		p := filepath.Join(dayDir, fmt.Sprintf("seg-%06d.wal.idx", n))
+		if _, err := os.Stat(p); err != nil {
+			return 0, fmt.Errorf("segment range %s seg-%06d..seg-%06d: %w", day, from, to, err)
+		}
+	}
+
+	httpClient := newHTTPClient(cfg.HTTPTimeout, &cfg)
+	back := newConfigBackoff(cfg)
+	// trySend leaves 429s to the caller; there is no read loop to slow
+	// here, so the pacer's delay is waited out before the next attempt
+	pace := newPacer(minPaceDelay, maxPaceDelay)
+	var (
+		batch      []batchFrame
+		batchBytes int
+		st         state
+		ss         sendState
+		shipped    int
+	)
+	send := func(idxBase string) error {
+		var err error
+		for i := 0; i < shipAttempts && len(batch) > 0; i++ {
+			if err = ctx.Err(); err != nil {
+				return err
+			}
+			n := len(batch)
+			// A hard send skips resource gating
+			err = trySend(ctx, cfg, httpClient, &batch, &batchBytes, &st, &ss, idxBase, nil, true, back)
+			if err == nil {
+				shipped += n
+			} else if errors.Is(err, ErrUnauthorized) {
+				return err
+			}
+			pace.Observe(err)
+			if d := pace.SuggestDelay(); d > 0 && i+1 < shipAttempts {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-clk.After(d):
+				}
+			}
+		}
+		return err
+	}
+
+	for n := from; n <= to; n++ {
+		idxPath := filepath.Join(dayDir, fmt.Sprintf("seg-%06d.wal.idx", n))
+		if _, err := os.Stat(idxPath); errors.Is(err, os.ErrNotExist) {
+			logger.Warn().Str("index", idxPath).Msg("segment missing from range; skipped")
+			continue
+		}
+		// Lets trySend key batches by day; st is not saved
+		st.IdxPath = idxPath
+		if err := shipSegment(cfg, idxPath, func(bf batchFrame) error {
+			if cfg.MaxBatchBytes > 0 && len(batch) > 0 && batchBytes+len(bf.Compressed) > cfg.MaxBatchBytes {
+				if err := send(filepath.Base(idxPath)); err != nil {
+					return err
+				}
+			}
+			batch = append(batch, bf)
+			batchBytes += len(bf.Compressed)
+			return nil
+		}); err != nil {
+			return shipped, err
+		}
+		if err := send(filepath.Base(idxPath)); err != nil {
+			return shipped, err
+		}
+	}
+	return shipped, nil
+}
+
+// shipSegment reads every complete frame indexed by idxPath and passes it
+// to add, skipping the frames Run would.
+func shipSegment(cfg Config, idxPath string, add func(batchFrame) error) error {
+	idx, r, err := openIdx(idxPath)
+	if err != nil {
+		return err
+	}
+	defer idx.Close()
+	var gz *os.File
+	defer func() {
+		if gz != nil {
+			gz.Close()
+		}
+	}()
+	skew := tsSanitizer{enabled: cfg.SanitizeTimestamps}
+	for {
+		fm, line, err := nextFrame(r, idxPath, cfg.LenientIndex, cfg.StrictIndexFields)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		checkTimestamps(cfg, &skew, &fm)
+		if skipFrame(cfg, fm) {
+			continue
+		}
+		if gz == nil || filepath.Base(gz.Name()) != fm.File {
+			if gz != nil {
+				gz.Close()
+			}
+			if gz, err = openGz(filepath.Join(filepath.Dir(idxPath), fm.File)); err != nil {
+				return err
+			}
+		}
+		b, err := preadSection(gz, int64(fm.Off), int64(fm.Len))
+		if err != nil {
+			return fmt.Errorf("read %s frame %d: %w", fm.File, fm.Frame, err)
+		}
+		if cfg.SendUncompressed {
+			if b, err = gunzipFrame(b); err != nil {
+				return fmt.Errorf("decompress %s frame %d: %w", fm.File, fm.Frame, err)
+			}
+		}
+		if err := add(batchFrame{Meta: fm, Compressed: b, IdxLineLen: len(line)}); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestShipRange(t *testing.T) {
+	rec := &frameRecorder{}
+	ts := httptest.NewServer(rec)
+	defer ts.Close()
+
+	tmpDir := t.TempDir()
+	walDir := filepath.Join(tmpDir, "wal")
+	day := filepath.Join(walDir, "2025-01-02")
+	writeTestSegment(t, day, 1, "a1\n", "a2\n")
+	writeTestSegment(t, day, 2, "b1\n", "b2\n")
+	writeTestSegment(t, day, 3, "c1\n")
+	writeTestSegment(t, day, 5, "e1\n") // seg 4 missing
+	writeTestSegment(t, day, 6, "f1\n")
+
+	cfg := DefaultConfig()
+	cfg.ServiceURL = ts.URL
+	cfg.WALDir = walDir
+	cfg.StateDir = filepath.Join(tmpDir, "state")
+
+	n, err := ShipRange(context.Background(), cfg, "2025-01-02", 2, 5)
+	if err != nil {
+		t.Fatalf("ShipRange() error = %v", err)
+	}
+	if n != 4 {
+		t.Errorf("ShipRange() = %d frames, want 4", n)
+	}
+	rec.mu.Lock()
+	var got []string
+	for _, fm := range rec.frames {
+		got = append(got, fm.File)
+	}
+	rec.mu.Unlock()
+	want := []string{"seg-000002.wal.gz", "seg-000002.wal.gz", "seg-000003.wal.gz", "seg-000005.wal.gz"}
+	if len(got) != len(want) {
+		t.Fatalf("shipped %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("shipped %v, want %v", got, want)
+		}
+	}
+	if _, err := os.Stat(stateFile(cfg.StateDir)); !os.IsNotExist(err) {
+		t.Errorf("ShipRange wrote streaming state (stat err %v)", err)
+	}
+}
+
+func TestShipRange_MissingRange(t *testing.T) {
+	walDir := t.TempDir()
+	writeTestSegment(t, filepath.Join(walDir, "2025-01-02"), 1, "a\n")
+	cfg := Config{ServiceURL: "http://127.0.0.1:0", WALDir: walDir}
+
+	tests := []struct {
+		name     string
+		day      string
+		from, to int
+	}{
+		{"missing day", "2025-01-03", 1, 1},
+		{"missing end", "2025-01-02", 1, 2},
+		{"zero start", "2025-01-02", 0, 1},
+		{"reversed", "2025-01-02", 2, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ShipRange(context.Background(), cfg, tt.day, tt.from, tt.to); err == nil {
+				t.Error("ShipRange() succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestShipRange_RateLimited(t *testing.T) {
+	tests := []struct {
+		name       string
+		retryAfter string
+		want       []time.Duration // spacing between successive attempts
+	}{
+		{"retry-after", "3", []time.Duration{3 * time.Second, 3 * time.Second}},
+		{"no retry-after", "", []time.Duration{time.Second, 2 * time.Second}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := newFakeClock()
+			var (
+				mu       sync.Mutex
+				attempts []time.Time
+			)
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				attempts = append(attempts, clk.Now())
+				mu.Unlock()
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(http.StatusTooManyRequests)
+			}))
+			defer ts.Close()
+
+			walDir := t.TempDir()
+			writeTestSegment(t, filepath.Join(walDir, "2025-01-02"), 1, "a\n")
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go advancePendingWaits(ctx, clk)
+
+			cfg := Config{ServiceURL: ts.URL, WALDir: walDir, BackoffInitial: time.Millisecond, BackoffMax: time.Millisecond}
+			if _, err := shipRange(ctx, cfg, "2025-01-02", 1, 1, clk); !errors.Is(err, ErrRateLimited) {
+				t.Fatalf("shipRange() error = %v, want ErrRateLimited", err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			var got []time.Duration
+			for i := 1; i < len(attempts); i++ {
+				got = append(got, attempts[i].Sub(attempts[i-1]))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("attempt spacing = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// stateSnapshotVersion is the format version written by ExportState.
+// Bump it when a change to state would be misread by an older agent.
+const stateSnapshotVersion = 1
+
+// ErrSnapshotVersion is matched (via errors.Is) by the error ImportState
+// returns for a snapshot written in an unsupported format.
+var ErrSnapshotVersion = errors.New("unsupported state snapshot version")
+
+type stateSnapshot struct {
+	Version int   `json:"version"`
+	State   state `json:"state"`
+}
+
+// ExportState returns the read position saved in stateDir as a versioned
+// snapshot, for moving an agent to another machine with ImportState.
+// Export from a stopped agent: a running one may be ahead of its state file.
+func ExportState(stateDir string) ([]byte, error) {
+	st, err := loadState(stateDir)
+	if err != nil {
+		return nil, fmt.Errorf("load state: %w", err)
+	}
+	return json.MarshalIndent(stateSnapshot{Version: stateSnapshotVersion, State: st}, "", "  ")
+}
+
+// ImportState writes a snapshot produced by ExportState to stateDir,
+// replacing any saved position there. The agent using stateDir must not be
+// running.
+func ImportState(stateDir string, data []byte) error {
+	var snap stateSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("decode state snapshot: %w", err)
+	}
+	if snap.Version != stateSnapshotVersion {
+		return fmt.Errorf("%w: %d (want %d)", ErrSnapshotVersion, snap.Version, stateSnapshotVersion)
+	}
+	if snap.State.IdxPath == "" {
+		return errors.New("state snapshot has no index path")
+	}
+	return saveState(stateDir, snap.State)
+}
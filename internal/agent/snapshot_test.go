@@ -0,0 +1,73 @@
+package agent
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStateSnapshot_RoundTrip(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	want := state{
+		IdxPath:      "/wal/2026-01-01/seg-000003.wal.idx",
+		IdxOffset:    4096,
+		CurGz:        "seg-000003.wal.gz",
+		LastFile:     "seg-000003.wal.gz",
+		LastFrame:    42,
+		LastCommitAt: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC),
+		LastSendAt:   time.Date(2026, 1, 1, 12, 0, 1, 0, time.UTC),
+		DirIndex:     1,
+	}
+	if err := saveState(src, want); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ExportState(src)
+	if err != nil {
+		t.Fatalf("ExportState() error = %v", err)
+	}
+	if err := ImportState(dst, data); err != nil {
+		t.Fatalf("ImportState() error = %v", err)
+	}
+	got, err := loadState(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("imported state = %+v, want %+v", got, want)
+	}
+}
+
+func TestImportState_Rejects(t *testing.T) {
+	tests := map[string]struct {
+		data    string
+		version bool
+	}{
+		"future version": {`{"version":2,"state":{"idx_path":"/x.idx"}}`, true},
+		"no version":     {`{"state":{"idx_path":"/x.idx"}}`, true},
+		"no index":       {`{"version":1,"state":{}}`, false},
+		"not json":       {`status`, false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			err := ImportState(dir, []byte(tt.data))
+			if err == nil {
+				t.Fatal("ImportState() succeeded, want error")
+			}
+			if got := errors.Is(err, ErrSnapshotVersion); got != tt.version {
+				t.Errorf("errors.Is(err, ErrSnapshotVersion) = %v, want %v (err: %v)", got, tt.version, err)
+			}
+			if _, err := os.Stat(stateFile(dir)); !os.IsNotExist(err) {
+				t.Error("rejected snapshot was written")
+			}
+		})
+	}
+}
+
+func TestExportState_NoState(t *testing.T) {
+	if _, err := ExportState(t.TempDir()); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("ExportState() error = %v, want not exist", err)
+	}
+}
@@ -0,0 +1,139 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// sourceRetryMax caps the wait between checks for a WAL directory that has
+// disappeared.
+const sourceRetryMax = 30 * time.Second
+
+// SourceUnavailableEvent reports that the WAL directory being shipped no
+// longer exists, e.g. because the node was reconfigured and the directory
+// moved. Run keeps checking for it and resumes once it is back.
+type SourceUnavailableEvent struct {
+	Dir string
+	Err error
+}
+
+// sourceState tracks whether the current WAL directory is missing and how
+// long to wait before checking again.
+type sourceState struct {
+	poll    time.Duration
+	wait    time.Duration
+	missing bool
+}
+
+func newSourceState(poll time.Duration) *sourceState {
+	return &sourceState{poll: poll}
+}
+
+// Check stats dir and reports whether it has disappeared. The first miss
+// after the directory was present returns first == true.
+func (s *sourceState) Check(dir string) (missing, first bool, err error) {
+	_, err = os.Stat(dir)
+	if !errors.Is(err, fs.ErrNotExist) {
+		return false, false, nil
+	}
+	first = !s.missing
+	s.missing = true
+	return true, first, err
+}
+
+// Missing reports whether the directory was missing at the last Check.
+func (s *sourceState) Missing() bool { return s.missing }
+
+// Recovered clears the missing state once the directory is readable again.
+func (s *sourceState) Recovered() {
+	s.missing = false
+	s.wait = 0
+}
+
+// Next returns how long to wait before checking again: starting at the
+// poll interval and doubling up to sourceRetryMax, since a moved directory
+// rarely comes back within a poll or two.
+func (s *sourceState) Next() time.Duration {
+	if s.wait <= 0 {
+		s.wait = s.poll
+	} else {
+		s.wait *= 2
+	}
+	if s.wait > sourceRetryMax {
+		s.wait = sourceRetryMax
+	}
+	if s.wait <= 0 {
+		s.wait = sourceRetryMax
+	}
+	return s.wait
+}
+
+// resumeIndex returns where to continue after the WAL directory comes
+// back: the saved index if it is still there and at least as long as the
+// saved offset, otherwise the oldest index in dirs[dirIdx:] from minDay.
+func resumeIndex(st state, dirs []string, dirIdx int, minDay string) (string, int64, int, error) {
+	if fi, err := os.Stat(st.IdxPath); err == nil && fi.Size() >= st.IdxOffset {
+		return st.IdxPath, st.IdxOffset, dirIdx, nil
+	}
+	p, i, err := firstIndexFrom(dirs, dirIdx, minDay)
+	return p, 0, i, err
+}
+
+// Values for Config.OnMissingState.
+const (
+	// MissingStateFail makes Run return an error (the default).
+	MissingStateFail = "fail"
+	// MissingStateOldest restarts from the oldest index still on disk.
+	MissingStateOldest = "oldest"
+	// MissingStateLatest skips ahead to the start of the newest index.
+	MissingStateLatest = "latest"
+)
+
+// StateRecoveredEvent reports that the saved position named an index that
+// no longer exists, e.g. after external pruning, and Run moved to the start
+// of Resumed under Policy.
+type StateRecoveredEvent struct {
+	Missing string
+	Resumed string
+	Policy  string
+}
+
+// recoverMissingState returns the position to start from when st.IdxPath
+// has been deleted, according to cfg.OnMissingState.
+func recoverMissingState(cfg Config, dirs []string, st state) (state, error) {
+	missing := st.IdxPath
+	var (
+		p   string
+		i   int
+		err error
+	)
+	switch cfg.OnMissingState {
+	case MissingStateOldest:
+		p, i, err = firstIndexFrom(dirs, 0, cfg.MinDay)
+	case MissingStateLatest:
+		err = fs.ErrNotExist
+		for i = len(dirs) - 1; i >= 0; i-- {
+			if p, err = latestIndex(dirs[i]); err == nil {
+				break
+			}
+		}
+	default:
+		return st, fmt.Errorf("saved index %s no longer exists; set on_missing_state to recover", missing)
+	}
+	if err != nil {
+		return st, fmt.Errorf("recover from missing index %s: %w", missing, err)
+	}
+	st.IdxPath, st.IdxOffset, st.CurGz, st.DirIndex = p, 0, "", i
+	logger.Error().
+		Str("missing", missing).
+		Str("resumed", p).
+		Str("policy", cfg.OnMissingState).
+		Msg("saved index no longer exists; moved read position")
+	if cfg.OnStateRecovered != nil {
+		cfg.OnStateRecovered(StateRecoveredEvent{Missing: missing, Resumed: p, Policy: cfg.OnMissingState})
+	}
+	return st, nil
+}
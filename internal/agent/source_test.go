@@ -0,0 +1,235 @@
+package agent
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSourceState_Backoff(t *testing.T) {
+	s := newSourceState(10 * time.Second)
+	want := []time.Duration{10 * time.Second, 20 * time.Second, sourceRetryMax, sourceRetryMax}
+	for i, w := range want {
+		if d := s.Next(); d != w {
+			t.Errorf("Next() #%d = %v, want %v", i+1, d, w)
+		}
+	}
+	s.Recovered()
+	if d := s.Next(); d != 10*time.Second {
+		t.Errorf("Next() after recovery = %v, want the poll interval", d)
+	}
+
+	dir := t.TempDir()
+	if missing, _, _ := s.Check(dir); missing {
+		t.Error("existing directory reported missing")
+	}
+	gone := filepath.Join(dir, "gone")
+	if missing, first, err := s.Check(gone); !missing || !first || err == nil {
+		t.Errorf("Check(missing) = %v, %v, %v; want missing, first, error", missing, first, err)
+	}
+	if _, first, _ := s.Check(gone); first {
+		t.Error("second miss reported as first")
+	}
+}
+
+func TestRun_WALDirDisappears(t *testing.T) {
+	tests := []struct {
+		name    string
+		restore func(t *testing.T, walDir, moved string)
+		want    int // frames shipped in total
+	}{
+		{
+			// Moved back with a new segment: resume where we left off
+			name: "moved back",
+			restore: func(t *testing.T, walDir, moved string) {
+				if err := os.Rename(moved, walDir); err != nil {
+					t.Fatal(err)
+				}
+				writeTestSegment(t, filepath.Join(walDir, "2026-01-01"), 2, "f2\n")
+			},
+			want: 2,
+		},
+		{
+			// Recreated from scratch: start over from the oldest index
+			name: "recreated",
+			restore: func(t *testing.T, walDir, moved string) {
+				writeTestSegment(t, filepath.Join(walDir, "2026-02-01"), 1, "g1\n", "g2\n")
+			},
+			want: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &frameRecorder{}
+			ts := httptest.NewServer(rec)
+			defer ts.Close()
+
+			root := t.TempDir()
+			walDir := filepath.Join(root, "wal")
+			writeTestSegment(t, filepath.Join(walDir, "2026-01-01"), 1, "f1\n")
+
+			unavailable := make(chan SourceUnavailableEvent, 4)
+			cfg := Config{
+				ServiceURL:   ts.URL,
+				WALDir:       walDir,
+				StateDir:     filepath.Join(root, "state"),
+				PollInterval: 10 * time.Millisecond,
+				SendInterval: time.Millisecond,
+				HardInterval: time.Millisecond,
+				OnSourceUnavailable: func(ev SourceUnavailableEvent) {
+					unavailable <- ev
+				},
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 1)
+			go func() { done <- Run(ctx, cfg) }()
+			defer func() {
+				cancel()
+				<-done
+			}()
+
+			waitFor := func(n int) {
+				t.Helper()
+				deadline := time.Now().Add(5 * time.Second)
+				for rec.count() < n {
+					if time.Now().After(deadline) {
+						t.Fatalf("shipped %d frames, want %d", rec.count(), n)
+					}
+					time.Sleep(5 * time.Millisecond)
+				}
+			}
+			waitFor(1)
+
+			moved := filepath.Join(root, "moved")
+			if err := os.Rename(walDir, moved); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case ev := <-unavailable:
+				if ev.Dir != walDir || !os.IsNotExist(ev.Err) {
+					t.Errorf("event = %+v, want %s not found", ev, walDir)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("OnSourceUnavailable not called")
+			}
+
+			tt.restore(t, walDir, moved)
+			waitFor(tt.want)
+			time.Sleep(50 * time.Millisecond)
+			if n := rec.count(); n != tt.want {
+				t.Errorf("shipped %d frames, want %d", n, tt.want)
+			}
+			if len(unavailable) != 0 {
+				t.Error("OnSourceUnavailable called more than once for one outage")
+			}
+		})
+	}
+}
+
+func TestRun_OnMissingState(t *testing.T) {
+	for _, tc := range []struct {
+		policy  string
+		wantErr bool
+		resumed string
+		frames  int
+	}{
+		{policy: "", wantErr: true},
+		{policy: MissingStateFail, wantErr: true},
+		{policy: MissingStateOldest, resumed: "seg-000002.wal.idx", frames: 3},
+		{policy: MissingStateLatest, resumed: "seg-000003.wal.idx", frames: 1},
+	} {
+		t.Run("policy="+tc.policy, func(t *testing.T) {
+			rec := &frameRecorder{}
+			ts := httptest.NewServer(rec)
+			defer ts.Close()
+
+			tmpDir := t.TempDir()
+			walDir := filepath.Join(tmpDir, "wal")
+			writeTestSegment(t, walDir, 1, "a1\n")
+			writeTestSegment(t, walDir, 2, "b1\n", "b2\n")
+			writeTestSegment(t, walDir, 3, "c1\n")
+			stateDir := filepath.Join(tmpDir, "state")
+			pruned := filepath.Join(walDir, "seg-000001.wal.idx")
+			if err := saveState(stateDir, state{IdxPath: pruned, IdxOffset: 10, CurGz: "seg-000001.wal.gz"}); err != nil {
+				t.Fatal(err)
+			}
+			for _, ext := range []string{".idx", ".gz"} {
+				if err := os.Remove(filepath.Join(walDir, "seg-000001.wal"+ext)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var events []StateRecoveredEvent
+			cfg := Config{
+				ServiceURL:       ts.URL,
+				WALDir:           walDir,
+				StateDir:         stateDir,
+				PollInterval:     time.Millisecond,
+				SendInterval:     time.Hour,
+				HardInterval:     time.Hour,
+				OnMissingState:   tc.policy,
+				OnStateRecovered: func(ev StateRecoveredEvent) { events = append(events, ev) },
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			err := Drain(ctx, cfg)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("Drain() error = nil, want missing index error")
+				}
+				if st, _ := loadState(stateDir); st.IdxPath != pruned {
+					t.Errorf("state moved to %s under fail policy", st.IdxPath)
+				}
+				if len(events) != 0 {
+					t.Errorf("events = %+v, want none", events)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Drain() error = %v", err)
+			}
+			want := filepath.Join(walDir, tc.resumed)
+			if len(events) != 1 || events[0].Missing != pruned || events[0].Resumed != want || events[0].Policy != tc.policy {
+				t.Errorf("events = %+v, want one %s -> %s", events, pruned, want)
+			}
+			if got := rec.count(); got != tc.frames {
+				t.Errorf("shipped %d frames, want %d", got, tc.frames)
+			}
+		})
+	}
+}
+
+func TestRecoverMissingState_Persists(t *testing.T) {
+	tmpDir := t.TempDir()
+	walDir := filepath.Join(tmpDir, "wal")
+	writeTestSegment(t, walDir, 2, "b1\n")
+	stateDir := filepath.Join(tmpDir, "state")
+	if err := saveState(stateDir, state{IdxPath: filepath.Join(walDir, "seg-000001.wal.idx"), IdxOffset: 10}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unreachable service: the new position must be saved before any send
+	cfg := Config{
+		ServiceURL:     "http://127.0.0.1:1",
+		WALDir:         walDir,
+		StateDir:       stateDir,
+		PollInterval:   time.Millisecond,
+		SendInterval:   time.Hour,
+		HardInterval:   time.Hour,
+		OnMissingState: MissingStateOldest,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_ = Run(ctx, cfg)
+
+	st, err := loadState(stateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(walDir, "seg-000002.wal.idx"); st.IdxPath != want || st.IdxOffset != 0 {
+		t.Errorf("state = %s@%d, want %s@0", st.IdxPath, st.IdxOffset, want)
+	}
+}
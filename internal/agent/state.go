@@ -15,6 +15,8 @@ type state struct {
 	LastFrame    uint64    `json:"last_frame"`
 	LastCommitAt time.Time `json:"last_commit_at"`
 	LastSendAt   time.Time `json:"last_send_at"`
+	// DirIndex is the position of IdxPath's directory in Config.walDirs.
+	DirIndex int `json:"dir_index,omitempty"`
 }
 
 func stateFile(dir string) string {
@@ -48,3 +50,55 @@ func saveState(dir string, st state) error {
 	}
 	return os.Rename(tmp, stateFile(dir))
 }
+
+// stateSaver coalesces status.json writes. The latest committed state is
+// kept in memory and written at most once per interval, so a busy chain
+// that sends many times per second does not rewrite the file on every
+// send. A non-positive interval writes on every Save.
+type stateSaver struct {
+	dir      string
+	interval time.Duration
+	save     func(dir string, st state) error
+	clock    clock
+
+	cur       state
+	dirty     bool
+	lastWrite time.Time
+}
+
+func newStateSaver(dir string, interval time.Duration) *stateSaver {
+	return &stateSaver{dir: dir, interval: interval, save: saveState, clock: realClock{}}
+}
+
+// Save records st as the latest committed state and writes it to disk if
+// the save interval has elapsed since the last write.
+func (s *stateSaver) Save(st state) {
+	s.cur = st
+	s.dirty = true
+	s.MaybeFlush()
+}
+
+// MaybeFlush writes pending state if the save interval has elapsed.
+func (s *stateSaver) MaybeFlush() {
+	if !s.dirty || s.clock.Now().Sub(s.lastWrite) < s.interval {
+		return
+	}
+	_ = s.Flush()
+}
+
+// Flush writes pending state to disk immediately.
+func (s *stateSaver) Flush() error {
+	if !s.dirty {
+		return nil
+	}
+	if err := s.save(s.dir, s.cur); err != nil {
+		return err
+	}
+	s.dirty = false
+	s.lastWrite = s.clock.Now()
+	return nil
+}
+
+// Current returns the latest committed state, whether or not it has been
+// written to disk yet.
+func (s *stateSaver) Current() state { return s.cur }
@@ -1,10 +1,13 @@
 package agent
 
 import (
+	"context"
 	"encoding/json"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestStateRoundTrip(t *testing.T) {
@@ -36,3 +39,161 @@ func TestStateRoundTrip(t *testing.T) {
 		t.Fatalf("expected idx path %s, got %s", expected.IdxPath, st.IdxPath)
 	}
 }
+
+func TestStateSaver_CoalescesWrites(t *testing.T) {
+	dir := t.TempDir()
+	interval := 50 * time.Millisecond
+	saver := newStateSaver(dir, interval)
+	writes := 0
+	saver.save = func(dir string, st state) error {
+		writes++
+		return saveState(dir, st)
+	}
+
+	// Commit roughly every millisecond for ~5 intervals.
+	start := time.Now()
+	var sends int64
+	for time.Since(start) < 5*interval {
+		sends++
+		saver.Save(state{IdxPath: "seg.idx", IdxOffset: sends})
+		if got := saver.Current().IdxOffset; got != sends {
+			t.Fatalf("in-memory offset = %d, want %d", got, sends)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	elapsed := time.Since(start)
+
+	maxWrites := int(elapsed/interval) + 1
+	if writes > maxWrites {
+		t.Fatalf("writes = %d for %d sends, want at most %d", writes, sends, maxWrites)
+	}
+	if writes == 0 {
+		t.Fatal("expected at least one write")
+	}
+
+	// Disk lags memory until flushed, but never by more than one interval.
+	onDisk, err := loadState(dir)
+	if err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	if onDisk.IdxOffset > sends {
+		t.Fatalf("disk offset %d ahead of memory %d", onDisk.IdxOffset, sends)
+	}
+
+	if err := saver.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	onDisk, err = loadState(dir)
+	if err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	if onDisk.IdxOffset != sends {
+		t.Fatalf("disk offset after flush = %d, want %d", onDisk.IdxOffset, sends)
+	}
+}
+
+func TestStateSaver_ZeroIntervalWritesEverySave(t *testing.T) {
+	saver := newStateSaver(t.TempDir(), 0)
+	writes := 0
+	saver.save = func(string, state) error {
+		writes++
+		return nil
+	}
+	for i := 0; i < 10; i++ {
+		saver.Save(state{IdxOffset: int64(i)})
+	}
+	if writes != 10 {
+		t.Fatalf("writes = %d, want 10", writes)
+	}
+	if err := saver.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if writes != 10 {
+		t.Fatalf("Flush with nothing pending wrote; writes = %d", writes)
+	}
+}
+
+func TestRun_StateSavedAtEOFAndShutdown(t *testing.T) {
+	rec := &frameRecorder{}
+	ts := httptest.NewServer(rec)
+	defer ts.Close()
+
+	walDir := filepath.Join(t.TempDir(), "wal")
+	day := filepath.Join(walDir, "2026-01-01")
+	writeTestSegment(t, day, 1, "f1\n", "f2\n")
+	stateDir := filepath.Join(t.TempDir(), "state")
+
+	cfg := Config{
+		ServiceURL:   ts.URL,
+		WALDir:       walDir,
+		StateDir:     stateDir,
+		PollInterval: 10 * time.Millisecond,
+		SendInterval: time.Millisecond,
+		HardInterval: time.Millisecond,
+		// Throttled far beyond the test, so only EOF and shutdown write
+		StateSaveInterval: time.Hour,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Run(ctx, cfg) }()
+
+	waitOffset := func(idxPath string) {
+		t.Helper()
+		fi, err := os.Stat(idxPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := fi.Size()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			st, _ := loadState(stateDir)
+			if st.IdxPath == idxPath && st.IdxOffset == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("state = %s@%d, want %s@%d", st.IdxPath, st.IdxOffset, idxPath, want)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	// Caught up while running
+	waitOffset(filepath.Join(day, "seg-000001.wal.idx"))
+
+	// Progress shipped just before shutdown is on disk once Run returns
+	writeTestSegment(t, day, 2, "f3\n")
+	deadline := time.Now().Add(5 * time.Second)
+	for rec.count() < 3 {
+		if time.Now().After(deadline) {
+			cancel()
+			t.Fatalf("frames shipped = %d, want 3", rec.count())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	waitOffset(filepath.Join(day, "seg-000002.wal.idx"))
+}
+
+func TestStateSaver_FakeClock(t *testing.T) {
+	clk := newFakeClock()
+	saver := newStateSaver(t.TempDir(), time.Minute)
+	saver.clock = clk
+	writes := 0
+	saver.save = func(dir string, st state) error {
+		writes++
+		return nil
+	}
+
+	saver.Save(state{IdxOffset: 1})
+	saver.Save(state{IdxOffset: 2})
+	clk.Advance(59 * time.Second)
+	saver.Save(state{IdxOffset: 3})
+	if writes != 1 {
+		t.Fatalf("writes within the interval = %d, want 1", writes)
+	}
+	clk.Advance(time.Second)
+	saver.MaybeFlush()
+	if writes != 2 {
+		t.Fatalf("writes after the interval = %d, want 2", writes)
+	}
+}
@@ -0,0 +1,106 @@
+package agent
+
+import "time"
+
+// Reasons a frame's timestamps are reported by TimestampSkewEvent.
+const (
+	TimestampZero      = "zero"      // FirstTS or LastTS is unset
+	TimestampFuture    = "future"    // a timestamp is after the agent's clock
+	TimestampBackwards = "backwards" // earlier than the previous frame, or LastTS before FirstTS
+)
+
+// skewLogEvery is how many skewed frames share one log line.
+const skewLogEvery = 1000
+
+// TimestampSkewEvent reports a frame whose timestamps are zero, in the
+// future or not monotonic, e.g. because the node's clock is off. Frame is
+// as read from the index. Sanitized is set when the timestamps were
+// normalized under Config.SanitizeTimestamps. Count is the number of such
+// frames seen so far by this run.
+type TimestampSkewEvent struct {
+	Frame     FrameMeta
+	Reason    string
+	Sanitized bool
+	Count     int
+}
+
+// tsSanitizer checks each frame's timestamps against the previous frame
+// and the clock.
+type tsSanitizer struct {
+	enabled bool
+	last    int64 // LastTS of the previous frame
+	count   int
+}
+
+// check reports the first problem with fm's timestamps, or "". When
+// enabled it also normalizes them: zero and backwards values are clamped
+// to the previous frame's, future values to now.
+func (s *tsSanitizer) check(fm *FrameMeta, now time.Time) string {
+	n := now.UnixNano()
+	first, last := fm.FirstTS, fm.LastTS
+	var reason string
+	switch {
+	case first == 0:
+		reason, first = TimestampZero, s.last
+	case first > n:
+		reason, first = TimestampFuture, n
+	case first < s.last:
+		reason, first = TimestampBackwards, s.last
+	}
+	if first == 0 {
+		// No previous frame to fall back on
+		first = n
+	}
+	switch {
+	case last == 0:
+		if reason == "" {
+			reason = TimestampZero
+		}
+		last = first
+	case last > n:
+		if reason == "" {
+			reason = TimestampFuture
+		}
+		last = n
+	case last < first:
+		if reason == "" {
+			reason = TimestampBackwards
+		}
+		last = first
+	}
+	if reason == "" {
+		s.last = fm.LastTS
+		return ""
+	}
+	s.count++
+	if s.enabled {
+		fm.FirstTS, fm.LastTS = first, last
+	}
+	// Compare the next frame with the normalized value either way, so one
+	// bad frame is not followed by a run of "backwards" ones
+	s.last = last
+	return reason
+}
+
+// checkTimestamps runs fm through s and reports a problem via log and
+// cfg.OnTimestampSkew.
+func checkTimestamps(cfg Config, s *tsSanitizer, fm *FrameMeta) {
+	orig := *fm
+	reason := s.check(fm, time.Now())
+	if reason == "" {
+		return
+	}
+	if (s.count-1)%skewLogEvery == 0 {
+		logger.Warn().
+			Str("file", orig.File).
+			Uint64("frame", orig.Frame).
+			Int64("first_ts", orig.FirstTS).
+			Int64("last_ts", orig.LastTS).
+			Str("reason", reason).
+			Int("count", s.count).
+			Msg("frame timestamps skewed")
+	}
+	if cfg.OnTimestampSkew != nil {
+		cfg.OnTimestampSkew(TimestampSkewEvent{Frame: orig, Reason: reason, Sanitized: s.enabled, Count: s.count})
+	}
+}
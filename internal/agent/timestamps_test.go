@@ -0,0 +1,84 @@
+package agent
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTsSanitizer_Check(t *testing.T) {
+	now := time.Unix(1000, 0)
+	n := now.UnixNano()
+	sec := int64(time.Second)
+	frames := []struct {
+		first, last int64
+		reason      string
+		wantFirst   int64
+		wantLast    int64
+	}{
+		{first: n - 10*sec, last: n - 9*sec, wantFirst: n - 10*sec, wantLast: n - 9*sec},
+		// Earlier than the previous frame
+		{first: n - 20*sec, last: n - 8*sec, reason: TimestampBackwards, wantFirst: n - 9*sec, wantLast: n - 8*sec},
+		// Ahead of the clock
+		{first: n + 60*sec, last: n + 61*sec, reason: TimestampFuture, wantFirst: n, wantLast: n},
+		// In order again relative to the clamped previous frame
+		{first: n, last: n, wantFirst: n, wantLast: n},
+		// Missing
+		{first: 0, last: 0, reason: TimestampZero, wantFirst: n, wantLast: n},
+		// LastTS before FirstTS
+		{first: n, last: n - sec, reason: TimestampBackwards, wantFirst: n, wantLast: n},
+	}
+
+	for _, enabled := range []bool{true, false} {
+		s := tsSanitizer{enabled: enabled}
+		flagged := 0
+		for i, f := range frames {
+			fm := FrameMeta{Frame: uint64(i + 1), FirstTS: f.first, LastTS: f.last}
+			if got := s.check(&fm, now); got != f.reason {
+				t.Errorf("enabled=%v frame %d reason = %q, want %q", enabled, i, got, f.reason)
+			}
+			if f.reason != "" {
+				flagged++
+			}
+			wantFirst, wantLast := f.first, f.last
+			if enabled {
+				wantFirst, wantLast = f.wantFirst, f.wantLast
+			}
+			if fm.FirstTS != wantFirst || fm.LastTS != wantLast {
+				t.Errorf("enabled=%v frame %d = (%d, %d), want (%d, %d)", enabled, i, fm.FirstTS, fm.LastTS, wantFirst, wantLast)
+			}
+		}
+		if s.count != flagged {
+			t.Errorf("enabled=%v count = %d, want %d", enabled, s.count, flagged)
+		}
+	}
+}
+
+func TestCheckTimestamps_Event(t *testing.T) {
+	var events []TimestampSkewEvent
+	cfg := Config{
+		SanitizeTimestamps: true,
+		OnTimestampSkew:    func(ev TimestampSkewEvent) { events = append(events, ev) },
+	}
+	s := tsSanitizer{enabled: cfg.SanitizeTimestamps}
+	future := time.Now().Add(time.Hour).UnixNano()
+
+	good := FrameMeta{Frame: 1, FirstTS: time.Now().Add(-time.Minute).UnixNano(), LastTS: time.Now().Add(-time.Minute).UnixNano()}
+	checkTimestamps(cfg, &s, &good)
+	bad := FrameMeta{Frame: 2, FirstTS: future, LastTS: future}
+	checkTimestamps(cfg, &s, &bad)
+	zero := FrameMeta{Frame: 3}
+	checkTimestamps(cfg, &s, &zero)
+
+	if len(events) != 2 {
+		t.Fatalf("events = %d, want 2", len(events))
+	}
+	if ev := events[0]; ev.Frame.Frame != 2 || ev.Frame.FirstTS != future || ev.Reason != TimestampFuture || !ev.Sanitized || ev.Count != 1 {
+		t.Errorf("first event = %+v", ev)
+	}
+	if ev := events[1]; ev.Frame.Frame != 3 || ev.Reason != TimestampZero || ev.Count != 2 {
+		t.Errorf("second event = %+v", ev)
+	}
+	if bad.FirstTS >= future || zero.FirstTS == 0 || zero.FirstTS < bad.LastTS {
+		t.Errorf("not sanitized: bad=%+v zero=%+v", bad, zero)
+	}
+}
@@ -0,0 +1,136 @@
+package agent
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+)
+
+// batchBody is the multipart body of a frames upload. It is written on
+// demand rather than held in a buffer, so a batch's compressed frames are
+// not copied a second time just to send them.
+type batchBody struct {
+	boundary string
+	parts    PartNames
+	manifest []byte
+	idxBase  string
+	frames   []batchFrame
+
+	// manifestType is the content type of manifest. A JSON manifest that
+	// is not gzipped is sent as a plain form field.
+	manifestType string
+	// manifestGzipped marks manifest as gzip-compressed, which is
+	// declared on its part.
+	manifestGzipped bool
+}
+
+func newBatchBody(parts PartNames, manifest []byte, idxBase string, frames []batchFrame) *batchBody {
+	return &batchBody{
+		// Fixed per body so every pass produces identical bytes
+		boundary: multipart.NewWriter(io.Discard).Boundary(),
+		parts:    parts,
+		manifest: manifest,
+		idxBase:  idxBase,
+		frames:   frames,
+	}
+}
+
+// ContentType returns the multipart/form-data content type, boundary included.
+func (b *batchBody) ContentType() string {
+	return "multipart/form-data; boundary=" + b.boundary
+}
+
+// WriteTo writes the full multipart body to w.
+func (b *batchBody) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	mw := multipart.NewWriter(cw)
+	if err := mw.SetBoundary(b.boundary); err != nil {
+		return cw.n, err
+	}
+	manifestPart, err := b.createManifestPart(mw)
+	if err != nil {
+		return cw.n, err
+	}
+	if _, err := manifestPart.Write(b.manifest); err != nil {
+		return cw.n, err
+	}
+	framesPart, err := mw.CreateFormFile(b.parts.Frames, b.idxBase)
+	if err != nil {
+		return cw.n, err
+	}
+	for _, fr := range b.frames {
+		if _, err := framesPart.Write(fr.Compressed); err != nil {
+			return cw.n, err
+		}
+	}
+	err = mw.Close()
+	return cw.n, err
+}
+
+// Checksum returns the SHA-256 of the batch's compressed frames,
+// concatenated in order, i.e. the content of the frames part.
+func (b *batchBody) Checksum() []byte {
+	h := sha256.New()
+	for _, fr := range b.frames {
+		h.Write(fr.Compressed)
+	}
+	return h.Sum(nil)
+}
+
+func (b *batchBody) createManifestPart(mw *multipart.Writer) (io.Writer, error) {
+	contentType := b.manifestType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	if !b.manifestGzipped && contentType == "application/json" {
+		return mw.CreateFormField(b.parts.Manifest)
+	}
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"`, b.parts.Manifest))
+	h.Set("Content-Type", contentType)
+	if b.manifestGzipped {
+		h.Set("Content-Encoding", "gzip")
+	}
+	return mw.CreatePart(h)
+}
+
+// Size returns the length of the body without materialising it, so the
+// upload can carry a Content-Length instead of being chunked.
+func (b *batchBody) Size() (int64, error) {
+	return b.WriteTo(io.Discard)
+}
+
+// Reader streams the body through a pipe. An error while writing is
+// returned from the reader's Read, and so surfaces from the HTTP call.
+// Closing the reader stops the writer.
+func (b *batchBody) Reader() io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := b.WriteTo(pw)
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// Bytes returns the body as a single buffer, for codecs that need it whole.
+func (b *batchBody) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,197 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"math/rand"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrySend_StreamsLargeBatch(t *testing.T) {
+	const frames, frameSize = 64, 256 << 10
+	rng := rand.New(rand.NewSource(1))
+	var batch []batchFrame
+	var want []byte
+	for i := 0; i < frames; i++ {
+		b := make([]byte, frameSize)
+		rng.Read(b)
+		want = append(want, b...)
+		batch = append(batch, batchFrame{Meta: FrameMeta{File: "seg-000001.wal.gz", Frame: uint64(i + 1), Len: frameSize}, Compressed: b, IdxLineLen: 10})
+	}
+
+	var (
+		gotLen    int64
+		gotFrames []byte
+		gotTE     []string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotLen, gotTE = r.ContentLength, r.TransferEncoding
+		mr, err := r.MultipartReader()
+		if err != nil {
+			t.Errorf("multipart reader: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for {
+			p, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Errorf("next part: %v", err)
+				return
+			}
+			if p.FormName() == "frames" {
+				gotFrames, _ = io.ReadAll(p)
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	cfg := Config{ServiceURL: ts.URL}
+	batchBytes := frames * frameSize
+	st := state{}
+	var ss sendState
+	if err := trySend(context.Background(), cfg, http.DefaultClient, &batch, &batchBytes, &st, &ss, "seg-000001.wal.idx", nil, false, newBackoff(time.Millisecond, time.Millisecond)); err != nil {
+		t.Fatalf("trySend() error = %v", err)
+	}
+	if !bytes.Equal(gotFrames, want) {
+		t.Errorf("frames part differs: got %d bytes, want %d", len(gotFrames), len(want))
+	}
+	// The precomputed size is sent rather than falling back to chunked
+	if gotLen <= int64(len(want)) || len(gotTE) != 0 {
+		t.Errorf("Content-Length = %d, Transfer-Encoding = %v; want a length over %d", gotLen, gotTE, len(want))
+	}
+}
+
+func TestBatchBody_SizeMatchesBody(t *testing.T) {
+	frames := []batchFrame{{Compressed: []byte("abc")}, {Compressed: []byte("defg")}}
+	b := newBatchBody(PartNames{}.withDefaults(), []byte(`[{"frame":1}]`), "x.idx", frames)
+	size, err := b.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc := b.Reader()
+	defer rc.Close()
+	raw, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(raw)) != size {
+		t.Errorf("streamed %d bytes, Size() = %d", len(raw), size)
+	}
+
+	_, params, _ := strings.Cut(b.ContentType(), "boundary=")
+	mr := multipart.NewReader(bytes.NewReader(raw), params)
+	var names []string
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, p.FormName())
+	}
+	if strings.Join(names, ",") != "manifest,frames" {
+		t.Errorf("parts = %v, want [manifest frames]", names)
+	}
+}
+
+func TestBatchBody_StreamingBoundsMemory(t *testing.T) {
+	const frames, frameSize = 128, 256 << 10
+	shared := make([]byte, frameSize)
+	batch := make([]batchFrame, frames)
+	for i := range batch {
+		batch[i] = batchFrame{Compressed: shared}
+	}
+	b := newBatchBody(PartNames{}.withDefaults(), []byte("[]"), "x.idx", batch)
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	rc := b.Reader()
+	n, err := io.Copy(io.Discard, rc)
+	rc.Close()
+	runtime.ReadMemStats(&after)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	total := int64(frames * frameSize)
+	if n <= total {
+		t.Fatalf("streamed %d bytes, want more than %d", n, total)
+	}
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > uint64(total/8) {
+		t.Errorf("streaming %d bytes allocated %d bytes", n, alloc)
+	}
+}
+
+func TestBatchBody_WriterErrorReachesRequest(t *testing.T) {
+	b := newBatchBody(PartNames{Frames: "frames", Manifest: "manifest"}, []byte("[]"), "x.idx", nil)
+	// An invalid boundary makes the writer fail before writing anything
+	b.boundary = strings.Repeat("x", 100)
+	rc := b.Reader()
+	defer rc.Close()
+	if _, err := io.ReadAll(rc); err == nil {
+		t.Fatal("expected the writer error from Read")
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+	}))
+	defer ts.Close()
+	req, _ := http.NewRequest(http.MethodPost, ts.URL, b.Reader())
+	if _, err := http.DefaultClient.Do(req); err == nil {
+		t.Fatal("expected the writer error from Do")
+	}
+}
+
+func TestTrySend_ContentChecksum(t *testing.T) {
+	batch := []batchFrame{
+		{Meta: FrameMeta{File: "seg-000001.wal.gz", Frame: 1}, Compressed: []byte("first frame"), IdxLineLen: 10},
+		{Meta: FrameMeta{File: "seg-000001.wal.gz", Frame: 2}, Compressed: []byte("second"), IdxLineLen: 10},
+	}
+	sum := sha256.Sum256([]byte("first framesecond"))
+	want := hex.EncodeToString(sum[:])
+
+	var got []string
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		got = append(got, r.Header.Get("X-Content-SHA256"))
+		// Fail the first attempt so the retry is checked too
+		if calls == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	cfg := Config{ServiceURL: ts.URL}
+	batchBytes := 17
+	st := state{}
+	var ss sendState
+	back := newBackoff(time.Millisecond, time.Millisecond)
+	if err := trySend(context.Background(), cfg, http.DefaultClient, &batch, &batchBytes, &st, &ss, "seg-000001.wal.idx", nil, true, back); err == nil {
+		t.Fatal("first trySend() error = nil, want 500 error")
+	}
+	if err := trySend(context.Background(), cfg, http.DefaultClient, &batch, &batchBytes, &st, &ss, "seg-000001.wal.idx", nil, true, back); err != nil {
+		t.Fatalf("retry trySend() error = %v", err)
+	}
+	if len(got) != 2 || got[0] != want || got[1] != want {
+		t.Errorf("X-Content-SHA256 = %v, want %s on both attempts", got, want)
+	}
+}
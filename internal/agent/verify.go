@@ -3,11 +3,63 @@ package agent
 import (
 	"bytes"
 	"compress/gzip"
+	"errors"
+	"fmt"
 	"hash/crc32"
 	"io"
 )
 
-// verifyFrame reads a gzip member and optionally checks CRC/line counts.
+// ErrFrameBounds is matched (via errors.Is) by the error returned when a
+// frame's index off/len do not delimit exactly one gzip member.
+var ErrFrameBounds = errors.New("frame bounds do not match a gzip member")
+
+type frameBoundsError struct {
+	File   string
+	Frame  uint64
+	Off    uint64
+	Len    uint64
+	Reason string
+}
+
+func (e *frameBoundsError) Error() string {
+	return fmt.Sprintf("%s frame %d [off=%d len=%d]: %v: %s", e.File, e.Frame, e.Off, e.Len, ErrFrameBounds, e.Reason)
+}
+
+func (e *frameBoundsError) Is(target error) bool { return target == ErrFrameBounds }
+
+// checkFrameBounds verifies that b, read from [off, off+len), starts with
+// the gzip magic and decompresses as a single member that consumes all of
+// b. Off-by-one index offsets can otherwise still yield "valid" gzip.
+func checkFrameBounds(fm FrameMeta, b []byte) error {
+	fail := func(format string, args ...any) error {
+		return &frameBoundsError{File: fm.File, Frame: fm.Frame, Off: fm.Off, Len: fm.Len, Reason: fmt.Sprintf(format, args...)}
+	}
+	if len(b) < 2 || b[0] != 0x1f || b[1] != 0x8b {
+		return fail("missing gzip magic")
+	}
+	// bytes.Reader is an io.ByteReader, so gzip reads no further than the
+	// end of the member and br.Len() is exactly what is left over.
+	br := bytes.NewReader(b)
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		return fail("gzip header: %v", err)
+	}
+	zr.Multistream(false)
+	if _, err := io.Copy(io.Discard, zr); err != nil {
+		return fail("decompress: %v", err)
+	}
+	if n := br.Len(); n > 0 {
+		return fail("%d trailing bytes after gzip member", n)
+	}
+	return nil
+}
+
+// ErrFrameChecksum is matched by the error returned when a frame's
+// decompressed data does not match the CRC32 in its index line.
+var ErrFrameChecksum = errors.New("frame checksum mismatch")
+
+// verifyFrame decompresses a gzip member and checks it against the CRC32
+// recorded in the index. Writers that leave crc32 at zero are not checked.
 func verifyFrame(fm FrameMeta, rc io.ReadCloser) error {
 	defer rc.Close()
 	zr, err := gzip.NewReader(rc)
@@ -32,8 +84,9 @@ func verifyFrame(fm FrameMeta, rc io.ReadCloser) error {
 			return err
 		}
 	}
-	// Optional checks; non-fatal in calling context.
 	_ = lines
-	_ = h.Sum32()
+	if fm.CRC32 != 0 && h.Sum32() != fm.CRC32 {
+		return fmt.Errorf("%s frame %d: %w: got %08x, index has %08x", fm.File, fm.Frame, ErrFrameChecksum, h.Sum32(), fm.CRC32)
+	}
 	return nil
 }
@@ -0,0 +1,192 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckFrameBounds(t *testing.T) {
+	dir := t.TempDir()
+	metas := writeTestSegment(t, dir, 1, "first frame\n", "second frame\n")
+	gz, err := os.ReadFile(filepath.Join(dir, "seg-000001.wal.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	slice := func(off, n uint64) []byte { return gz[off : off+n] }
+
+	for i, fm := range metas {
+		if err := checkFrameBounds(fm, slice(fm.Off, fm.Len)); err != nil {
+			t.Errorf("frame %d with correct bounds: %v", i+1, err)
+		}
+	}
+
+	first, second := metas[0], metas[1]
+	tests := []struct {
+		name string
+		fm   FrameMeta
+	}{
+		{"len one short", FrameMeta{File: first.File, Frame: 1, Off: first.Off, Len: first.Len - 1}},
+		{"len one long", FrameMeta{File: first.File, Frame: 1, Off: first.Off, Len: first.Len + 1}},
+		{"off one early", FrameMeta{File: second.File, Frame: 2, Off: second.Off - 1, Len: second.Len}},
+		{"off one late", FrameMeta{File: first.File, Frame: 1, Off: first.Off + 1, Len: first.Len}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkFrameBounds(tt.fm, slice(tt.fm.Off, tt.fm.Len))
+			if !errors.Is(err, ErrFrameBounds) {
+				t.Fatalf("checkFrameBounds() = %v, want ErrFrameBounds", err)
+			}
+			var fe *frameBoundsError
+			if !errors.As(err, &fe) || fe.Off != tt.fm.Off || fe.Len != tt.fm.Len {
+				t.Errorf("error %v should report off=%d len=%d", err, tt.fm.Off, tt.fm.Len)
+			}
+		})
+	}
+}
+
+func TestRun_StrictFrameBounds(t *testing.T) {
+	tmpDir := t.TempDir()
+	walDir := filepath.Join(tmpDir, "wal")
+	metas := writeTestSegment(t, walDir, 1, "f1\n", "f2\n")
+
+	// Rewrite the index with the first frame's length off by one.
+	bad := metas[0]
+	bad.Len++
+	writeIndex(t, filepath.Join(walDir, "seg-000001.wal.idx"), bad, metas[1])
+
+	cfg := Config{
+		ServiceURL:        "http://localhost:9999",
+		WALDir:            walDir,
+		StateDir:          filepath.Join(tmpDir, "state"),
+		PollInterval:      time.Millisecond,
+		SendInterval:      time.Hour,
+		HardInterval:      time.Hour,
+		StrictFrameBounds: true,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := Run(ctx, cfg); !errors.Is(err, ErrFrameBounds) {
+		t.Fatalf("Run() error = %v, want ErrFrameBounds", err)
+	}
+}
+
+// writeIndex overwrites an index file with one JSON line per frame.
+func writeIndex(t *testing.T, path string, metas ...FrameMeta) {
+	t.Helper()
+	var buf []byte
+	for _, fm := range metas {
+		line, err := json.Marshal(fm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+	if err := os.WriteFile(path, buf, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyFrame_Checksum(t *testing.T) {
+	dir := t.TempDir()
+	metas := writeTestSegment(t, dir, 1, "payload\n")
+	gz, err := os.ReadFile(filepath.Join(dir, "seg-000001.wal.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fm := metas[0]
+	if err := verifyFrame(fm, io.NopCloser(bytes.NewReader(gz))); err != nil {
+		t.Fatalf("verifyFrame() = %v, want nil", err)
+	}
+	fm.CRC32++
+	if err := verifyFrame(fm, io.NopCloser(bytes.NewReader(gz))); !errors.Is(err, ErrFrameChecksum) {
+		t.Fatalf("verifyFrame() = %v, want ErrFrameChecksum", err)
+	}
+	fm.CRC32 = 0
+	if err := verifyFrame(fm, io.NopCloser(bytes.NewReader(gz))); err != nil {
+		t.Errorf("verifyFrame() without crc32 = %v, want nil", err)
+	}
+}
+
+func TestVerifyWAL_CountsCorruptFrames(t *testing.T) {
+	tmpDir := t.TempDir()
+	walDir := filepath.Join(tmpDir, "wal")
+	day1 := filepath.Join(walDir, "2025-01-02")
+	day2 := filepath.Join(walDir, "2025-01-03")
+	metas := writeTestSegment(t, day1, 1, "a1\n", "a2\n", "a3\n")
+	writeTestSegment(t, day2, 1, "b1\n")
+
+	// The second frame's index crc32 no longer matches its data
+	bad := metas[1]
+	bad.CRC32++
+	writeIndex(t, filepath.Join(day1, "seg-000001.wal.idx"), metas[0], bad, metas[2])
+
+	cfg := Config{WALDir: walDir, StateDir: filepath.Join(tmpDir, "state")}
+	rep, err := VerifyWAL(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("VerifyWAL() error = %v", err)
+	}
+	var total int64
+	for _, fm := range metas {
+		total += int64(fm.Len)
+	}
+	gz2, err := os.Stat(filepath.Join(day2, "seg-000001.wal.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	total += gz2.Size()
+	want := VerifyReport{OK: 3, Corrupt: 1, Bytes: total}
+	if rep != want {
+		t.Errorf("VerifyWAL() = %+v, want %+v", rep, want)
+	}
+	if _, err := os.Stat(stateFile(cfg.StateDir)); !os.IsNotExist(err) {
+		t.Errorf("VerifyWAL wrote streaming state (stat err %v)", err)
+	}
+}
+
+func TestVerifyWAL_SkipsLikeRun(t *testing.T) {
+	walDir := t.TempDir()
+	metas := writeTestSegment(t, walDir, 1, "a1\n", "", "a3\n", "a4\n")
+	cfg := Config{
+		WALDir:          walDir,
+		SkipEmptyFrames: true,
+		FrameFilter:     func(fm FrameMeta) bool { return fm.Frame != 3 },
+	}
+	rep, err := VerifyWAL(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("VerifyWAL() error = %v", err)
+	}
+	want := VerifyReport{OK: 2, Skipped: 2, Bytes: int64(metas[0].Len + metas[3].Len)}
+	if rep != want {
+		t.Errorf("VerifyWAL() = %+v, want %+v", rep, want)
+	}
+}
+
+func TestVerifyWAL_DamagedData(t *testing.T) {
+	walDir := t.TempDir()
+	metas := writeTestSegment(t, walDir, 1, "first frame\n", "second frame\n")
+	gzPath := filepath.Join(walDir, "seg-000001.wal.gz")
+	gz, err := os.ReadFile(gzPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Flip a byte in the first frame's gzip trailer
+	gz[metas[0].Off+metas[0].Len-5] ^= 0xff
+	if err := os.WriteFile(gzPath, gz, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	rep, err := VerifyWAL(context.Background(), Config{WALDir: walDir})
+	if err != nil {
+		t.Fatalf("VerifyWAL() error = %v", err)
+	}
+	if rep.OK != 1 || rep.Corrupt != 1 {
+		t.Errorf("VerifyWAL() = %+v, want 1 ok and 1 corrupt", rep)
+	}
+}
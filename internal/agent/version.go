@@ -0,0 +1,43 @@
+package agent
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+const modulePath = "github.com/bft-labs/walship"
+
+// Version returns the walship module version from the binary's build info,
+// whether walship is the main module or a dependency of an embedder.
+// It returns "dev" when the version is unknown.
+func Version() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "dev"
+	}
+	if info.Main.Path == modulePath && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path != modulePath {
+			continue
+		}
+		if dep.Replace != nil && dep.Replace.Version != "" {
+			return dep.Replace.Version
+		}
+		if dep.Version != "" {
+			return dep.Version
+		}
+	}
+	return "dev"
+}
+
+// userAgent returns the User-Agent sent with every request: Config.UserAgent
+// if set, otherwise walship/<version> with the chain, node and platform.
+func userAgent(cfg *Config) string {
+	if cfg.UserAgent != "" {
+		return cfg.UserAgent
+	}
+	return fmt.Sprintf("walship/%s (chain=%s; node=%s; %s/%s)", Version(), cfg.ChainID, cfg.NodeID, runtime.GOOS, runtime.GOARCH)
+}
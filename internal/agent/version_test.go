@@ -0,0 +1,54 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUserAgent(t *testing.T) {
+	var got []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("User-Agent"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	send := func(cfg Config) {
+		t.Helper()
+		got = nil
+		batch := []batchFrame{{Meta: FrameMeta{File: "f", Frame: 1}, Compressed: []byte("x"), IdxLineLen: 10}}
+		batchBytes := 1
+		st := state{}
+		var ss sendState
+		if err := trySend(context.Background(), cfg, http.DefaultClient, &batch, &batchBytes, &st, &ss, "000.idx", nil, false, newBackoff(time.Millisecond, time.Millisecond)); err != nil {
+			t.Fatalf("trySend() error = %v", err)
+		}
+		if err := postSnapshot(context.Background(), http.DefaultClient, &cfg, ts.URL+configEndpoint, strings.NewReader("{}"), "application/json"); err != nil {
+			t.Fatalf("postSnapshot() error = %v", err)
+		}
+		if len(got) != 2 {
+			t.Fatalf("requests = %d, want 2", len(got))
+		}
+	}
+
+	send(Config{ServiceURL: ts.URL, ChainID: "chain-1", NodeID: "abc"})
+	want := regexp.MustCompile(`^walship/\S+ \(chain=chain-1; node=abc; ` + runtime.GOOS + `/` + runtime.GOARCH + `\)$`)
+	for _, ua := range got {
+		if !want.MatchString(ua) {
+			t.Errorf("User-Agent = %q, want match for %s", ua, want)
+		}
+	}
+
+	send(Config{ServiceURL: ts.URL, ChainID: "chain-1", NodeID: "abc", UserAgent: "custom/1.0"})
+	for _, ua := range got {
+		if ua != "custom/1.0" {
+			t.Errorf("User-Agent = %q, want the override", ua)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchFallbackInterval bounds how long the read loop waits for a WAL event
+// in watch mode before polling anyway, in case an event was missed.
+const watchFallbackInterval = 5 * time.Second
+
+// walWaiter blocks the read loop between polls at EOF. In watch mode it
+// wakes as soon as anything is written or created in the watched
+// directories; otherwise, or if fsnotify is unavailable, it just sleeps.
+type walWaiter struct {
+	watcher *fsnotify.Watcher
+	dirs    map[string]bool
+}
+
+// newWALWaiter returns a waiter that watches for WAL changes when watch is
+// true. Failure to set up fsnotify falls back to plain polling.
+func newWALWaiter(watch bool) *walWaiter {
+	w := &walWaiter{}
+	if !watch {
+		return w
+	}
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		logger.Warn().Err(err).Msg("wal watch unavailable; falling back to polling")
+		return w
+	}
+	w.watcher, w.dirs = fw, map[string]bool{}
+	return w
+}
+
+// Watch sets the directories to watch, typically the current segment's
+// directory (new index writes and segments) and the WAL root (new day
+// directories). Directories no longer listed are unwatched, except those
+// Wait saw created inside a listed one.
+func (w *walWaiter) Watch(dirs ...string) {
+	if w.watcher == nil {
+		return
+	}
+	want := make(map[string]bool, len(dirs))
+	for _, d := range dirs {
+		want[d] = true
+		if w.dirs[d] {
+			continue
+		}
+		if err := w.watcher.Add(d); err != nil {
+			logger.Debug().Err(err).Str("dir", d).Msg("wal watch: add directory")
+			continue
+		}
+		w.dirs[d] = true
+	}
+	for d := range w.dirs {
+		if !want[d] && !want[filepath.Dir(d)] {
+			_ = w.watcher.Remove(d)
+			delete(w.dirs, d)
+		}
+	}
+}
+
+// Wait blocks until a WAL change is seen (watch mode), poll elapses
+// (polling mode), or ctx is done.
+func (w *walWaiter) Wait(ctx context.Context, poll time.Duration) {
+	if w.watcher == nil {
+		select {
+		case <-ctx.Done():
+		case <-time.After(poll):
+		}
+		return
+	}
+	timeout := watchFallbackInterval
+	if poll > timeout {
+		timeout = poll
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			return
+		case ev, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if ev.Op&fsnotify.Create != 0 {
+				// A new day directory is usually created just before its
+				// first segment; watch it too or that segment is missed.
+				w.addCreatedDir(ev.Name)
+				return
+			}
+			if ev.Op&fsnotify.Write != 0 {
+				return
+			}
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			logger.Debug().Err(err).Msg("wal watch error")
+		}
+	}
+}
+
+func (w *walWaiter) addCreatedDir(path string) {
+	if w.dirs[path] {
+		return
+	}
+	if fi, err := os.Stat(path); err != nil || !fi.IsDir() {
+		return
+	}
+	if err := w.watcher.Add(path); err != nil {
+		logger.Debug().Err(err).Str("dir", path).Msg("wal watch: add directory")
+		return
+	}
+	w.dirs[path] = true
+}
+
+// Close releases the underlying watcher, if any.
+func (w *walWaiter) Close() {
+	if w.watcher != nil {
+		_ = w.watcher.Close()
+	}
+}
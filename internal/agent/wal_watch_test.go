@@ -0,0 +1,85 @@
+package agent
+
+import (
+	"context"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRun_WatchWALWakesOnNewSegments(t *testing.T) {
+	rec := &frameRecorder{}
+	ts := httptest.NewServer(rec)
+	defer ts.Close()
+
+	walDir := filepath.Join(t.TempDir(), "wal")
+	day1 := filepath.Join(walDir, "2026-01-01")
+	writeTestSegment(t, day1, 1, "f1\n")
+
+	// With a long poll interval, only fsnotify can make pickup fast.
+	poll := 3 * time.Second
+	cfg := Config{
+		ServiceURL:   ts.URL,
+		WALDir:       walDir,
+		StateDir:     filepath.Join(t.TempDir(), "state"),
+		PollInterval: poll,
+		SendInterval: time.Millisecond,
+		HardInterval: time.Millisecond,
+		WatchWAL:     true,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Run(ctx, cfg) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	waitFor := func(n int) time.Duration {
+		t.Helper()
+		start := time.Now()
+		for rec.count() < n {
+			if time.Since(start) > poll {
+				t.Fatalf("frame %d not shipped within the poll interval", n)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		return time.Since(start)
+	}
+	waitFor(1)
+	time.Sleep(50 * time.Millisecond) // let the reader go idle at EOF
+
+	// Rotation within the day.
+	writeTestSegment(t, day1, 2, "f2\n")
+	if d := waitFor(2); d > poll/2 {
+		t.Errorf("new segment picked up after %v, want well under %v", d, poll)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// Rotation to a new day directory.
+	writeTestSegment(t, filepath.Join(walDir, "2026-01-02"), 1, "f3\n")
+	if d := waitFor(3); d > poll/2 {
+		t.Errorf("new day picked up after %v, want well under %v", d, poll)
+	}
+}
+
+func TestWALWaiter_PollingFallback(t *testing.T) {
+	w := newWALWaiter(false)
+	defer w.Close()
+	w.Watch(t.TempDir()) // no-op without a watcher
+
+	start := time.Now()
+	w.Wait(context.Background(), 20*time.Millisecond)
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Errorf("polling Wait returned after %v, want the full poll interval", d)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start = time.Now()
+	w.Wait(ctx, time.Hour)
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Wait ignored cancelled context for %v", d)
+	}
+}
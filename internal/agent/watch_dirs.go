@@ -0,0 +1,69 @@
+package agent
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchRetryInterval is how often a watcher retries directories that are
+// missing, e.g. after the node home was reinitialised.
+const watchRetryInterval = 2 * time.Second
+
+// dirWatch keeps fsnotify watches on a fixed set of directories. A watch
+// silently ends when its directory is removed or renamed, so such
+// directories are marked missing and re-added by retry once they exist
+// again.
+type dirWatch struct {
+	w       *fsnotify.Watcher
+	dirs    []string
+	missing map[string]bool
+}
+
+// newDirWatch adds a watch for each of dirs. Directories that cannot be
+// watched yet are left for retry and returned with their errors.
+func newDirWatch(w *fsnotify.Watcher, dirs []string) (*dirWatch, map[string]error) {
+	d := &dirWatch{w: w, missing: map[string]bool{}}
+	failed := map[string]error{}
+	for _, dir := range dirs {
+		dir = filepath.Clean(dir)
+		d.dirs = append(d.dirs, dir)
+		if err := w.Add(dir); err != nil {
+			d.missing[dir] = true
+			failed[dir] = err
+		}
+	}
+	return d, failed
+}
+
+// lost reports whether ev removed one of the watched directories, and if
+// so marks it for retry.
+func (d *dirWatch) lost(ev fsnotify.Event) bool {
+	if ev.Op&(fsnotify.Remove|fsnotify.Rename) == 0 {
+		return false
+	}
+	name := filepath.Clean(ev.Name)
+	for _, dir := range d.dirs {
+		if dir == name {
+			// A renamed directory keeps its watch under the old path
+			_ = d.w.Remove(dir)
+			d.missing[dir] = true
+			return true
+		}
+	}
+	return false
+}
+
+// retry re-adds missing directories that exist again and returns those
+// now watched.
+func (d *dirWatch) retry() []string {
+	var back []string
+	for dir := range d.missing {
+		if err := d.w.Add(dir); err == nil {
+			delete(d.missing, dir)
+			back = append(back, dir)
+		}
+	}
+	return back
+}
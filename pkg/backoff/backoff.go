@@ -0,0 +1,95 @@
+// Package backoff computes exponential retry delays with optional jitter.
+package backoff
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// Backoff yields growing delays between retries: the first step is the
+// initial delay, each later step is the previous one times the multiplier,
+// capped at the maximum. It is not safe for concurrent use.
+type Backoff struct {
+	initial    time.Duration
+	max        time.Duration
+	multiplier float64
+	jitter     bool
+	cur        time.Duration
+}
+
+// Option configures a Backoff.
+type Option func(*Backoff)
+
+// WithMultiplier sets the growth factor between steps (default 2). Values
+// below 1 are ignored.
+func WithMultiplier(m float64) Option {
+	return func(b *Backoff) {
+		if m >= 1 {
+			b.multiplier = m
+		}
+	}
+}
+
+// WithJitter makes Next draw each delay uniformly from [0, current step]
+// ("full jitter"), so clients that failed together do not retry in
+// lockstep.
+func WithJitter() Option {
+	return func(b *Backoff) { b.jitter = true }
+}
+
+// New returns a Backoff stepping from initial to max. A max below initial
+// is raised to initial.
+func New(initial, max time.Duration, opts ...Option) *Backoff {
+	if max < initial {
+		max = initial
+	}
+	b := &Backoff{initial: initial, max: max, multiplier: 2}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// Initial returns the first step.
+func (b *Backoff) Initial() time.Duration { return b.initial }
+
+// Max returns the cap on a step.
+func (b *Backoff) Max() time.Duration { return b.max }
+
+// Next advances to the next step and returns the delay to wait.
+func (b *Backoff) Next() time.Duration {
+	if b.cur <= 0 {
+		b.cur = b.initial
+	} else {
+		next := time.Duration(float64(b.cur) * b.multiplier)
+		if next > b.max || next < b.cur {
+			next = b.max
+		}
+		b.cur = next
+	}
+	if b.jitter && b.cur > 0 {
+		return time.Duration(rand.Int63n(int64(b.cur) + 1))
+	}
+	return b.cur
+}
+
+// Sleep waits for the next delay. It returns ctx's error early if ctx is
+// cancelled first.
+func (b *Backoff) Sleep(ctx context.Context) error {
+	d := b.Next()
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// Reset starts the steps over from the initial delay.
+func (b *Backoff) Reset() { b.cur = 0 }
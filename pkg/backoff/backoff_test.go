@@ -0,0 +1,76 @@
+package backoff
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBackoff_GrowsToCap(t *testing.T) {
+	b := New(10*time.Millisecond, 80*time.Millisecond)
+	for i, want := range []time.Duration{10, 20, 40, 80, 80} {
+		if d := b.Next(); d != want*time.Millisecond {
+			t.Fatalf("step %d = %v, want %v", i, d, want*time.Millisecond)
+		}
+	}
+}
+
+func TestBackoff_Multiplier(t *testing.T) {
+	b := New(time.Second, time.Minute, WithMultiplier(1.5))
+	for i, want := range []time.Duration{1000, 1500, 2250} {
+		if d := b.Next(); d != want*time.Millisecond {
+			t.Fatalf("step %d = %v, want %v", i, d, want*time.Millisecond)
+		}
+	}
+}
+
+func TestBackoff_Reset(t *testing.T) {
+	b := New(time.Second, time.Minute)
+	b.Next()
+	b.Next()
+	b.Reset()
+	if d := b.Next(); d != time.Second {
+		t.Fatalf("after Reset = %v, want 1s", d)
+	}
+}
+
+func TestBackoff_MaxBelowInitial(t *testing.T) {
+	b := New(time.Second, time.Millisecond)
+	if b.Max() != time.Second {
+		t.Fatalf("Max() = %v, want 1s", b.Max())
+	}
+}
+
+func TestBackoff_JitterWithinBounds(t *testing.T) {
+	b := New(10*time.Millisecond, 80*time.Millisecond, WithJitter())
+	seen := map[time.Duration]bool{}
+	for i, step := range []time.Duration{10, 20, 40, 80, 80, 80, 80, 80} {
+		step *= time.Millisecond
+		d := b.Next()
+		if d < 0 || d > step {
+			t.Fatalf("attempt %d: delay %v outside [0, %v]", i, d, step)
+		}
+		seen[d] = true
+	}
+	if len(seen) < 2 {
+		t.Fatalf("expected jittered delays to vary, got %v", seen)
+	}
+}
+
+func TestBackoff_SleepHonoursContext(t *testing.T) {
+	b := New(time.Hour, time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := b.Sleep(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Sleep() error = %v, want context.DeadlineExceeded", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("Sleep returned after %v, want promptly on cancel", d)
+	}
+
+	b = New(time.Millisecond, time.Millisecond)
+	if err := b.Sleep(context.Background()); err != nil {
+		t.Fatalf("Sleep() error = %v", err)
+	}
+}
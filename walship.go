@@ -27,6 +27,37 @@ import (
 // Use DefaultConfig() to get a Config with sensible defaults.
 type Config = agent.Config
 
+// IdleEvent is passed to Config.OnIdle when the agent has been caught up,
+// with nothing new to ship, for at least Config.IdleThreshold.
+type IdleEvent = agent.IdleEvent
+
+// BatchCodec encodes the assembled multipart body of each batch before it
+// is sent and names the Content-Encoding to advertise. Set it on
+// Config.BatchCodec; the default is passthrough.
+type BatchCodec = agent.BatchCodec
+
+// ManifestEncoder serializes the manifest part of each upload and names
+// its content type. Set it on Config.ManifestEncoder; the default is JSON.
+type ManifestEncoder = agent.ManifestEncoder
+
+// JSONManifestEncoder encodes the manifest as a JSON array.
+type JSONManifestEncoder = agent.JSONManifestEncoder
+
+// CBORManifestEncoder encodes the manifest as CBOR, for ingests that
+// prefer a compact binary manifest.
+type CBORManifestEncoder = agent.CBORManifestEncoder
+
+// PartNames sets the multipart field names used for frame uploads.
+type PartNames = agent.PartNames
+
+// FrameTooLargeEvent is passed to Config.OnFrameTooLarge for each frame
+// skipped because it exceeds Config.MaxFrameBytes.
+type FrameTooLargeEvent = agent.FrameTooLargeEvent
+
+// SourceUnavailableEvent is passed to Config.OnSourceUnavailable when the
+// WAL directory disappears while the agent is running.
+type SourceUnavailableEvent = agent.SourceUnavailableEvent
+
 // FrameMeta contains metadata about a single WAL frame.
 // Fields are used to locate and read gzip members from the .gz file.
 type FrameMeta = agent.FrameMeta
@@ -38,19 +69,96 @@ func Run(ctx context.Context, cfg Config) error {
 	return agent.Run(ctx, cfg)
 }
 
+// Drain ships every frame currently on disk from the position saved in
+// cfg.StateDir, across segments and ExtraWALDirs, and returns once all are
+// confirmed; use it to decommission a node. ctx bounds it like Run.
+func Drain(ctx context.Context, cfg Config) error {
+	return agent.Drain(ctx, cfg)
+}
+
+// ShipRange ships segments from..to (inclusive) of one day directory under
+// cfg.WALDir once and returns the number of frames sent. It does not read
+// or update the state in cfg.StateDir, so it can re-ingest a window while
+// the streaming agent keeps its position.
+func ShipRange(ctx context.Context, cfg Config, day string, from, to int) (int, error) {
+	return agent.ShipRange(ctx, cfg, day, from, to)
+}
+
+// VerifyReport summarizes a VerifyWAL pass: frames that checked out,
+// frames that did not, and the compressed bytes read.
+type VerifyReport = agent.VerifyReport
+
+// ErrFrameChecksum is matched by the error for a frame whose data does not
+// match the crc32 in its index line.
+var ErrFrameChecksum = agent.ErrFrameChecksum
+
+// VerifyWAL decompresses and CRC-checks every frame under cfg's WAL
+// directories without sending anything or touching the state in
+// cfg.StateDir. Corrupt frames are counted in the report; the error is
+// for failures that stop the pass.
+func VerifyWAL(ctx context.Context, cfg Config) (VerifyReport, error) {
+	return agent.VerifyWAL(ctx, cfg)
+}
+
+// SegmentInfo describes one WAL segment on disk: its day directory,
+// number, file paths and sizes.
+type SegmentInfo = agent.SegmentInfo
+
+// ListSegments returns the segments under walDir in the order the agent
+// ships them, for tooling that needs to inspect the WAL layout.
+func ListSegments(walDir string) ([]SegmentInfo, error) {
+	return agent.ListSegments(walDir)
+}
+
+// RunMulti runs one independent agent per config in this process, e.g. for
+// several validators on one host. It blocks until every agent has returned;
+// a failing agent does not stop the others and its error is reported keyed
+// by chainID/nodeID. Each config must be validated and use its own StateDir.
+func RunMulti(ctx context.Context, cfgs []Config) error {
+	return agent.RunMulti(ctx, cfgs)
+}
+
 // DefaultConfig returns a Config with sensible default values.
 // At minimum, you must set NodeHome and AuthKey before calling Run.
 func DefaultConfig() Config {
 	return agent.DefaultConfig()
 }
 
+// ConfigBuilder assembles a validated Config starting from DefaultConfig.
+type ConfigBuilder = agent.ConfigBuilder
+
+// NewConfigBuilder returns a ConfigBuilder seeded with DefaultConfig, e.g.
+//
+//	cfg, err := walship.NewConfigBuilder().
+//	    WithNodeHome("/path/to/node").
+//	    WithAuth("your-api-key").
+//	    Build()
+func NewConfigBuilder() *ConfigBuilder {
+	return agent.NewConfigBuilder()
+}
+
+// NodeInfoResolver derives ChainID and NodeID from a node home directory.
+// Set Config.NodeInfoResolver for chains whose key or genesis format
+// differs from CometBFT's.
+type NodeInfoResolver = agent.NodeInfoResolver
+
+// TendermintResolver is the default NodeInfoResolver, reading
+// config/genesis.json and config/node_key.json.
+type TendermintResolver = agent.TendermintResolver
+
 // LoadNodeInfo extracts ChainID and NodeID from the node's configuration files.
-// It reads genesis.json for ChainID and node_key.json for NodeID.
+// It reads genesis.json for ChainID and node_key.json for NodeID, or asks
+// cfg.NodeInfoResolver when one is set.
 // This should be called after setting cfg.NodeHome and before Run.
 func LoadNodeInfo(cfg *Config) error {
 	return agent.LoadNodeInfo(cfg)
 }
 
+// Version returns the walship module version from build info, or "dev".
+func Version() string {
+	return agent.Version()
+}
+
 // Logger returns the package-level zerolog logger used by the agent.
 func Logger() zerolog.Logger {
 	return agent.Logger()
@@ -58,3 +166,121 @@ func Logger() zerolog.Logger {
 
 // DefaultServiceURL is the default endpoint for shipping WAL data.
 const DefaultServiceURL = agent.DefaultServiceURL
+
+// ErrMalformedIndexLine is matched by the error Run returns when an index
+// line cannot be decoded strictly. Set Config.LenientIndex to accept it.
+var ErrMalformedIndexLine = agent.ErrMalformedIndexLine
+
+// ErrFrameBounds is matched by the error Run returns when
+// Config.StrictFrameBounds is set and a frame's index off/len do not
+// delimit exactly one gzip member.
+var ErrFrameBounds = agent.ErrFrameBounds
+
+// Peek returns up to n frames the agent would ship next, starting at the
+// position saved in cfg.StateDir, without sending them or advancing that
+// position. Call it with a validated Config.
+func Peek(ctx context.Context, cfg Config, n int) ([]FrameMeta, error) {
+	return agent.Peek(ctx, cfg, n)
+}
+
+// Errors matched by the errors of failed uploads. Run returns an error
+// matching ErrUnauthorized when the service rejects the auth key.
+var (
+	ErrUnauthorized    = agent.ErrUnauthorized
+	ErrPayloadTooLarge = agent.ErrPayloadTooLarge
+	ErrRateLimited     = agent.ErrRateLimited
+)
+
+// ErrAlreadyLocked is matched by the error Run returns when another agent
+// already uses the same StateDir. Set Config.DisableLock to skip the lock.
+var ErrAlreadyLocked = agent.ErrAlreadyLocked
+
+// HTTPStatusError reports a non-2xx response from the service.
+type HTTPStatusError = agent.HTTPStatusError
+
+// ErrSnapshotVersion is matched by the error ImportState returns for a
+// snapshot in a format this version cannot read.
+var ErrSnapshotVersion = agent.ErrSnapshotVersion
+
+// ExportState returns the read position saved in stateDir as a versioned
+// snapshot, so an agent can be moved to another machine without copying
+// status.json by hand. Export while the agent is stopped.
+func ExportState(stateDir string) ([]byte, error) {
+	return agent.ExportState(stateDir)
+}
+
+// ImportState restores a snapshot from ExportState into stateDir. It
+// rejects snapshots of an unsupported version with ErrSnapshotVersion.
+func ImportState(stateDir string, data []byte) error {
+	return agent.ImportState(stateDir, data)
+}
+
+// Default bounds for the retry backoff after a failed send.
+const (
+	DefaultBackoffInitial = agent.DefaultBackoffInitial
+	DefaultBackoffMax     = agent.DefaultBackoffMax
+)
+
+// Lag is how far the committed read position trails the newest WAL index,
+// in index bytes and segments.
+type Lag = agent.Lag
+
+// LagMonitor reports Lag from the state persisted in a Config's StateDir.
+type LagMonitor = agent.LagMonitor
+
+// NewLagMonitor returns a LagMonitor for cfg. Call it with a validated
+// Config; Lag may be polled while Run is active, e.g. to alert when the
+// agent falls behind.
+func NewLagMonitor(cfg Config) *LagMonitor {
+	return agent.NewLagMonitor(cfg)
+}
+
+// SendLatency holds send duration percentiles from a LatencyRecorder.
+type SendLatency = agent.SendLatency
+
+// LatencyRecorder keeps the durations of recent successful sends. Set one
+// on Config.SendLatency and poll its Latency method while Run is active.
+type LatencyRecorder = agent.LatencyRecorder
+
+// DefaultLatencyWindow is the window of a LatencyRecorder created with zero.
+const DefaultLatencyWindow = agent.DefaultLatencyWindow
+
+// NewLatencyRecorder returns a LatencyRecorder over the last window sends.
+func NewLatencyRecorder(window int) *LatencyRecorder {
+	return agent.NewLatencyRecorder(window)
+}
+
+// OffsetDriftEvent is passed to Config.OnOffsetDrift when the index offset
+// the agent accounts for differs from the reader's real position.
+type OffsetDriftEvent = agent.OffsetDriftEvent
+
+// DNSErrorEvent is passed to Config.OnDNSError for each send that fails
+// because the service host does not resolve.
+type DNSErrorEvent = agent.DNSErrorEvent
+
+// SendErrorEvent is passed to Config.OnSendError after each failed batch
+// upload, numbering the attempt and carrying the run's retry totals.
+type SendErrorEvent = agent.SendErrorEvent
+
+// TimestampSkewEvent is passed to Config.OnTimestampSkew for each frame
+// whose timestamps are zero, in the future or not monotonic.
+type TimestampSkewEvent = agent.TimestampSkewEvent
+
+// ReadErrorEvent is passed to Config.OnReadError for each failure to read
+// the WAL.
+type ReadErrorEvent = agent.ReadErrorEvent
+
+// StateRecoveredEvent is passed to Config.OnStateRecovered when the saved
+// index was deleted and Run moved under Config.OnMissingState.
+type StateRecoveredEvent = agent.StateRecoveredEvent
+
+// Values for Config.OnMissingState.
+const (
+	MissingStateFail   = agent.MissingStateFail
+	MissingStateOldest = agent.MissingStateOldest
+	MissingStateLatest = agent.MissingStateLatest
+)
+
+// RestartEvent is passed to Config.OnRestart each time Run restarts itself
+// after Config.MaxLifetime.
+type RestartEvent = agent.RestartEvent